@@ -0,0 +1,638 @@
+package coap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+var (
+	ErrBlockETagChanged = errors.New("coap: etag changed during block transfer")
+
+	errBlockUnavailable = errors.New("block unavailable")
+)
+
+// StreamWriter 由支持流式响应的ResponseWriter实现, 参见ServeBody.
+type StreamWriter interface {
+	// SetBody 设置响应负载的来源, 替代Write写入的负载.
+	// size为负载长度, 未知时为-1, 已知时以Size2选项告知对端.
+	// 负载超过块大小时以Block2块传输按需逐块读取, 传输结束或超时后若body实现了io.Closer则被关闭.
+	SetBody(body io.Reader, size int64)
+}
+
+// ServeBody 以body作为响应负载, w未实现StreamWriter时将body全部写入w.
+func ServeBody(w ResponseWriter, body io.Reader, size int64) error {
+	if sw, ok := w.(StreamWriter); ok {
+		sw.SetBody(body, size)
+		return nil
+	}
+	if c, ok := body.(io.Closer); ok {
+		defer c.Close()
+	}
+	_, err := io.Copy(w, body)
+	return err
+}
+
+// bodyLength 返回body的长度, 未知时返回-1
+func bodyLength(body io.Reader) int64 {
+	switch b := body.(type) {
+	case interface{ Len() int }:
+		return int64(b.Len())
+	case *os.File:
+		if fi, err := b.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// blockRequest 返回以payload为负载的请求副本, token不为空时沿用该token
+func blockRequest(r *Request, token Token, payload []byte) *Request {
+	r2 := new(Request)
+	*r2 = *r
+	r2.Confirmable = true
+	r2.Options = r.Options.clone()
+	r2.Payload = payload
+	r2.Body = nil
+	if len(token) > 0 {
+		r2.Token, r2.useToken = token, true
+	}
+	return r2
+}
+
+// blockSize 返回请求的块大小, 不超过传输参数的最大块大小
+func (s *session) blockSize(r *Request) uint32 {
+	max := atomic.LoadUint32(&s.blockSizeV)
+	if isValidBlockSize(r.BlockSize) && r.BlockSize < max {
+		return r.BlockSize
+	}
+	return max
+}
+
+// postRequest 按请求的负载来源及块大小发送请求并等待响应
+func (s *session) postRequest(r *Request) (*Response, error) {
+	switch {
+	case r.Body != nil:
+		return s.postBlock1Request(r)
+	case r.BlockSize == 0:
+		return s.postRequestAndWaitResponse(r)
+	case len(r.Payload) > int(s.blockSize(r)):
+		r2 := new(Request)
+		*r2 = *r
+		r2.Body = bytes.NewReader(r.Payload)
+		r2.Payload = nil
+		return s.postBlock1Request(r2)
+	case r.Method == GET || r.Method == FETCH:
+		// 首个请求即携带Block2选项, 提前协商块大小
+		var buf bytes.Buffer
+		resp, err := s.postBlock2Request(r, &buf)
+		if err != nil {
+			return nil, err
+		}
+		resp.Payload = buf.Bytes()
+		return resp, nil
+	default:
+		return s.postRequestAndWaitResponse(r)
+	}
+}
+
+// postBlock1Request 按块读取r.Body并以Block1块传输发送, 内存中只保留当前块.
+// Body不超过一个块时作为普通请求发送.
+func (s *session) postBlock1Request(r *Request) (*Response, error) {
+	if c, ok := r.Body.(io.Closer); ok {
+		defer c.Close()
+	}
+	size := s.blockSize(r)
+	length := bodyLength(r.Body)
+	br := bufio.NewReaderSize(r.Body, int(size))
+
+	var token Token
+	var offset int64
+	for {
+		block := make([]byte, size)
+		n, err := io.ReadFull(br, block)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		block = block[:n]
+		_, err = br.Peek(1)
+		more := err == nil
+
+		if offset == 0 && !more {
+			req := blockRequest(r, "", block)
+			req.Confirmable = r.Confirmable
+			return s.postRequestAndWaitResponse(req)
+		}
+
+		req := blockRequest(r, token, block)
+		req.Options.Set(Block1, base.BlockOption{Num: uint32(offset / int64(size)), More: more, Size: size}.Value())
+		if length >= 0 {
+			req.Options.Set(Size1, uint32(length))
+		}
+		resp, err := s.postRequestAndWaitResponse(req)
+		if err != nil {
+			return nil, err
+		}
+		if !more || resp.Status != Continue {
+			return resp, nil
+		}
+
+		// 服务端可要求更小的块大小, 之后的块按新的大小划分
+		if opt, ok := resp.Options.Block1(); ok && opt.Size < size {
+			size = opt.Size
+		}
+		token = resp.Token
+		offset += int64(n)
+	}
+}
+
+// postBlock2Request 以Block2块传输逐块获取响应, 并将各块负载依次写入w.
+// 返回的响应不包含负载, 各块的ETag不一致时返回ErrBlockETagChanged.
+func (s *session) postBlock2Request(r *Request, w io.Writer) (*Response, error) {
+	size := s.blockSize(r)
+	var token Token
+	var etag [][]byte
+	var offset int64
+	for {
+		req := blockRequest(r, token, r.Payload)
+		req.Confirmable = r.Confirmable || offset > 0
+		req.Options.Set(Block2, base.BlockOption{Num: uint32(offset / int64(size)), Size: size}.Value())
+		resp, err := s.postRequestAndWaitResponse(req)
+		if err != nil {
+			return nil, err
+		}
+
+		opt, ok := resp.Options.Block2()
+		if !ok {
+			if offset > 0 {
+				return nil, fmt.Errorf("coap: block transfer interrupted: %s", resp.Status)
+			}
+			if _, err = w.Write(resp.Payload); err != nil {
+				return nil, err
+			}
+			resp.Payload = nil
+			return resp, nil
+		}
+		if int64(opt.Num)*int64(opt.Size) != offset {
+			return nil, fmt.Errorf("coap: unexpected block %d of size %d", opt.Num, opt.Size)
+		}
+		if offset == 0 {
+			etag = resp.Options.ETags()
+		} else if !etagEqual(etag, resp.Options.ETags()) {
+			return nil, ErrBlockETagChanged
+		}
+		if _, err = w.Write(resp.Payload); err != nil {
+			return nil, err
+		}
+		if !opt.More {
+			resp.Options.Del(Block2)
+			resp.Payload = nil
+			return resp, nil
+		}
+		size = opt.Size
+		token = resp.Token
+		offset += int64(len(resp.Payload))
+	}
+}
+
+func etagEqual(x, y [][]byte) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if !bytes.Equal(x[i], y[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// blockStream 服务端按需读取的响应负载
+type blockStream struct {
+	mu      sync.Mutex
+	access  time.Time
+	code    Code
+	options Options
+	body    io.Reader
+	reader  *bufio.Reader
+	size    int64
+	offset  int64 // 顺序读取的当前位置
+
+	// 最近读取的块, 用于响应重传的请求
+	last     []byte
+	lastOff  int64
+	lastMore bool
+}
+
+func newBlockStream(code Code, options Options, body io.Reader, size int64) *blockStream {
+	return &blockStream{
+		access:  time.Now(),
+		code:    code,
+		options: options,
+		body:    body,
+		size:    size,
+		lastOff: -1,
+	}
+}
+
+// read 读取偏移off处最多n字节的块, more表示块之后是否还有数据.
+// body实现了io.ReaderAt时可随机读取, 否则只能顺序读取, 已读过的块除最近一块外返回errBlockUnavailable.
+func (b *blockStream) read(off int64, n int) (block []byte, more bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.access = time.Now()
+
+	if ra, ok := b.body.(io.ReaderAt); ok {
+		buf := make([]byte, n+1)
+		k, err := ra.ReadAt(buf, off)
+		if k < len(buf) && err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		if k > n {
+			return buf[:n], true, nil
+		}
+		return buf[:k], false, nil
+	}
+
+	if off == b.lastOff && (len(b.last) == n || !b.lastMore) {
+		return b.last, b.lastMore, nil
+	}
+	if off < b.offset {
+		return nil, false, errBlockUnavailable
+	}
+	if b.reader == nil {
+		b.reader = bufio.NewReaderSize(b.body, n)
+	}
+	if off > b.offset {
+		k, err := io.CopyN(ioutil.Discard, b.reader, off-b.offset)
+		b.offset += k
+		if err != nil {
+			return nil, false, errBlockUnavailable
+		}
+	}
+	buf := make([]byte, n)
+	k, err := io.ReadFull(b.reader, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	b.offset += int64(k)
+	_, err = b.reader.Peek(1)
+	b.last, b.lastOff, b.lastMore = buf[:k], off, err == nil
+	return b.last, b.lastMore, nil
+}
+
+func (b *blockStream) expired(timeout time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Since(b.access) > timeout
+}
+
+func (b *blockStream) close() {
+	if c, ok := b.body.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// blockStreams 会话中进行中的Block2流式响应, 以请求方法及url为键
+type blockStreams struct {
+	mu      sync.Mutex
+	streams map[string]*blockStream
+}
+
+func (p *blockStreams) add(key string, b *blockStream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.streams == nil {
+		p.streams = make(map[string]*blockStream)
+	}
+	if old, ok := p.streams[key]; ok {
+		old.close()
+	}
+	p.streams[key] = b
+}
+
+func (p *blockStreams) get(key string) (*blockStream, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.streams[key]
+	return b, ok
+}
+
+func (p *blockStreams) del(key string, b *blockStream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.streams[key] == b {
+		delete(p.streams, key)
+	}
+	b.close()
+}
+
+func (p *blockStreams) update(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, b := range p.streams {
+		if b.expired(timeout) {
+			delete(p.streams, key)
+			b.close()
+		}
+	}
+}
+
+func (p *blockStreams) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, b := range p.streams {
+		delete(p.streams, key)
+		b.close()
+	}
+}
+
+// requestBlock2 返回请求的块的偏移及块大小, 块大小不超过会话的最大块大小
+func (s *session) requestBlock2(req *Request) (off int64, size uint32) {
+	size = s.params.MaxBlockSize
+	if opt, ok := req.Options.Block2(); ok {
+		off = int64(opt.Num) * int64(opt.Size)
+		if opt.Size < size {
+			size = opt.Size
+		}
+	}
+	return off, size
+}
+
+// serveBlock 由进行中的流式响应提供请求的后续块, 没有可用的流式响应时返回false
+func (s *session) serveBlock(req *Request, resp *response) bool {
+	off, size := s.requestBlock2(req)
+	if off == 0 {
+		return false
+	}
+	key := requestKey(req)
+	b, ok := s.streams.get(key)
+	if !ok {
+		return false
+	}
+	block, more, err := b.read(off, int(size))
+	if err == errBlockUnavailable || err == nil && len(block) == 0 {
+		s.streams.del(key, b)
+		return false
+	}
+	if err != nil {
+		s.logger().Log(LevelWarn, "read body", "token", base.TokenString(resp.token), "error", err)
+		s.streams.del(key, b)
+		resp.code = InternalServerError
+		return true
+	}
+	if !more {
+		s.streams.del(key, b)
+	}
+	resp.code = b.code
+	resp.options = b.options.clone()
+	s.writeBlock(resp, block, base.BlockOption{Num: uint32(off / int64(size)), More: more, Size: size}, b.size)
+	return true
+}
+
+// writeBody 读取Handler设置的响应负载中被请求的块, 负载未读完时登记流式响应以提供后续块
+func (s *session) writeBody(req *Request, resp *response) {
+	if resp.body == nil {
+		return
+	}
+	off, size := s.requestBlock2(req)
+	b := newBlockStream(resp.code, resp.options.clone(), resp.body, resp.bodySize)
+	resp.body = nil
+	block, more, err := b.read(off, int(size))
+	if err != nil {
+		s.logger().Log(LevelWarn, "read body", "token", base.TokenString(resp.token), "error", err)
+		b.close()
+		resp.code = InternalServerError
+		resp.options = nil
+		return
+	}
+	if off == 0 && !more {
+		b.close()
+		resp.buffer.Write(block)
+		return
+	}
+	if len(block) == 0 {
+		// 块号超出范围(RFC 7959 2.2)
+		b.close()
+		resp.code = BadOption
+		resp.options = nil
+		return
+	}
+	if more {
+		s.streams.add(requestKey(req), b)
+	} else {
+		b.close()
+	}
+	s.writeBlock(resp, block, base.BlockOption{Num: uint32(off / int64(size)), More: more, Size: size}, b.size)
+}
+
+func (s *session) writeBlock(resp *response, block []byte, opt base.BlockOption, size int64) {
+	resp.options.Set(Block2, opt.Value())
+	if size >= 0 {
+		resp.options.Set(Size2, uint32(size))
+	}
+	resp.buffer.Reset()
+	resp.buffer.Write(block)
+}
+
+// blockUpload 流式接收的Block1请求负载, 收到的块经Request.Body按顺序交给Handler,
+// 块被读完后才以Continue确认, 对端据此发送下一块, 不必在内存中组装完整的负载
+type blockUpload struct {
+	session *session
+	token   string
+	blockc  chan uploadBlock
+	donec   chan struct{}
+	once    sync.Once
+	err     error
+
+	// 以下字段只在读取Body的协程中访问
+	cur *uploadBlock
+	off int
+
+	// 以下字段只在running协程中访问
+	access    time.Time
+	messageID uint16           // 最近收到的块的消息ID
+	opt       base.BlockOption // 最近收到的块的Block1选项
+	pending   bool             // 最近收到的块是否尚未确认
+	resp      *response        // 先于下一块完成的响应, 附带在下一块的ACK中
+}
+
+type uploadBlock struct {
+	messageID uint16
+	opt       base.BlockOption
+	payload   []byte
+}
+
+func newBlockUpload(s *session, token string) *blockUpload {
+	return &blockUpload{
+		session: s,
+		token:   token,
+		blockc:  make(chan uploadBlock, 1),
+		donec:   make(chan struct{}),
+	}
+}
+
+// Read 读取收到的块, 块被读完后通知running协程确认该块
+func (u *blockUpload) Read(p []byte) (int, error) {
+	if u.cur == nil {
+		select {
+		case b := <-u.blockc:
+			u.cur = &b
+		case <-u.donec:
+			return 0, u.err
+		}
+	}
+	b := u.cur
+	if u.off == len(b.payload) && !b.opt.More {
+		return 0, io.EOF
+	}
+	n := copy(p, b.payload[u.off:])
+	u.off += n
+	if u.off == len(b.payload) && b.opt.More {
+		u.cur, u.off = nil, 0
+		u.session.post(func() { u.session.continueUpload(u, *b) })
+	}
+	return n, nil
+}
+
+// push 交付收到的块, 上一块尚未被读取时返回false
+func (u *blockUpload) push(m base.Message, opt base.BlockOption) bool {
+	select {
+	case u.blockc <- uploadBlock{messageID: m.MessageID, opt: opt, payload: m.Payload}:
+	default:
+		return false
+	}
+	u.access = time.Now()
+	u.messageID = m.MessageID
+	u.opt = opt
+	u.pending = true
+	return true
+}
+
+// close 结束读取, 尚未收到的块以err返回
+func (u *blockUpload) close(err error) {
+	u.once.Do(func() {
+		u.err = err
+		close(u.donec)
+	})
+}
+
+// newUpload 为开始Block1块传输的请求创建流式接收的负载, 不需要流式接收时返回nil
+func (s *session) newUpload(m base.Message) *blockUpload {
+	if !s.streamBody || m.Type != base.CON {
+		return nil
+	}
+	if opt, ok := base.ParseBlock1Option(m); !ok || opt.Num != 0 || !opt.More {
+		return nil
+	}
+	return newBlockUpload(s, m.Token)
+}
+
+// startUpload 登记流式接收的负载并交付第一块, 替代同一令牌进行中的请求
+func (s *session) startUpload(u *blockUpload, m base.Message) {
+	if u == nil {
+		return
+	}
+	if old, ok := s.uploads[u.token]; ok {
+		old.close(io.ErrUnexpectedEOF)
+	}
+	s.uploads[u.token] = u
+	opt, _ := base.ParseBlock1Option(m)
+	u.push(m, opt)
+}
+
+// recvUpload 将Block1请求的后续块交给进行中的请求
+func (s *session) recvUpload(m base.Message, opt base.BlockOption) {
+	u, ok := s.uploads[m.Token]
+	if !ok {
+		s.rejectRequest(m, RequestEntityIncomplete)
+		return
+	}
+	if !u.push(m, opt) {
+		// 对端未等待确认就发送了后续块
+		delete(s.uploads, u.token)
+		u.close(io.ErrUnexpectedEOF)
+		s.rejectRequest(m, RequestEntityIncomplete)
+		return
+	}
+	if r := u.resp; r != nil {
+		u.resp = nil
+		if err := s.sendResponse(r); err != nil {
+			s.logger().Log(LevelWarn, "send response", "token", base.TokenString(r.token), "error", err)
+		}
+	}
+}
+
+// continueUpload 在块被Handler读完后以Continue确认, 要求对端发送下一块
+func (s *session) continueUpload(u *blockUpload, b uploadBlock) {
+	if s.uploads[u.token] != u || !u.pending || u.messageID != b.messageID {
+		return
+	}
+	u.pending = false
+	opt := b.opt
+	// 块大小超过上限时, 以更小的块大小应答, 要求对端之后使用该大小
+	if opt.Size > s.params.MaxBlockSize {
+		opt.Size = s.params.MaxBlockSize
+	}
+	m := base.Message{
+		Type:      base.ACK,
+		Code:      uint8(Continue),
+		MessageID: b.messageID,
+		Token:     u.token,
+	}
+	m.SetOption(base.Block1, opt.Value())
+	if err := s.sendMessage(m); err != nil {
+		s.logger().Log(LevelWarn, "send continue", "token", base.TokenString(u.token), "error", err)
+	}
+}
+
+// finishUpload 结束流式接收的请求, 响应附带在最近收到的块的ACK中.
+// 收到的块都已确认时响应留待下一块到达后发送, 返回false
+func (s *session) finishUpload(r *response) bool {
+	u := r.upload
+	if s.uploads[u.token] != u {
+		// 请求已超时或被替代, 没有可附带响应的块
+		r.upload = nil
+		r.needAck = false
+		return true
+	}
+	if !u.pending {
+		u.resp = r
+		return false
+	}
+	r.upload = nil
+	r.messageID = u.messageID
+	if u.opt.More {
+		// 在最后一块之前结束块传输, 最后一块的Block1选项由块传输层回复
+		r.options.Set(Block1, u.opt.Value())
+	}
+	delete(s.uploads, u.token)
+	u.close(io.ErrUnexpectedEOF)
+	return true
+}
+
+// updateUploads 结束长时间没有收到块的请求
+func (s *session) updateUploads(timeout time.Duration) {
+	for token, u := range s.uploads {
+		if time.Since(u.access) > timeout {
+			delete(s.uploads, token)
+			u.close(ErrTimeout)
+		}
+	}
+}
+
+func (s *session) closeUploads() {
+	for token, u := range s.uploads {
+		delete(s.uploads, token)
+		u.close(ErrSessionClosed)
+	}
+}
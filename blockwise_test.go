@@ -0,0 +1,348 @@
+package coap_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ironzhang/coap"
+)
+
+// onlyReader 隐藏io.ReaderAt等接口, 只能顺序读取
+type onlyReader struct {
+	io.Reader
+}
+
+func TestBlockwiseStreaming(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 300)[:4500]
+
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		switch r.URL.Path {
+		case "/upload":
+			if b, err := ioutil.ReadAll(r.Body); err != nil || !bytes.Equal(b, data) {
+				w.WriteCode(coap.BadRequest)
+				return
+			}
+			w.WriteCode(coap.Changed)
+			if size1 := r.Options.Get(coap.Size1); size1 != nil {
+				w.Options().Set(coap.Size1, size1)
+			}
+		case "/sequential":
+			coap.ServeBody(w, onlyReader{bytes.NewReader(data)}, -1)
+		case "/random":
+			coap.ServeBody(w, bytes.NewReader(data), int64(len(data)))
+		case "/small":
+			coap.ServeBody(w, strings.NewReader("small"), 5)
+		}
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h}).Serve("coap", ln)
+
+	client := &coap.Client{}
+	urlstr := "coap://" + ln.LocalAddr().String()
+
+	// 上传
+	req, err := coap.NewRequest(true, coap.PUT, urlstr+"/upload", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Body = onlyReader{bytes.NewReader(data)}
+	resp, err := client.SendRequest(req)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if got, want := resp.Status, coap.Changed; got != want {
+		t.Errorf("upload: status: %v != %v", got, want)
+	}
+	if got := resp.Options.Get(coap.Size1); got != nil {
+		t.Errorf("upload: size1: %v != nil", got)
+	}
+	req.Body = bytes.NewReader(data)
+	if resp, err = client.SendRequest(req); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if got, want := resp.Options.Get(coap.Size1), uint32(len(data)); got != want {
+		t.Errorf("upload: size1: %v != %v", got, want)
+	}
+
+	// 下载
+	tests := []struct {
+		path    string
+		payload []byte
+		size2   interface{}
+	}{
+		{path: "/sequential", payload: data, size2: nil},
+		{path: "/random", payload: data, size2: uint32(len(data))},
+		{path: "/small", payload: []byte("small"), size2: nil},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, coap.GET, urlstr+tt.path, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		var buf bytes.Buffer
+		resp, err := client.Download(req, &buf)
+		if err != nil {
+			t.Fatalf("case%d: download: %v", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), tt.payload) {
+			t.Errorf("case%d: download: payload length: %d != %d", i, buf.Len(), len(tt.payload))
+		}
+		if got, want := resp.Options.Get(coap.Size2), tt.size2; got != want {
+			t.Errorf("case%d: download: size2: %v != %v", i, got, want)
+		}
+
+		// 由协议栈自动重组
+		resp, err = client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if !bytes.Equal(resp.Payload, tt.payload) {
+			t.Errorf("case%d: send request: payload length: %d != %d", i, len(resp.Payload), len(tt.payload))
+		}
+	}
+}
+
+// countReader 统计已被读取的字节数
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func TestBlockwiseUploadStreaming(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	body := &countReader{r: bytes.NewReader(data)}
+
+	type result struct {
+		payload []byte
+		read    int64
+		lead    int64 // 客户端已发送而Handler尚未读取的最大字节数
+		err     error
+	}
+	results := make(chan result, 1)
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		res := result{payload: r.Payload}
+		buf := make([]byte, 300)
+		limit := int64(len(data))
+		if r.URL.Path == "/reject" {
+			limit = 1024
+		}
+		for res.read < limit {
+			n, err := r.Body.Read(buf)
+			if n > 0 && !bytes.Equal(buf[:n], data[res.read:res.read+int64(n)]) {
+				res.err = fmt.Errorf("payload mismatch at %d", res.read)
+				break
+			}
+			res.read += int64(n)
+			if lead := atomic.LoadInt64(&body.n) - res.read; lead > res.lead {
+				res.lead = lead
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				res.err = err
+				break
+			}
+		}
+		results <- res
+		if r.URL.Path == "/reject" {
+			w.WriteCode(coap.Forbidden)
+			return
+		}
+		w.WriteCode(coap.Changed)
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	server := &coap.Server{Handler: h}
+	go server.Serve("coap", ln)
+
+	client := &coap.Client{}
+	urlstr := "coap://" + ln.LocalAddr().String()
+
+	tests := []struct {
+		path     string
+		status   coap.Code
+		read     int64
+		requests uint64
+	}{
+		{path: "/upload", status: coap.Changed, read: int64(len(data)), requests: uint64(len(data) / 1024)},
+		// Handler提前结束, 响应附带在下一块的ACK中
+		{path: "/reject", status: coap.Forbidden, read: 1024, requests: 2},
+	}
+	for i, tt := range tests {
+		before := server.Stats().MessagesIn.Types["CON"]
+		body.r = bytes.NewReader(data)
+		atomic.StoreInt64(&body.n, 0)
+		req, err := coap.NewRequest(true, coap.PUT, urlstr+tt.path, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		req.Body = onlyReader{body}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: upload: %v", i, err)
+		}
+		res := <-results
+		if res.err != nil {
+			t.Errorf("case%d: read body: %v", i, res.err)
+		}
+		if res.payload != nil {
+			t.Errorf("case%d: payload: %d bytes != nil", i, len(res.payload))
+		}
+		if got, want := res.read, tt.read; got != want {
+			t.Errorf("case%d: read: %d != %d", i, got, want)
+		}
+		// 块被读取后才确认, 客户端至多领先Handler数个块
+		if res.lead > 4*1024 {
+			t.Errorf("case%d: lead: %d bytes", i, res.lead)
+		}
+		if got, want := resp.Status, tt.status; got != want {
+			t.Errorf("case%d: status: %v != %v", i, got, want)
+		}
+		if got, want := server.Stats().MessagesIn.Types["CON"]-before, tt.requests; got != want {
+			t.Errorf("case%d: requests: %d != %d", i, got, want)
+		}
+	}
+}
+
+func TestBlockSizeNegotiation(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20)
+
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		switch r.Method {
+		case coap.GET:
+			w.Write(data)
+		case coap.PUT:
+			n, _ := io.Copy(ioutil.Discard, r.Body)
+			w.WriteCode(coap.Changed)
+			fmt.Fprintf(w, "%d", n)
+		}
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	server := &coap.Server{
+		Handler:            h,
+		TransmissionParams: &coap.TransmissionParams{MaxBlockSize: 64},
+		MaxRequestBodySize: 1000,
+	}
+	go server.Serve("coap", ln)
+
+	client := &coap.Client{TransmissionParams: &coap.TransmissionParams{MaxBlockSize: 128}}
+	urlstr := "coap://" + ln.LocalAddr().String() + "/data"
+
+	tests := []struct {
+		method    coap.Code
+		blockSize uint32
+		body      io.Reader
+		size2     bool
+		status    coap.Code
+		payload   string
+		size      interface{}
+		requests  uint64
+	}{
+		// 服务端限定最大块大小
+		{method: coap.GET, status: coap.Content, payload: string(data), requests: 5},
+		// 提前协商块大小
+		{method: coap.GET, blockSize: 32, status: coap.Content, payload: string(data), requests: 10},
+		// 要求Size2
+		{method: coap.GET, size2: true, status: coap.Content, payload: string(data[:64]), size: uint32(len(data)), requests: 1},
+		// 服务端要求更小的块
+		{method: coap.PUT, body: bytes.NewReader(data), status: coap.Changed, payload: "320", requests: 4},
+		{method: coap.PUT, blockSize: 16, body: bytes.NewReader(data), status: coap.Changed, payload: "320", requests: 20},
+		// 超过请求负载上限
+		{method: coap.PUT, body: bytes.NewReader(make([]byte, 1200)), status: coap.RequestEntityTooLarge, size: uint32(1000), requests: 1},
+		{method: coap.PUT, body: onlyReader{bytes.NewReader(make([]byte, 1200))}, status: coap.RequestEntityTooLarge, size: uint32(1000), requests: 15},
+	}
+	for i, tt := range tests {
+		before := server.Stats().MessagesIn.Types["CON"]
+		req, err := coap.NewRequest(true, tt.method, urlstr, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		req.BlockSize = tt.blockSize
+		req.Body = tt.body
+		if tt.size2 {
+			req.Options.Set(coap.Block2, uint32(2))
+			req.Options.Set(coap.Size2, uint32(0))
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := resp.Status, tt.status; got != want {
+			t.Errorf("case%d: status: %v != %v", i, got, want)
+		}
+		if got, want := string(resp.Payload), tt.payload; got != want {
+			t.Errorf("case%d: payload: %q != %q", i, got, want)
+		}
+		if tt.size != nil {
+			id := uint16(coap.Size1)
+			if tt.method == coap.GET {
+				id = coap.Size2
+			}
+			if got, want := resp.Options.Get(id), tt.size; got != want {
+				t.Errorf("case%d: size: %v != %v", i, got, want)
+			}
+		}
+		if got, want := server.Stats().MessagesIn.Types["CON"]-before, tt.requests; got != want {
+			t.Errorf("case%d: requests: %d != %d", i, got, want)
+		}
+	}
+}
+
+func TestLargerBlockSizeRequest(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 3000; i++ {
+		fmt.Fprintf(&buf, "%05d,", i)
+	}
+	data := buf.Bytes()
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.Write(data)
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	server := &coap.Server{Handler: h, TransmissionParams: &coap.TransmissionParams{MaxBlockSize: 512}}
+	go server.Serve("coap", ln)
+
+	// 请求的块大小超过服务端上限, 按上限换算块号
+	client := &coap.Client{}
+	req, err := coap.NewRequest(true, coap.POST, "coap://"+ln.LocalAddr().String()+"/data", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := client.FetchBlock(req, 1, 1024)
+	if err != nil {
+		t.Fatalf("fetch block: %v", err)
+	}
+	if got, want := string(resp.Payload), string(data[1024:1536]); got != want {
+		t.Errorf("payload: %q != %q", got, want)
+	}
+	if got, ok := resp.Options.Block2(); !ok || got != (coap.BlockOption{Num: 2, More: true, Size: 512}) {
+		t.Errorf("block2: %v %t", got, ok)
+	}
+}
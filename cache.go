@@ -1,8 +1,12 @@
 package coap
 
 import (
+	"bytes"
+	"container/list"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +17,33 @@ func requestKey(req *Request) string {
 	return fmt.Sprintf("%s %s", req.Method.String(), req.URL.String())
 }
 
+// cacheKey 返回请求的缓存键(RFC 7252 5.6), 由方法、url及除NoCacheKey选项外的所有选项组成,
+// FETCH请求还包括负载(RFC 8132 2.1). Uri-*选项已包含在url中, 同编号的选项保持原有顺序.
+func cacheKey(req *Request) string {
+	options := cloneOptionsExclude(req.Options, func(o base.Option) bool {
+		return base.NoCacheKey(o.ID) || uriOptions[o.ID]
+	})
+	sort.SliceStable(options, func(i, j int) bool { return options[i].ID < options[j].ID })
+
+	var b strings.Builder
+	b.WriteString(requestKey(req))
+	for _, o := range options {
+		fmt.Fprintf(&b, ";%d=%x", o.ID, o.Value)
+	}
+	if req.Method == FETCH {
+		fmt.Fprintf(&b, ";payload=%x", req.Payload)
+	}
+	return b.String()
+}
+
+// uriOptions 组成请求url的选项
+var uriOptions = map[uint16]bool{
+	URIHost:  true,
+	URIPort:  true,
+	URIPath:  true,
+	URIQuery: true,
+}
+
 func isCacheStatus(status Code) bool {
 	if status == Content {
 		return true
@@ -24,6 +55,11 @@ func isCacheStatus(status Code) bool {
 	return false
 }
 
+// isSafeMethod 判断请求方法是否为安全方法, 只有安全方法的响应可以缓存
+func isSafeMethod(method Code) bool {
+	return method == GET || method == FETCH
+}
+
 func cloneOptionsExclude(src Options, exclude func(o base.Option) bool) Options {
 	dst := make(Options, 0, len(src))
 	for _, o := range src {
@@ -41,69 +77,270 @@ func optionsEqual(x, y Options) bool {
 	return reflect.DeepEqual(x, y)
 }
 
-type cvalue struct {
-	req     *Request
-	resp    *Response
-	start   time.Time
-	timeout time.Duration
+// CacheEntry 缓存的响应
+type CacheEntry struct {
+	Request  *Request
+	Response *Response
+	Stored   time.Time     // 保存或重新验证的时间
+	MaxAge   time.Duration // 有效时间
 }
 
-type cache struct {
-	mu     sync.Mutex
-	values map[string]cvalue
+// URI 返回缓存响应对应的请求url
+func (e *CacheEntry) URI() string {
+	return e.Request.URL.String()
 }
 
-func (c *cache) Get(req *Request) (*Response, bool) {
-	key := requestKey(req)
-	value, ok := c.getValue(key)
-	if !ok {
-		return nil, false
+// Fresh 判断缓存响应是否仍在有效期内
+func (e *CacheEntry) Fresh() bool {
+	return time.Since(e.Stored) <= e.MaxAge
+}
+
+// maxAge 返回剩余的有效时间, 单位为秒
+func (e *CacheEntry) maxAge() uint32 {
+	d := e.MaxAge - time.Since(e.Stored)
+	if d < 0 {
+		return 0
+	}
+	return uint32(d / time.Second)
+}
+
+// size 返回缓存项占用的近似字节数
+func (e *CacheEntry) size(key string) int64 {
+	n := int64(len(key) + len(e.Response.Payload))
+	for _, o := range e.Response.Options {
+		switch v := o.Value.(type) {
+		case []byte:
+			n += int64(len(v))
+		case string:
+			n += int64(len(v))
+		}
+		n += 4
+	}
+	return n
+}
+
+// Cache 响应缓存, 可由多个Client、Server及Proxy共享, 实现须可被多个协程并发使用.
+// 已过期但带有ETag的响应仍然由Get返回, 以便重新验证.
+type Cache interface {
+	Get(key string) (*CacheEntry, bool)
+	Add(key string, e *CacheEntry)
+	Remove(key string)
+
+	// Invalidate 删除uri的所有缓存响应, 在以不安全的方法访问该uri后调用
+	Invalidate(uri string)
+}
+
+// NoCache 不缓存任何响应的Cache
+var NoCache Cache = nopCache{}
+
+type nopCache struct{}
+
+func (nopCache) Get(string) (*CacheEntry, bool) { return nil, false }
+func (nopCache) Add(string, *CacheEntry)        {}
+func (nopCache) Remove(string)                  {}
+func (nopCache) Invalidate(string)              {}
+
+// 默认缓存的容量, 用于未设置Cache的Client及Server
+const (
+	DefaultCacheEntries = 256
+	DefaultCacheBytes   = 1 << 20
+)
+
+// LRUCache 限制缓存项数及字节数的Cache, 超过限制时淘汰最久未使用的响应
+type LRUCache struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List
+	items map[string]*list.Element
+	uris  map[string]map[string]struct{}
+}
+
+type lruItem struct {
+	key   string
+	entry *CacheEntry
+	size  int64
+}
+
+// NewLRUCache 创建LRUCache, maxEntries及maxBytes为0时不限制
+func NewLRUCache(maxEntries int, maxBytes int64) *LRUCache {
+	return &LRUCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		uris:       make(map[string]map[string]struct{}),
 	}
-	if !optionsEqual(req.Options, value.req.Options) {
+}
+
+func (c *LRUCache) Get(key string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
 		return nil, false
 	}
-	return value.resp, true
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruItem).entry, true
 }
 
-func (c *cache) Add(req *Request, resp *Response) {
-	if isCacheStatus(resp.Status) {
-		key := requestKey(req)
-		age, ok := resp.Options.Get(MaxAge).(uint32)
-		if !ok {
-			age = 60
-		}
-		c.addValue(key, cvalue{
-			req:     req,
-			resp:    resp,
-			start:   time.Now(),
-			timeout: time.Duration(age) * time.Second,
-		})
+func (c *LRUCache) Add(key string, entry *CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	item := &lruItem{key: key, entry: entry, size: entry.size(key)}
+	if c.maxBytes > 0 && item.size > c.maxBytes {
+		return
+	}
+	c.items[key] = c.ll.PushFront(item)
+	c.bytes += item.size
+	uri := entry.URI()
+	if c.uris[uri] == nil {
+		c.uris[uri] = make(map[string]struct{})
+	}
+	c.uris[uri][key] = struct{}{}
+
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.ll.Back().Value.(*lruItem).key)
 	}
 }
 
-func (c *cache) addValue(key string, value cvalue) {
+func (c *LRUCache) Remove(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if value.timeout > 0 {
-		if c.values == nil {
-			c.values = make(map[string]cvalue)
-		}
-		c.values[key] = value
-	} else {
-		delete(c.values, key)
+	c.remove(key)
+}
+
+func (c *LRUCache) Invalidate(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.uris[uri] {
+		c.remove(key)
 	}
 }
 
-func (c *cache) getValue(key string) (cvalue, bool) {
+// Len 返回缓存项数
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Bytes 返回缓存项占用的近似字节数
+func (c *LRUCache) Bytes() int64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	value, ok := c.values[key]
+	return c.bytes
+}
+
+func (c *LRUCache) remove(key string) {
+	e, ok := c.items[key]
+	if !ok {
+		return
+	}
+	item := e.Value.(*lruItem)
+	c.ll.Remove(e)
+	delete(c.items, key)
+	c.bytes -= item.size
+	uri := item.entry.URI()
+	delete(c.uris[uri], key)
+	if len(c.uris[uri]) == 0 {
+		delete(c.uris, uri)
+	}
+}
+
+// cache 按RFC 7252的规则使用Cache保存及查找响应, Cache为nil时不缓存
+type cache struct {
+	Cache
+}
+
+// Get 查找新鲜的缓存响应
+func (c cache) Get(req *Request) (*Response, bool) {
+	e, ok := c.lookup(req)
+	if !ok || !e.Fresh() {
+		return nil, false
+	}
+	return e.Response.clone(), true
+}
+
+// Add 保存安全方法的可缓存响应的副本, 以Max-Age选项为有效时间, 缺省为60秒
+func (c cache) Add(req *Request, resp *Response) {
+	if c.Cache == nil || !isSafeMethod(req.Method) || !isCacheStatus(resp.Status) {
+		return
+	}
+	key := cacheKey(req)
+	age, ok := resp.Options.MaxAge()
 	if !ok {
-		return cvalue{}, false
+		age = 60
 	}
-	if time.Since(value.start) > value.timeout {
-		delete(c.values, key)
-		return cvalue{}, false
+	if age == 0 {
+		c.Cache.Remove(key)
+		return
+	}
+	c.Cache.Add(key, &CacheEntry{
+		Request:  req,
+		Response: resp.clone(),
+		Stored:   time.Now(),
+		MaxAge:   time.Duration(age) * time.Second,
+	})
+}
+
+// lookup 查找缓存的响应, 已过期但带有ETag的响应仍然返回, 以便重新验证
+func (c cache) lookup(req *Request) (*CacheEntry, bool) {
+	if c.Cache == nil || !isSafeMethod(req.Method) {
+		return nil, false
+	}
+	key := cacheKey(req)
+	e, ok := c.Cache.Get(key)
+	if !ok || !optionsEqual(req.Options, e.Request.Options) || !bytes.Equal(req.Payload, e.Request.Payload) {
+		return nil, false
+	}
+	if !e.Fresh() && !e.Response.Options.Contain(ETag) {
+		c.Cache.Remove(key)
+		return nil, false
+	}
+	return e, true
+}
+
+// revalidate 以2.03 Valid响应更新缓存响应的有效期及选项, 返回更新后的响应
+func (c cache) revalidate(req *Request, valid *Response) (*Response, bool) {
+	e, ok := c.lookup(req)
+	if !ok {
+		return nil, false
+	}
+	// 2.03 Valid的ETag与缓存响应的ETag不一致时, 缓存响应不能继续使用
+	if etags := valid.Options.ETags(); len(etags) > 0 {
+		if cached := e.Response.Options.ETags(); len(cached) == 0 || !bytes.Equal(etags[0], cached[0]) {
+			return nil, false
+		}
+	}
+	resp := e.Response.clone()
+	if !valid.Options.Contain(MaxAge) {
+		resp.Options.Del(MaxAge)
+	}
+	for _, o := range valid.Options {
+		resp.Options.Set(o.ID, o.Value)
+	}
+	c.Add(e.Request, resp)
+	return resp, true
+}
+
+// invalidate 以不安全的方法成功访问url后, 删除该url的所有缓存响应
+func (c cache) invalidate(req *Request, resp *Response) {
+	if c.Cache == nil || isSafeMethod(req.Method) || resp.Status>>5 != 2 {
+		return
+	}
+	c.Cache.Invalidate(req.URL.String())
+}
+
+// validationRequest 返回附带缓存响应的ETag的请求副本, 用于重新验证已过期的缓存响应
+func validationRequest(req *Request, cached *Response) *Request {
+	v := *req
+	v.Options = req.Options.clone()
+	for _, etag := range cached.Options.ETags() {
+		v.Options.Add(ETag, etag)
 	}
-	return value, true
+	return &v
 }
@@ -1,8 +1,11 @@
 package coap
 
 import (
+	"net"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ironzhang/coap/internal/stack/base"
 )
@@ -181,11 +184,11 @@ func TestCache(t *testing.T) {
 		{
 			req:    req3,
 			resp:   &Response{Status: BadRequest, Payload: []byte("bad request")},
-			cached: true,
+			cached: false,
 		},
 	}
 
-	var c cache
+	c := cache{NewLRUCache(0, 0)}
 	for _, tt := range tests {
 		c.Add(tt.req, tt.resp)
 	}
@@ -201,3 +204,200 @@ func TestCache(t *testing.T) {
 		}
 	}
 }
+
+func TestCacheKey(t *testing.T) {
+	newRequest := func(options ...base.Option) *Request {
+		req, _ := NewRequest(true, GET, "coap://localhost/test?a=1", nil)
+		req.Options = append(req.Options, options...)
+		return req
+	}
+	fetchRequest := func(payload string) *Request {
+		req, _ := NewRequest(true, FETCH, "coap://localhost/test?a=1", []byte(payload))
+		return req
+	}
+	tests := []struct {
+		x, y  *Request
+		equal bool
+	}{
+		{x: newRequest(), y: newRequest(), equal: true},
+		{x: newRequest(), y: newRequest(base.Option{ID: Size1, Value: uint32(10)}), equal: true},
+		{x: newRequest(), y: newRequest(base.Option{ID: Accept, Value: uint32(50)}), equal: false},
+		{x: newRequest(base.Option{ID: Accept, Value: uint32(0)}), y: newRequest(base.Option{ID: Accept, Value: uint32(50)}), equal: false},
+		{x: newRequest(base.Option{ID: ContentFormat, Value: uint32(0)}), y: newRequest(base.Option{ID: ContentFormat, Value: uint32(60)}), equal: false},
+		{
+			x:     newRequest(base.Option{ID: Accept, Value: uint32(50)}, base.Option{ID: ETag, Value: []byte("a")}),
+			y:     newRequest(base.Option{ID: ETag, Value: []byte("a")}, base.Option{ID: Accept, Value: uint32(50)}),
+			equal: true,
+		},
+		{x: fetchRequest("a"), y: fetchRequest("a"), equal: true},
+		{x: fetchRequest("a"), y: fetchRequest("b"), equal: false},
+		{
+			x:     newRequest(base.Option{ID: ETag, Value: []byte("a")}, base.Option{ID: ETag, Value: []byte("b")}),
+			y:     newRequest(base.Option{ID: ETag, Value: []byte("b")}, base.Option{ID: ETag, Value: []byte("a")}),
+			equal: false,
+		},
+	}
+	for i, tt := range tests {
+		if got, want := cacheKey(tt.x) == cacheKey(tt.y), tt.equal; got != want {
+			t.Errorf("case%d: %q == %q: %v != %v", i, cacheKey(tt.x), cacheKey(tt.y), got, want)
+		}
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	entry := func(path string, n int) *CacheEntry {
+		req, _ := NewRequest(true, GET, "coap://localhost"+path, nil)
+		return &CacheEntry{Request: req, Response: &Response{Status: Content, Payload: make([]byte, n)}, Stored: time.Now(), MaxAge: time.Minute}
+	}
+
+	// 项数限制, 淘汰最久未使用的响应
+	c := NewLRUCache(2, 0)
+	c.Add("a", entry("/a", 1))
+	c.Add("b", entry("/b", 1))
+	c.Get("a")
+	c.Add("c", entry("/c", 1))
+	for i, tt := range []struct {
+		key string
+		ok  bool
+	}{{"a", true}, {"b", false}, {"c", true}} {
+		if _, ok := c.Get(tt.key); ok != tt.ok {
+			t.Errorf("case%d: get %s: %v != %v", i, tt.key, ok, tt.ok)
+		}
+	}
+
+	// 字节数限制
+	c = NewLRUCache(0, 250)
+	c.Add("a", entry("/a", 100))
+	c.Add("b", entry("/b", 100))
+	c.Add("c", entry("/c", 100))
+	c.Add("d", entry("/d", 1000))
+	if got, want := c.Len(), 2; got != want {
+		t.Errorf("len: %d != %d", got, want)
+	}
+	if got := c.Bytes(); got > 250 {
+		t.Errorf("bytes: %d > 250", got)
+	}
+
+	// 删除uri的所有响应
+	c = NewLRUCache(0, 0)
+	c.Add("a1", entry("/a", 1))
+	c.Add("a2", entry("/a", 2))
+	c.Add("b", entry("/b", 1))
+	c.Invalidate("coap://localhost:5683/a")
+	if got, want := c.Len(), 1; got != want {
+		t.Errorf("invalidate: len: %d != %d", got, want)
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Errorf("invalidate: b is removed")
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	var requests int64
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Write([]byte("ok"))
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&Server{Handler: h}).Serve("coap", ln)
+
+	shared := NewLRUCache(0, 0)
+	c1, c2 := &Client{Cache: shared}, &Client{Cache: shared}
+	urlstr := "coap://" + ln.LocalAddr().String() + "/resource"
+	tests := []struct {
+		client   *Client
+		method   Code
+		payload  string
+		requests int64
+	}{
+		{client: c1, method: GET, requests: 1},
+		{client: c2, method: GET, requests: 1},
+		{client: c1, method: PUT, requests: 2},
+		{client: c2, method: GET, requests: 3},
+		{client: c1, method: GET, requests: 3},
+		{client: &Client{Cache: NoCache}, method: GET, requests: 4},
+		{client: c1, method: FETCH, payload: "a", requests: 5},
+		{client: c2, method: FETCH, payload: "a", requests: 5},
+		{client: c1, method: FETCH, payload: "b", requests: 6},
+		{client: c1, method: PATCH, requests: 7},
+		{client: c1, method: FETCH, payload: "a", requests: 8},
+		{client: c1, method: IPATCH, requests: 9},
+		{client: c1, method: GET, requests: 10},
+	}
+	for i, tt := range tests {
+		var payload []byte
+		if tt.payload != "" {
+			payload = []byte(tt.payload)
+		}
+		req, err := NewRequest(true, tt.method, urlstr, payload)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		if _, err = tt.client.SendRequest(req); err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := atomic.LoadInt64(&requests), tt.requests; got != want {
+			t.Errorf("case%d: requests: %d != %d", i, got, want)
+		}
+	}
+}
+
+func TestCacheResponseCopy(t *testing.T) {
+	var requests int64
+	h := Chain(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("ok"))
+	}), Conditional(func(r *Request) []byte {
+		return []byte("v1")
+	}))
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Options().Set(MaxAge, uint32(1))
+		h.ServeCOAP(w, r)
+	})}).Serve("coap", ln)
+
+	// 共享缓存的调用者修改响应不影响缓存及其他调用者
+	shared := NewLRUCache(0, 0)
+	c1, c2 := &Client{Cache: shared}, &Client{Cache: shared}
+	urlstr := "coap://" + ln.LocalAddr().String() + "/resource"
+	tests := []struct {
+		client   *Client
+		expire   bool
+		requests int64
+	}{
+		{client: c1, requests: 1},
+		{client: c2, requests: 1},
+		{client: c1, requests: 1},
+		{client: c2, expire: true, requests: 2},
+		{client: c1, requests: 2},
+	}
+	for i, tt := range tests {
+		if tt.expire {
+			time.Sleep(1100 * time.Millisecond)
+		}
+		req, err := NewRequest(true, GET, urlstr, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err := tt.client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := atomic.LoadInt64(&requests), tt.requests; got != want {
+			t.Errorf("case%d: requests: %d != %d", i, got, want)
+		}
+		if _, ok := resp.Options.ContentFormat(); ok || resp.Status != Content {
+			t.Errorf("case%d: response modified: %v %v", i, resp.Status, resp.Options)
+		}
+		resp.Status = BadRequest
+		resp.Options.Set(ContentFormat, AppJSON)
+	}
+}
@@ -1,11 +1,18 @@
 package coap
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/pion/dtls/v2"
 )
 
 // Conn COAP链接
@@ -23,10 +30,22 @@ func newConn(url *url.URL, conn net.Conn, sess *session) *Conn {
 }
 
 func (c *Conn) reading() {
+	if sc, ok := c.conn.(*streamConn); ok {
+		if err := sc.serve(c.sess); err != nil && atomic.LoadInt64(&c.closed) == 0 {
+			c.sess.logger().Log(LevelError, "conn serve", "error", err)
+		}
+		// 对端关闭链接后, 结束会话中等待响应的请求
+		c.Close()
+		return
+	}
+
 	var buf [1500]byte
 	for atomic.LoadInt64(&c.closed) == 0 {
 		n, err := c.conn.Read(buf[:])
 		if err != nil {
+			if err == io.EOF {
+				return
+			}
 			continue
 		}
 		data := make([]byte, n)
@@ -35,6 +54,10 @@ func (c *Conn) reading() {
 	}
 }
 
+func (c *Conn) isClosed() bool {
+	return atomic.LoadInt64(&c.closed) != 0
+}
+
 // Close 关闭COAP链接
 func (c *Conn) Close() error {
 	if atomic.CompareAndSwapInt64(&c.closed, 0, 1) {
@@ -44,6 +67,11 @@ func (c *Conn) Close() error {
 	return nil
 }
 
+// SetLogger 设置链接使用的Logger, 覆盖Client.Logger
+func (c *Conn) SetLogger(l Logger) {
+	c.sess.setLogger(l)
+}
+
 // SendRequest 发送COAP请求
 func (c *Conn) SendRequest(req *Request) (*Response, error) {
 	if atomic.LoadInt64(&c.closed) != 0 {
@@ -55,16 +83,88 @@ func (c *Conn) SendRequest(req *Request) (*Response, error) {
 	return c.sess.postRequestWithCache(req)
 }
 
+// Download 以Block2块传输逐块获取响应, 并将响应负载依次写入w, 返回的响应不包含负载
+func (c *Conn) Download(req *Request, w io.Writer) (*Response, error) {
+	if atomic.LoadInt64(&c.closed) != 0 {
+		return nil, errors.New("conn closed")
+	}
+	if c.url.Host != req.URL.Host {
+		return nil, fmt.Errorf("%q is unacceptable, correct url host is %q", req.URL.Host, c.url.Host)
+	}
+	return c.sess.postBlock2Request(req, w)
+}
+
+// Do 发送COAP请求, ctx取消时停止重传并返回ctx.Err()
+func (c *Conn) Do(ctx context.Context, req *Request) (*Response, error) {
+	return c.SendRequest(req.WithContext(ctx))
+}
+
+// Ping 发送Ping信令并等待Pong, 用于检查coap+tcp及coap+ws等可靠传输链接是否存活,
+// 其他链接返回ErrPingNotSupported
+func (c *Conn) Ping(ctx context.Context) error {
+	return c.sess.ping(ctx)
+}
+
+// Notify 通知资源path的所有观察者, 参见Server.Notify. 链接已关闭时返回ErrSessionClosed.
+func (c *Conn) Notify(path string) error {
+	return c.sess.notify(path)
+}
+
+// NotifyPayload 以给定内容通知资源path的所有观察者, 参见Server.NotifyPayload.
+func (c *Conn) NotifyPayload(path string, status Code, options Options, payload []byte, confirmable bool) error {
+	return c.sess.notifyPayload(path, status, options, payload, confirmable)
+}
+
 // Client 定义了运行一个COAP Client的参数
 type Client struct {
-	ReadBytes  int // 读缓冲大小
-	WriteBytes int // 写缓冲大小
+	ReadBytes  int          // 读缓冲大小
+	WriteBytes int          // 写缓冲大小
+	DTLSConfig *dtls.Config // DTLS配置, 访问coaps地址时使用
+	TLSConfig  *tls.Config  // TLS配置, 访问coaps+tcp及coaps+ws地址时使用
+
+	IdleTimeout  time.Duration // 链接池中空闲链接的超时时间, 为0时使用DefaultIdleTimeout
+	MaxEndpoints int           // 链接池最多保持的端点数, 为0表示不限制
+
+	TransmissionParams *TransmissionParams // 传输参数, 为nil时使用协议默认值
+	OSCORE             *OSCOREContext      // OSCORE安全上下文, 不为nil时发出的请求均受OSCORE保护
+
+	// QBlock 在UDP及DTLS上以Q-Block1/Q-Block2(RFC 9177)传输大于块大小的请求负载及GET请求的响应负载,
+	// 适用于高丢包、高时延的链路. 对端以BadOption拒绝时改用Block1/Block2块传输.
+	// 设置了Body或BlockSize的请求仍使用Block1/Block2块传输.
+	QBlock bool
+
+	// Cache 响应缓存, 可由多个Client、Server共享; 为nil时每个会话使用默认容量的LRUCache, 设为NoCache时不缓存
+	Cache Cache
+
+	// Proxy 返回请求使用的出站代理地址, 返回nil表示直接访问目标服务器.
+	// 经由代理时目标url以Proxy-Uri选项携带, 如coap://proxy:5683.
+	Proxy func(*Request) (*url.URL, error)
+
+	Logger Logger    // 日志接口, 为nil时以Info级别输出到log包的标准Logger
+	Trace  TraceMode // 消息跟踪模式
+
+	mu    sync.Mutex
+	conns map[string]*pooledConn
+	stats stats
 }
 
 var DefaultClient = &Client{}
 
-// SendRequest 发送COAP请求
+// SendRequest 发送COAP请求, 到同一端点的请求共享链接池中的链接及其会话
 func (c *Client) SendRequest(req *Request) (*Response, error) {
+	return c.send(req, (*session).postRequestWithCache)
+}
+
+// Download 以Block2块传输逐块获取响应, 并将响应负载依次写入w, 返回的响应不包含负载.
+// 各块的ETag不一致时返回ErrBlockETagChanged, 此时w中可能已写入部分负载.
+func (c *Client) Download(req *Request, w io.Writer) (*Response, error) {
+	return c.send(req, func(s *session, r *Request) (*Response, error) {
+		return s.postBlock2Request(r, w)
+	})
+}
+
+// send 经由出站代理或链接池中的链接, 以post在会话中发送请求
+func (c *Client) send(req *Request, post func(*session, *Request) (*Response, error)) (*Response, error) {
 	if req.URL == nil {
 		return nil, errors.New("coap: nil Request.URL")
 	}
@@ -72,33 +172,61 @@ func (c *Client) SendRequest(req *Request) (*Response, error) {
 		return nil, errors.New("coap: invalid Request.URL.Host")
 	}
 
-	conn, err := c.dial(req.URL)
+	dest := req.URL
+	if c.Proxy != nil {
+		proxy, err := c.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			if dest, err = endpointURL(proxy); err != nil {
+				return nil, err
+			}
+			req = proxyRequest(req)
+		}
+	}
+
+	pc, err := c.getConn(dest)
 	if err != nil {
 		return nil, err
 	}
-	sess := newSession(conn, nil, nil, conn.LocalAddr(), conn.RemoteAddr(), req.URL.Scheme)
-
-	var closed int64
-	defer func() {
-		atomic.StoreInt64(&closed, 1)
-		conn.Close()
-		sess.Close()
-	}()
-
-	go func() {
-		var buf [1500]byte
-		for atomic.LoadInt64(&closed) == 0 {
-			n, err := conn.Read(buf[:])
-			if err != nil {
-				continue
-			}
-			data := make([]byte, n)
-			copy(data, buf[:n])
-			sess.recvData(data)
-		}
-	}()
+	defer c.putConn(pc)
+	return post(pc.conn.sess, req)
+}
+
+// endpointURL 返回补全默认端口的端点url
+func endpointURL(u *url.URL) (*url.URL, error) {
+	_, port, err := splitHostPort(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	e := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	if port == 0 {
+		e.Host += ":" + defaultPort(u.Scheme)
+	}
+	return e, nil
+}
+
+// Do 发送COAP请求, ctx取消时停止重传并返回ctx.Err()
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	return c.SendRequest(req.WithContext(ctx))
+}
 
-	return sess.postRequestAndWaitResponse(req)
+// Ping 向urlstr所在端点的链接池链接发送Ping信令并等待Pong, 参见Conn.Ping
+func (c *Client) Ping(ctx context.Context, urlstr string) error {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return err
+	}
+	if u, err = endpointURL(u); err != nil {
+		return err
+	}
+	pc, err := c.getConn(u)
+	if err != nil {
+		return err
+	}
+	defer c.putConn(pc)
+	return pc.conn.sess.ping(ctx)
 }
 
 func (c *Client) dialUDP(address string) (net.Conn, error) {
@@ -120,7 +248,45 @@ func (c *Client) dialUDP(address string) (net.Conn, error) {
 }
 
 func (c *Client) dial(u *url.URL) (net.Conn, error) {
-	return c.dialUDP(u.Host)
+	switch u.Scheme {
+	case "coaps":
+		if c.DTLSConfig == nil {
+			return nil, errors.New("coap: nil Client.DTLSConfig")
+		}
+		return dialDTLS(u.Host, c.DTLSConfig)
+	case "coap+tcp", "coaps+tcp":
+		conn, err := dialTCP(u.Scheme, u.Host, c.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		return newStreamConn(conn), nil
+	case "coap+ws", "coaps+ws":
+		return dialWebSocket(u, c.TLSConfig)
+	default:
+		return c.dialUDP(u.Host)
+	}
+}
+
+func (c *Client) dialConn(u *url.URL, handler Handler, observer Observer) (*Conn, error) {
+	nc, err := c.dial(u)
+	if err != nil {
+		return nil, err
+	}
+	sess := newSession(nc, handler, observer, nc.LocalAddr(), nc.RemoteAddr(), u.Scheme, c.sessionConfig())
+	sess.dtls = newDTLSState(nc)
+	return newConn(u, nc, sess), nil
+}
+
+func (c *Client) sessionConfig() sessionConfig {
+	return sessionConfig{params: c.TransmissionParams, oscore: c.OSCORE, qblock: c.QBlock, cache: c.Cache, logger: c.Logger, trace: c.Trace, stats: &c.stats}
+}
+
+// Stats 返回Client的统计快照, 包括由Dial建立的链接
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	n := len(c.conns)
+	c.mu.Unlock()
+	return c.stats.snapshot(n)
 }
 
 // Dial 建立COAP链接
@@ -134,15 +300,7 @@ func (c *Client) Dial(urlstr string, handler Handler, observer Observer) (*Conn,
 		return nil, err
 	}
 	if port == 0 {
-		if u.Scheme == "coaps" {
-			u.Host += ":5684"
-		} else {
-			u.Host += ":5683"
-		}
-	}
-	nc, err := c.dial(u)
-	if err != nil {
-		return nil, err
+		u.Host += ":" + defaultPort(u.Scheme)
 	}
-	return newConn(u, nc, newSession(nc, handler, observer, nc.LocalAddr(), nc.RemoteAddr(), u.Scheme)), nil
+	return c.dialConn(u, handler, observer)
 }
@@ -2,6 +2,7 @@ package coap_test
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"sync"
@@ -19,7 +20,7 @@ func init() {
 type TestCOAPHandler struct{}
 
 func (h TestCOAPHandler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
-	w.Write(r.Payload)
+	io.Copy(w, r.Body)
 }
 
 func ListenAndServeTestCOAP(addr string) {
@@ -0,0 +1,54 @@
+package coap
+
+import "bytes"
+
+// CheckPreconditions 按请求的条件选项检查资源的当前ETag, etag为nil表示资源不存在(RFC 7252 5.10.8, 5.10.6).
+//
+// If-Match的值均不匹配etag(空值匹配任意存在的资源), 或资源存在而请求携带If-None-Match时, 以PreconditionFailed响应;
+// GET及FETCH请求的某个ETag选项与etag相同时, 以Valid响应. 以上情况返回false, Handler不应再写入响应.
+// 否则返回true, GET及FETCH请求的响应设置ETag选项.
+func CheckPreconditions(w ResponseWriter, r *Request, etag []byte) bool {
+	if !matchIfMatch(r, etag) || (etag != nil && r.Options.Contain(IfNoneMatch)) {
+		w.WriteCode(PreconditionFailed)
+		return false
+	}
+	if !isSafeMethod(r.Method) || etag == nil {
+		return true
+	}
+	w.Options().Set(ETag, etag)
+	for _, b := range r.Options.ETags() {
+		if bytes.Equal(b, etag) {
+			w.WriteCode(Valid)
+			return false
+		}
+	}
+	return true
+}
+
+func matchIfMatch(r *Request, etag []byte) bool {
+	values := r.Options.GetValues(IfMatch)
+	if len(values) == 0 {
+		return true
+	}
+	if etag == nil {
+		return false
+	}
+	for _, v := range values {
+		if b, ok := v.([]byte); ok && (len(b) == 0 || bytes.Equal(b, etag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conditional 返回检查条件请求的中间件, etag返回请求资源的当前ETag, 资源不存在时返回nil.
+// 条件不满足时以PreconditionFailed或Valid响应, 不再调用next, 参见CheckPreconditions.
+func Conditional(etag func(r *Request) []byte) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			if CheckPreconditions(w, r, etag(r)) {
+				next.ServeCOAP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package coap_test
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	coap.EnableCache = true
+	defer func() { coap.EnableCache = false }()
+
+	var mu sync.Mutex
+	etag, data := []byte("v1"), "version 1"
+	var requests, contents int64
+	content := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == coap.PUT {
+			etag, data = []byte("v2"), string(r.Payload)
+			w.WriteCode(coap.Changed)
+			return
+		}
+		atomic.AddInt64(&contents, 1)
+		w.Write([]byte(data))
+	})
+	conditional := coap.Chain(content, coap.Conditional(func(r *coap.Request) []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return etag
+	}))
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Options().Set(coap.MaxAge, uint32(1))
+		conditional.ServeCOAP(w, r)
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h}).Serve("coap", ln)
+
+	client := &coap.Client{}
+	urlstr := "coap://" + ln.LocalAddr().String() + "/resource"
+	get := func(i int, payload string) {
+		req, err := coap.NewRequest(true, coap.GET, urlstr, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: get: %v", i, err)
+		}
+		if resp.Status != coap.Content || string(resp.Payload) != payload {
+			t.Errorf("case%d: response: %v %q != %v %q", i, resp.Status, resp.Payload, coap.Content, payload)
+		}
+	}
+
+	// 过期后以ETag重新验证, 2.03 Valid时沿用缓存的负载
+	get(0, "version 1")
+	get(1, "version 1")
+	time.Sleep(1100 * time.Millisecond)
+	get(2, "version 1")
+	if got, want := atomic.LoadInt64(&requests), int64(2); got != want {
+		t.Errorf("requests: %d != %d", got, want)
+	}
+	if got, want := atomic.LoadInt64(&contents), int64(1); got != want {
+		t.Errorf("contents: %d != %d", got, want)
+	}
+
+	// 条件请求
+	tests := []struct {
+		options coap.Options
+		status  coap.Code
+	}{
+		{options: coap.Options{{ID: coap.IfMatch, Value: []byte("v0")}}, status: coap.PreconditionFailed},
+		{options: coap.Options{{ID: coap.IfNoneMatch, Value: []byte{}}}, status: coap.PreconditionFailed},
+		{options: coap.Options{{ID: coap.IfMatch, Value: []byte("v0")}, {ID: coap.IfMatch, Value: []byte("v1")}}, status: coap.Changed},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, coap.PUT, urlstr, []byte("version 2"))
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		req.Options = append(req.Options, tt.options...)
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: put: %v", i, err)
+		}
+		if got, want := resp.Status, tt.status; got != want {
+			t.Errorf("case%d: status: %v != %v", i, got, want)
+		}
+	}
+
+	// 资源改变后重新验证得到新的表示
+	time.Sleep(1100 * time.Millisecond)
+	get(3, "version 2")
+	if got, want := atomic.LoadInt64(&contents), int64(2); got != want {
+		t.Errorf("contents: %d != %d", got, want)
+	}
+}
+
+func TestConditionalFetch(t *testing.T) {
+	h := coap.Chain(coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.Write([]byte("result"))
+	}), coap.Conditional(func(r *coap.Request) []byte {
+		return []byte("v1")
+	}))
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h}).Serve("coap", ln)
+
+	tests := []struct {
+		etag    []byte
+		status  coap.Code
+		payload string
+	}{
+		{etag: nil, status: coap.Content, payload: "result"},
+		{etag: []byte("v0"), status: coap.Content, payload: "result"},
+		{etag: []byte("v1"), status: coap.Valid, payload: ""},
+	}
+	client := &coap.Client{}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, coap.FETCH, "coap://"+ln.LocalAddr().String()+"/query", []byte("q"))
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		if tt.etag != nil {
+			req.Options.Set(coap.ETag, tt.etag)
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: fetch: %v", i, err)
+		}
+		if resp.Status != tt.status || string(resp.Payload) != tt.payload {
+			t.Errorf("case%d: response: %v %q != %v %q", i, resp.Status, resp.Payload, tt.status, tt.payload)
+		}
+		if etags := resp.Options.ETags(); len(etags) != 1 || string(etags[0]) != "v1" {
+			t.Errorf("case%d: etags: %q != [v1]", i, etags)
+		}
+	}
+}
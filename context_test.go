@@ -0,0 +1,85 @@
+package coap
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func RespWaiters(s *session) int {
+	c := make(chan int)
+	s.runningc <- func() { c <- len(s.respWaiters) }
+	return <-c
+}
+
+func TestConnDoCancel(t *testing.T) {
+	// 不响应任何请求的对端
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := DefaultClient.Dial("coap://"+ln.LocalAddr().String(), nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, err := NewRequest(true, GET, "coap://"+ln.LocalAddr().String()+"/slow", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err = conn.Do(ctx, req); err != context.DeadlineExceeded {
+		t.Errorf("do: %v != %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("do returns after %v", d)
+	}
+	if got, want := RespWaiters(conn.sess), 0; got != want {
+		t.Errorf("response waiters: %d != %d", got, want)
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	ctxc := make(chan context.Context, 1)
+	h := func(w ResponseWriter, r *Request) {
+		ctxc <- r.Context()
+	}
+	s := &Server{Handler: HandlerFunc(h)}
+	go s.ServeTCP(ln)
+
+	conn, err := DefaultClient.Dial("coap+tcp://"+ln.Addr().String(), nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	req, err := NewRequest(true, GET, "coap+tcp://"+ln.Addr().String()+"/ctx", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if _, err = conn.SendRequest(req); err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	ctx := <-ctxc
+	if err = ctx.Err(); err != nil {
+		t.Fatalf("context error before close: %v", err)
+	}
+
+	conn.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("handler context not cancelled after session closed")
+	}
+}
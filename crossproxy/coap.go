@@ -0,0 +1,159 @@
+package crossproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ironzhang/coap"
+)
+
+var httpMethods = map[coap.Code]string{
+	coap.GET:    http.MethodGet,
+	coap.POST:   http.MethodPost,
+	coap.PUT:    http.MethodPut,
+	coap.DELETE: http.MethodDelete,
+}
+
+// COAPToHTTP 将带有Proxy-Uri或Proxy-Scheme选项的COAP请求转发至HTTP服务器的coap.Handler(RFC 8075).
+//
+// 目标为http或https以外的代理请求及不含代理选项的请求交由Next处理, 如coap.Proxy.
+// HTTP状态码按COAPCode转换, Content-Type、Cache-Control及ETag头部转换为相应的选项.
+type COAPToHTTP struct {
+	// Client 转发请求使用的http.Client, 为nil时使用http.DefaultClient
+	Client *http.Client
+
+	// Next 处理其余请求, 为nil时以ProxyingNotSupported或NotFound响应
+	Next coap.Handler
+}
+
+func (h *COAPToHTTP) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+func (h *COAPToHTTP) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	target, err := coap.ProxyTarget(r)
+	if err != nil {
+		w.WriteCode(coap.BadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	if target == nil || (target.Scheme != "http" && target.Scheme != "https") {
+		switch {
+		case h.Next != nil:
+			h.Next.ServeCOAP(w, r)
+		case target == nil:
+			w.WriteCode(coap.NotFound)
+		default:
+			w.WriteCode(coap.ProxyingNotSupported)
+		}
+		return
+	}
+	method, ok := httpMethods[r.Method]
+	if !ok {
+		w.WriteCode(coap.MethodNotAllowed)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), method, target.String(), r.Body)
+	if err != nil {
+		w.WriteCode(coap.BadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	if format, ok := r.Options.ContentFormat(); ok {
+		t := coap.MediaType(format)
+		if t == "" {
+			w.WriteCode(coap.UnsupportedContentFormat)
+			return
+		}
+		req.Header.Set("Content-Type", t)
+	}
+	if format, ok := r.Options.Accept(); ok {
+		if t := coap.MediaType(format); t != "" {
+			req.Header.Set("Accept", t)
+		}
+	}
+
+	resp, err := h.client().Do(req)
+	if err != nil {
+		if r.Context().Err() == context.DeadlineExceeded {
+			w.WriteCode(coap.GatewayTimeout)
+		} else {
+			w.WriteCode(coap.BadGateway)
+		}
+		fmt.Fprint(w, err)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		w.WriteCode(coap.BadGateway)
+		fmt.Fprint(w, err)
+		return
+	}
+	// 不转发被截断的响应
+	if len(body) > maxBodySize {
+		w.WriteCode(coap.BadGateway)
+		fmt.Fprint(w, "response body too large")
+		return
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		if format, ok := coap.ContentFormatOf(ct); ok {
+			w.Options().Set(coap.ContentFormat, format)
+		}
+	}
+	if age, ok := maxAge(resp.Header.Get("Cache-Control")); ok {
+		w.Options().Set(coap.MaxAge, age)
+	}
+	if etag, ok := parseETag(resp.Header.Get("ETag")); ok {
+		w.Options().Set(coap.ETag, etag)
+	}
+	w.WriteCode(COAPCode(r.Method, resp.StatusCode))
+	w.Write(body)
+}
+
+// maxAge 解析Cache-Control头部的max-age指令
+func maxAge(cc string) (uint32, bool) {
+	for _, directive := range strings.Split(cc, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "no-cache" || directive == "no-store" {
+			return 0, true
+		}
+		if strings.HasPrefix(directive, "max-age=") {
+			n, err := strconv.ParseUint(directive[len("max-age="):], 10, 32)
+			if err != nil {
+				return 0, false
+			}
+			return uint32(n), true
+		}
+	}
+	return 0, false
+}
+
+// parseETag 将ETag头部转换为ETag选项, 十六进制的ETag按字节解码, 超过8字节时忽略
+func parseETag(s string) ([]byte, bool) {
+	s = strings.TrimPrefix(s, "W/")
+	s = strings.Trim(s, `"`)
+	if s == "" {
+		return nil, false
+	}
+	etag, err := hex.DecodeString(s)
+	if err != nil {
+		etag = []byte(s)
+	}
+	if len(etag) > 8 {
+		return nil, false
+	}
+	return etag, true
+}
@@ -0,0 +1,231 @@
+package crossproxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ironzhang/coap"
+)
+
+func ServeTestCOAP(t *testing.T, h coap.Handler) (addr string, closer func()) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	go (&coap.Server{Handler: h}).Serve("coap", ln)
+	return ln.LocalAddr().String(), func() { ln.Close() }
+}
+
+func TestHTTPToCOAP(t *testing.T) {
+	h := func(w coap.ResponseWriter, r *coap.Request) {
+		switch r.URL.Path {
+		case "/temp":
+			w.Options().Set(coap.ContentFormat, uint32(50))
+			w.Options().Set(coap.MaxAge, uint32(30))
+			w.Options().Set(coap.ETag, []byte{0x01, 0x02})
+			w.Write([]byte(`{"temp":21.5}`))
+		case "/echo":
+			w.WriteCode(coap.Changed)
+			w.Options().Set(coap.ContentFormat, r.Options.Get(coap.ContentFormat))
+			w.Write(r.Payload)
+		default:
+			w.WriteCode(coap.NotFound)
+		}
+	}
+	addr, closer := ServeTestCOAP(t, coap.HandlerFunc(h))
+	defer closer()
+
+	ts := httptest.NewServer(&HTTPToCOAP{})
+	defer ts.Close()
+
+	tests := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		header      map[string]string
+		respBody    string
+	}{
+		{
+			method: "GET",
+			path:   "/.well-known/coap/coap://" + addr + "/temp",
+			status: http.StatusOK,
+			header: map[string]string{
+				"Content-Type":  "application/json",
+				"Cache-Control": "max-age=30",
+				"ETag":          `"0102"`,
+			},
+			respBody: `{"temp":21.5}`,
+		},
+		{
+			method:   "GET",
+			path:     "/.well-known/coap/coap:/" + addr + "/temp",
+			status:   http.StatusOK,
+			respBody: `{"temp":21.5}`,
+		},
+		{
+			method:      "POST",
+			path:        "/.well-known/coap/coap://" + addr + "/echo",
+			contentType: "text/plain",
+			body:        "hello",
+			status:      http.StatusOK,
+			header:      map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			respBody:    "hello",
+		},
+		{
+			method: "GET",
+			path:   "/.well-known/coap/coap://" + addr + "/missing",
+			status: http.StatusNotFound,
+		},
+		{
+			method:      "POST",
+			path:        "/.well-known/coap/coap://" + addr + "/echo",
+			contentType: "text/html",
+			body:        "<p>",
+			status:      http.StatusUnsupportedMediaType,
+		},
+		{
+			method: "GET",
+			path:   "/.well-known/coap/ftp://" + addr + "/temp",
+			status: http.StatusBadRequest,
+		},
+		{
+			method: "PATCH",
+			path:   "/.well-known/coap/coap://" + addr + "/temp",
+			status: http.StatusNotImplemented,
+		},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case%d: do: %v", i, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Errorf("case%d: status: %d != %d, %s", i, got, want, body)
+		}
+		for k, v := range tt.header {
+			if got := resp.Header.Get(k); got != v {
+				t.Errorf("case%d: header %s: %q != %q", i, k, got, v)
+			}
+		}
+		if tt.respBody != "" && string(body) != tt.respBody {
+			t.Errorf("case%d: body: %q != %q", i, body, tt.respBody)
+		}
+	}
+}
+
+func TestCOAPToHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/temp":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "public, max-age=30")
+			w.Header().Set("ETag", `"0a0b"`)
+			w.Write([]byte(`{"temp":21.5}`))
+		case "/echo":
+			w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(body)
+		case "/large":
+			w.Write(make([]byte, maxBodySize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	addr, closer := ServeTestCOAP(t, &COAPToHTTP{})
+	defer closer()
+
+	tests := []struct {
+		method  coap.Code
+		uri     string
+		format  interface{}
+		payload string
+		code    coap.Code
+		options coap.Options
+		resp    string
+	}{
+		{
+			method:  coap.GET,
+			uri:     origin.URL + "/temp",
+			code:    coap.Content,
+			options: coap.Options{{ID: coap.ContentFormat, Value: uint32(50)}, {ID: coap.MaxAge, Value: uint32(30)}, {ID: coap.ETag, Value: []byte{0x0a, 0x0b}}},
+			resp:    `{"temp":21.5}`,
+		},
+		{
+			method:  coap.POST,
+			uri:     origin.URL + "/echo",
+			format:  uint32(0),
+			payload: "hello",
+			code:    coap.Changed,
+			options: coap.Options{{ID: coap.ContentFormat, Value: uint32(0)}},
+			resp:    "hello",
+		},
+		{
+			method: coap.GET,
+			uri:    origin.URL + "/missing",
+			code:   coap.NotFound,
+		},
+		{
+			method: coap.GET,
+			uri:    origin.URL + "/large",
+			code:   coap.BadGateway,
+			resp:   "response body too large",
+		},
+		{
+			method: coap.GET,
+			uri:    "ftp://example.com/temp",
+			code:   coap.ProxyingNotSupported,
+		},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, tt.method, "coap://"+addr, []byte(tt.payload))
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		req.Options.Set(coap.ProxyURI, tt.uri)
+		if tt.format != nil {
+			req.Options.Set(coap.ContentFormat, tt.format)
+		}
+		resp, err := coap.DefaultClient.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := resp.Status, tt.code; got != want {
+			t.Errorf("case%d: code: %v != %v", i, got, want)
+		}
+		for _, o := range tt.options {
+			if got := resp.Options.Get(o.ID); string(toBytes(got)) != string(toBytes(o.Value)) {
+				t.Errorf("case%d: option %d: %v != %v", i, o.ID, got, o.Value)
+			}
+		}
+		if tt.resp != "" && string(resp.Payload) != tt.resp {
+			t.Errorf("case%d: payload: %q != %q", i, resp.Payload, tt.resp)
+		}
+	}
+}
+
+func toBytes(v interface{}) []byte {
+	switch tv := v.(type) {
+	case []byte:
+		return tv
+	case uint32:
+		return []byte{byte(tv >> 24), byte(tv >> 16), byte(tv >> 8), byte(tv)}
+	}
+	return nil
+}
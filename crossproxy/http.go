@@ -0,0 +1,154 @@
+package crossproxy
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ironzhang/coap"
+)
+
+// DefaultPrefix HTTP-COAP默认URI映射的路径前缀(RFC 8075 5.3), 如/.well-known/coap/coap://host/path
+const DefaultPrefix = "/.well-known/coap/"
+
+// maxBodySize 转发的请求及响应负载的最大长度
+const maxBodySize = 1 << 20
+
+var coapMethods = map[string]coap.Code{
+	http.MethodGet:    coap.GET,
+	http.MethodPost:   coap.POST,
+	http.MethodPut:    coap.PUT,
+	http.MethodDelete: coap.DELETE,
+}
+
+// HTTPToCOAP 将HTTP请求转换为COAP请求并转发至目标COAP服务器的http.Handler(RFC 8075).
+//
+// 目标COAP URI位于请求路径的Prefix之后, 如GET /.well-known/coap/coap://host/temp.
+// Content-Type及Accept按注册的映射转换为Content-Format及Accept选项,
+// 响应码按HTTPStatus转换, Content-Format、Max-Age、ETag及Location-Path转换为相应的头部.
+type HTTPToCOAP struct {
+	// Client 转发请求使用的Client, 为nil时使用coap.DefaultClient
+	Client *coap.Client
+
+	// Prefix 目标URI之前的路径前缀, 为空时使用DefaultPrefix
+	Prefix string
+}
+
+func (h *HTTPToCOAP) client() *coap.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return coap.DefaultClient
+}
+
+func (h *HTTPToCOAP) prefix() string {
+	if h.Prefix != "" {
+		return h.Prefix
+	}
+	return DefaultPrefix
+}
+
+func (h *HTTPToCOAP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := coapMethods[r.Method]
+	if !ok {
+		http.Error(w, fmt.Sprintf("method %s not supported", r.Method), http.StatusNotImplemented)
+		return
+	}
+	target, err := h.target(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(payload) > maxBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	req, err := coap.NewRequest(true, method, target, payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && len(payload) > 0 {
+		format, ok := coap.ContentFormatOf(ct)
+		if !ok {
+			http.Error(w, fmt.Sprintf("content type %q not supported", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+		req.Options.Set(coap.ContentFormat, format)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if format, ok := coap.ContentFormatOf(strings.TrimSpace(accept)); ok {
+			req.Options.Set(coap.Accept, format)
+			break
+		}
+	}
+
+	resp, err := h.client().Do(r.Context(), req)
+	if err != nil {
+		if err == coap.ErrTimeout || err == context.DeadlineExceeded {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	writeHTTPResponse(w, resp)
+}
+
+var singleSlashScheme = regexp.MustCompile(`^([a-z][a-z0-9+.-]*):/([^/])`)
+
+// target 由请求路径得到目标COAP URI, 兼容http.ServeMux将//合并为/的路径
+func (h *HTTPToCOAP) target(r *http.Request) (string, error) {
+	path := r.URL.EscapedPath()
+	prefix := h.prefix()
+	if !strings.HasPrefix(path, prefix) {
+		return "", fmt.Errorf("path %q does not start with %q", path, prefix)
+	}
+	target := singleSlashScheme.ReplaceAllString(path[len(prefix):], "$1://$2")
+	if target == "" {
+		return "", errors.New("empty target uri")
+	}
+	if !strings.Contains(target, "://") {
+		target = "coap://" + target
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	return target, nil
+}
+
+func writeHTTPResponse(w http.ResponseWriter, resp *coap.Response) {
+	header := w.Header()
+	if format, ok := resp.Options.ContentFormat(); ok {
+		if t := coap.MediaType(format); t != "" {
+			header.Set("Content-Type", t)
+		}
+	}
+	if age, ok := resp.Options.MaxAge(); ok {
+		header.Set("Cache-Control", fmt.Sprintf("max-age=%d", age))
+	}
+	if etags := resp.Options.ETags(); len(etags) > 0 {
+		header.Set("ETag", `"`+hex.EncodeToString(etags[0])+`"`)
+	}
+	if path := resp.Options.LocationPath(); path != "" {
+		location := "/" + path
+		if query := resp.Options.GetStrings(coap.LocationQuery); len(query) > 0 {
+			location += "?" + strings.Join(query, "&")
+		}
+		header.Set("Location", location)
+	}
+	w.WriteHeader(HTTPStatus(resp.Status))
+	w.Write(resp.Payload)
+}
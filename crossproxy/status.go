@@ -0,0 +1,92 @@
+package crossproxy
+
+import (
+	"net/http"
+
+	"github.com/ironzhang/coap"
+)
+
+// httpStatuses COAP响应码到HTTP状态码的映射(RFC 8075 7)
+var httpStatuses = map[coap.Code]int{
+	coap.Created:                  http.StatusCreated,
+	coap.Deleted:                  http.StatusOK,
+	coap.Valid:                    http.StatusNotModified,
+	coap.Changed:                  http.StatusOK,
+	coap.Content:                  http.StatusOK,
+	coap.BadRequest:               http.StatusBadRequest,
+	coap.Unauthorized:             http.StatusForbidden,
+	coap.BadOption:                http.StatusBadRequest,
+	coap.Forbidden:                http.StatusForbidden,
+	coap.NotFound:                 http.StatusNotFound,
+	coap.MethodNotAllowed:         http.StatusMethodNotAllowed,
+	coap.NotAcceptable:            http.StatusNotAcceptable,
+	coap.RequestEntityIncomplete:  http.StatusBadRequest,
+	coap.PreconditionFailed:       http.StatusPreconditionFailed,
+	coap.RequestEntityTooLarge:    http.StatusRequestEntityTooLarge,
+	coap.UnsupportedContentFormat: http.StatusUnsupportedMediaType,
+	coap.InternalServerError:      http.StatusInternalServerError,
+	coap.NotImplemented:           http.StatusNotImplemented,
+	coap.BadGateway:               http.StatusBadGateway,
+	coap.ServiceUnavailable:       http.StatusServiceUnavailable,
+	coap.GatewayTimeout:           http.StatusGatewayTimeout,
+	coap.ProxyingNotSupported:     http.StatusBadGateway,
+}
+
+// HTTPStatus 返回COAP响应码对应的HTTP状态码, 未定义的响应码按类别映射.
+func HTTPStatus(code coap.Code) int {
+	if status, ok := httpStatuses[code]; ok {
+		return status
+	}
+	switch code >> 5 {
+	case 2:
+		return http.StatusOK
+	case 4:
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// coapCodes HTTP状态码到COAP响应码的映射
+var coapCodes = map[int]coap.Code{
+	http.StatusCreated:               coap.Created,
+	http.StatusNotModified:           coap.Valid,
+	http.StatusBadRequest:            coap.BadRequest,
+	http.StatusUnauthorized:          coap.Unauthorized,
+	http.StatusForbidden:             coap.Forbidden,
+	http.StatusNotFound:              coap.NotFound,
+	http.StatusMethodNotAllowed:      coap.MethodNotAllowed,
+	http.StatusNotAcceptable:         coap.NotAcceptable,
+	http.StatusPreconditionFailed:    coap.PreconditionFailed,
+	http.StatusRequestEntityTooLarge: coap.RequestEntityTooLarge,
+	http.StatusUnsupportedMediaType:  coap.UnsupportedContentFormat,
+	http.StatusInternalServerError:   coap.InternalServerError,
+	http.StatusNotImplemented:        coap.NotImplemented,
+	http.StatusBadGateway:            coap.BadGateway,
+	http.StatusServiceUnavailable:    coap.ServiceUnavailable,
+	http.StatusGatewayTimeout:        coap.GatewayTimeout,
+}
+
+// COAPCode 返回HTTP状态码对应的COAP响应码, 2xx按请求方法映射为Content、Changed或Deleted.
+func COAPCode(method coap.Code, status int) coap.Code {
+	if code, ok := coapCodes[status]; ok {
+		return code
+	}
+	switch status / 100 {
+	case 2:
+		switch method {
+		case coap.GET:
+			return coap.Content
+		case coap.DELETE:
+			return coap.Deleted
+		default:
+			return coap.Changed
+		}
+	case 4:
+		return coap.BadRequest
+	case 5:
+		return coap.InternalServerError
+	default:
+		return coap.BadGateway
+	}
+}
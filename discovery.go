@@ -0,0 +1,93 @@
+package coap
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/ironzhang/coap/linkformat"
+)
+
+// WellKnownCore 资源发现路径(RFC 6690)
+const WellKnownCore = "/.well-known/core"
+
+// Links 返回注册资源的link-format描述.
+//
+// 含路径参数的模式不对应具体资源, 不出现在列表中; 前缀模式以去掉末尾/的路径表示.
+func (mux *ServeMux) Links() []linkformat.Link {
+	var links []linkformat.Link
+	for _, res := range mux.Resources() {
+		if strings.Contains(res.Pattern, "{") || res.Pattern == "/" {
+			continue
+		}
+		l := linkformat.Link{URI: strings.TrimSuffix(res.Pattern, "/")}
+		for k, v := range res.Attrs {
+			l.Set(k, v)
+		}
+		links = append(links, l)
+	}
+	return links
+}
+
+// serveWellKnownCore 以link-format格式响应资源发现请求, 支持以查询参数过滤, 如?rt=temperature*
+func (mux *ServeMux) serveWellKnownCore(w ResponseWriter, r *Request) {
+	if r.Method != GET {
+		w.WriteCode(MethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var links []linkformat.Link
+	for _, l := range mux.Links() {
+		if matchLinkQuery(l, query) {
+			links = append(links, l)
+		}
+	}
+	w.Options().Set(ContentFormat, AppLinkFormat)
+	io.WriteString(w, linkformat.Format(links))
+}
+
+func matchLinkQuery(l linkformat.Link, query url.Values) bool {
+	for name, values := range query {
+		for _, v := range values {
+			if !l.Match(name, v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Discover 使用DefaultClient获取资源发现文档, 参见Client.Discover.
+func Discover(urlstr string) ([]linkformat.Link, error) {
+	return DefaultClient.Discover(urlstr)
+}
+
+// Discover 获取并解析资源发现文档.
+//
+// urlstr路径为空时访问/.well-known/core, 查询参数用于服务端过滤, 如coap://host?rt=temperature.
+func (c *Client) Discover(urlstr string) ([]linkformat.Link, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = WellKnownCore
+	}
+	req, err := NewRequest(true, GET, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.SendRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != Content {
+		return nil, fmt.Errorf("coap: discover %s: %v", u, resp.Status)
+	}
+	if ct, ok := resp.Options.ContentFormat(); ok && ct != AppLinkFormat {
+		return nil, fmt.Errorf("coap: discover %s: unexpected content format %d", u, ct)
+	}
+	return linkformat.Parse(string(resp.Payload))
+}
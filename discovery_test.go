@@ -0,0 +1,83 @@
+package coap_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ironzhang/coap"
+	"github.com/ironzhang/coap/coaptest"
+)
+
+func ServeTestDiscovery(t *testing.T) (addr string, closer func()) {
+	noop := func(w coap.ResponseWriter, r *coap.Request) {}
+	mux := coap.NewServeMux()
+	mux.HandleMethodFunc(coap.GET, "/sensors/temp", noop)
+	mux.SetAttrs("/sensors/temp", map[string]string{"rt": "temperature-c", "if": "sensor", "obs": ""})
+	mux.HandleMethodFunc(coap.GET, "/sensors/light", noop)
+	mux.SetAttrs("/sensors/light", map[string]string{"rt": "light-lux", "if": "sensor", "ct": "0"})
+	mux.HandleFunc("/actuators/{id}", noop)
+	mux.HandleFunc("/firmware/", noop)
+
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	s := &coap.Server{Handler: mux}
+	go s.Serve("coap", ln)
+	return ln.LocalAddr().String(), func() { ln.Close() }
+}
+
+func TestDiscover(t *testing.T) {
+	addr, closer := ServeTestDiscovery(t)
+	defer closer()
+
+	tests := []struct {
+		urlstr string
+		uris   []string
+	}{
+		{urlstr: "coap://" + addr, uris: []string{"/firmware", "/sensors/light", "/sensors/temp"}},
+		{urlstr: "coap://" + addr + "/.well-known/core?rt=temperature-c", uris: []string{"/sensors/temp"}},
+		{urlstr: "coap://" + addr + "?rt=light*", uris: []string{"/sensors/light"}},
+		{urlstr: "coap://" + addr + "?href=/sensors/*", uris: []string{"/sensors/light", "/sensors/temp"}},
+		{urlstr: "coap://" + addr + "?if=actuator", uris: nil},
+	}
+	for i, tt := range tests {
+		links, err := coap.Discover(tt.urlstr)
+		if err != nil {
+			t.Fatalf("case%d: discover: %v", i, err)
+		}
+		var uris []string
+		for _, l := range links {
+			uris = append(uris, l.URI)
+		}
+		if got, want := len(uris), len(tt.uris); got != want {
+			t.Fatalf("case%d: links: %v != %v", i, uris, tt.uris)
+		}
+		for j := range uris {
+			if uris[j] != tt.uris[j] {
+				t.Errorf("case%d: link%d: %s != %s", i, j, uris[j], tt.uris[j])
+			}
+		}
+	}
+
+	links, err := coap.Discover("coap://" + addr + "?rt=temperature-c")
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	if len(links) != 1 || !links[0].Observable() || links[0].Interfaces()[0] != "sensor" {
+		t.Errorf("typed link: %v", links)
+	}
+}
+
+func TestWellKnownCoreMethod(t *testing.T) {
+	mux := coap.NewServeMux()
+	req, err := coap.NewRequest(true, coap.POST, "coap://localhost"+coap.WellKnownCore, nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	rec := coaptest.NewRecorder()
+	mux.ServeCOAP(rec, req)
+	if got, want := rec.Code, coap.MethodNotAllowed; got != want {
+		t.Errorf("code: %v != %v", got, want)
+	}
+}
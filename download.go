@@ -0,0 +1,125 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// FetchBlock 获取响应负载的第num块, 块大小size须为16至1024之间2的幂.
+// 响应不经块重组, 携带服务端的Block2选项, 服务端可能以更小的块大小响应.
+func (c *Client) FetchBlock(req *Request, num, size uint32) (*Response, error) {
+	return c.send(req, func(s *session, r *Request) (*Response, error) {
+		return s.postBlockRequest(r, num, size)
+	})
+}
+
+// FetchBlock 获取响应负载的第num块, 参见Client.FetchBlock
+func (c *Conn) FetchBlock(req *Request, num, size uint32) (*Response, error) {
+	if atomic.LoadInt64(&c.closed) != 0 {
+		return nil, errors.New("conn closed")
+	}
+	if c.url.Host != req.URL.Host {
+		return nil, fmt.Errorf("%q is unacceptable, correct url host is %q", req.URL.Host, c.url.Host)
+	}
+	return c.sess.postBlockRequest(req, num, size)
+}
+
+func (s *session) postBlockRequest(r *Request, num, size uint32) (*Response, error) {
+	if !isValidBlockSize(size) {
+		return nil, fmt.Errorf("coap: invalid block size %d", size)
+	}
+	req := blockRequest(r, "", r.Payload)
+	req.Confirmable = r.Confirmable
+	req.Options.Set(Block2, base.BlockOption{Num: num, Size: size}.Value())
+	return s.postRequestAndWaitResponse(req)
+}
+
+// ResumableDownload 可断点续传的Block2下载.
+//
+// 下载进度保存在导出的字段中, 下载中断或程序重启后以相同的进度再次调用Do即从Offset处继续下载.
+// 资源的ETag与已下载部分的ETag不一致时, 丢弃已下载的部分并从头重新下载.
+type ResumableDownload struct {
+	Offset   int64  // 已下载的字节数
+	ETag     []byte // 已下载部分的ETag, 服务端未提供ETag时为nil, 此时无法检测资源的改变
+	Size     int64  // 服务端以Size2选项告知的资源长度, 未知时为0
+	Restarts int    // 因资源改变而重新下载的次数
+}
+
+// Do 经由c从Offset处继续下载req, 将负载写入w的对应位置, c为nil时使用DefaultClient.
+// 下载完成时若w实现了Truncate(int64) error, 则截断至资源长度. 返回的响应不包含负载.
+func (d *ResumableDownload) Do(c *Client, req *Request, w io.WriterAt) (*Response, error) {
+	if c == nil {
+		c = DefaultClient
+	}
+	return c.send(req, func(s *session, r *Request) (*Response, error) {
+		return d.run(s, r, w)
+	})
+}
+
+func (d *ResumableDownload) run(s *session, r *Request, w io.WriterAt) (*Response, error) {
+	size := s.blockSize(r)
+	var token Token
+	for {
+		req := blockRequest(r, token, r.Payload)
+		req.Confirmable = r.Confirmable || len(token) > 0
+		req.Options.Set(Block2, base.BlockOption{Num: uint32(d.Offset / int64(size)), Size: size}.Value())
+		req.Options.Set(Size2, uint32(0))
+		resp, err := s.postRequestAndWaitResponse(req)
+		if err != nil {
+			return nil, err
+		}
+		if !isSuccessCode(resp.Status) {
+			return resp, nil
+		}
+
+		var etag []byte
+		if etags := resp.Options.ETags(); len(etags) > 0 {
+			etag = etags[0]
+		}
+		if d.Offset > 0 && d.ETag != nil && !bytes.Equal(etag, d.ETag) {
+			// 资源已改变, 从头重新下载
+			d.Offset, d.ETag, d.Size = 0, nil, 0
+			d.Restarts++
+			token = resp.Token
+			continue
+		}
+		d.ETag = etag
+		if size2, ok := resp.Options.Size2(); ok {
+			d.Size = int64(size2)
+		}
+
+		// 不支持块传输的服务端以完整的表示响应
+		var start int64
+		opt, ok := resp.Options.Block2()
+		if ok {
+			start = int64(opt.Num) * int64(opt.Size)
+			size = opt.Size
+		}
+		if start > d.Offset {
+			return nil, fmt.Errorf("coap: unexpected block %d of size %d", opt.Num, opt.Size)
+		}
+		if skip := d.Offset - start; skip < int64(len(resp.Payload)) {
+			payload := resp.Payload[skip:]
+			if _, err = w.WriteAt(payload, d.Offset); err != nil {
+				return nil, err
+			}
+			d.Offset += int64(len(payload))
+		}
+		if !opt.More {
+			if t, ok := w.(interface{ Truncate(int64) error }); ok {
+				if err = t.Truncate(d.Offset); err != nil {
+					return nil, err
+				}
+			}
+			resp.Options.Del(Block2)
+			resp.Payload = nil
+			return resp, nil
+		}
+		token = resp.Token
+	}
+}
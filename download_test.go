@@ -0,0 +1,185 @@
+package coap_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ironzhang/coap"
+)
+
+// memFile 内存中的io.WriterAt, limit不为0时写入超过limit的数据返回错误
+type memFile struct {
+	data  []byte
+	limit int64
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.limit > 0 && off+int64(len(p)) > f.limit {
+		return 0, errors.New("write limit exceeded")
+	}
+	if n := off + int64(len(p)); n > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, n-int64(len(f.data)))...)
+	}
+	copy(f.data[off:], p)
+	return len(p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.data = f.data[:size]
+	return nil
+}
+
+func TestResumableDownload(t *testing.T) {
+	var mu sync.Mutex
+	etag, data := []byte("v1"), bytes.Repeat([]byte("version 1 "), 300)
+	var served int64
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		atomic.AddInt64(&served, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		w.Options().Set(coap.ETag, etag)
+		w.Write(data)
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h}).Serve("coap", ln)
+
+	client := &coap.Client{}
+	req, err := coap.NewRequest(true, coap.GET, "coap://"+ln.LocalAddr().String()+"/firmware", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+
+	// 获取任意块, 服务端不保存传输状态
+	for _, num := range []uint32{5, 2, 11} {
+		resp, err := client.FetchBlock(req, num, 256)
+		if err != nil {
+			t.Fatalf("fetch block %d: %v", num, err)
+		}
+		start := int(num) * 256
+		end := start + 256
+		if end > len(data) {
+			end = len(data)
+		}
+		if !bytes.Equal(resp.Payload, data[start:end]) {
+			t.Errorf("block %d: payload: %q != %q", num, resp.Payload, data[start:end])
+		}
+		want := num<<4 | 4 // SZX 4即256字节
+		if end < len(data) {
+			want |= 1 << 3
+		}
+		if got := resp.Options.Get(coap.Block2); got != want {
+			t.Errorf("block %d: block2: %v != %v", num, got, want)
+		}
+	}
+	if got, want := atomic.LoadInt64(&served), int64(3); got != want {
+		t.Errorf("served: %d != %d", got, want)
+	}
+
+	// 下载中断后继续
+	req.BlockSize = 512
+	var d coap.ResumableDownload
+	f := &memFile{limit: 1200}
+	if _, err = d.Do(client, req, f); err == nil {
+		t.Fatalf("download should be interrupted")
+	}
+	if got, want := d.Offset, int64(1024); got != want {
+		t.Errorf("offset: %d != %d", got, want)
+	}
+	f.limit = 0
+	if _, err = d.Do(client, req, f); err != nil {
+		t.Fatalf("resume download: %v", err)
+	}
+	if !bytes.Equal(f.data, data) || d.Size != int64(len(data)) || string(d.ETag) != "v1" {
+		t.Errorf("download: length %d, size %d, etag %q", len(f.data), d.Size, d.ETag)
+	}
+
+	// 资源改变后从头重新下载
+	mu.Lock()
+	etag, data = []byte("v2"), bytes.Repeat([]byte("v2 "), 300)
+	mu.Unlock()
+	d.Offset = 512
+	if _, err = d.Do(client, req, f); err != nil {
+		t.Fatalf("restart download: %v", err)
+	}
+	if !bytes.Equal(f.data, data) || d.Restarts != 1 || string(d.ETag) != "v2" {
+		t.Errorf("restart: length %d, restarts %d, etag %q", len(f.data), d.Restarts, d.ETag)
+	}
+
+	// 超出范围的块
+	resp, err := client.FetchBlock(req, 100, 256)
+	if err != nil {
+		t.Fatalf("fetch block: %v", err)
+	}
+	if got, want := resp.Status, coap.BadOption; got != want {
+		t.Errorf("out of range: status: %v != %v", got, want)
+	}
+}
+
+func TestDownloadSmallerServerBlockSize(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 3000; i++ {
+		fmt.Fprintf(&buf, "%05d,", i)
+	}
+	data := buf.Bytes()
+	var served int64
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		atomic.AddInt64(&served, 1)
+		w.Options().Set(coap.ETag, []byte("v1"))
+		w.Write(data)
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h, TransmissionParams: &coap.TransmissionParams{MaxBlockSize: 256}}).Serve("coap", ln)
+
+	client := &coap.Client{}
+	req, err := coap.NewRequest(true, coap.GET, "coap://"+ln.LocalAddr().String()+"/firmware", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+
+	// 以1024字节获取第2块, 服务端以256字节的第8块响应
+	resp, err := client.FetchBlock(req, 2, 1024)
+	if err != nil {
+		t.Fatalf("fetch block: %v", err)
+	}
+	if got, want := string(resp.Payload), string(data[2048:2304]); got != want {
+		t.Errorf("fetch block: payload: %q != %q", got, want)
+	}
+	if got, ok := resp.Options.Block2(); !ok || got != (coap.BlockOption{Num: 8, More: true, Size: 256}) {
+		t.Errorf("fetch block: block2: %v %t", got, ok)
+	}
+
+	// 中断后以1024字节的块大小继续, 不重复获取已下载的块
+	req.BlockSize = 1024
+	var d coap.ResumableDownload
+	f := &memFile{limit: 1200}
+	if _, err = d.Do(client, req, f); err == nil {
+		t.Fatalf("download should be interrupted")
+	}
+	if got, want := d.Offset, int64(1024); got != want {
+		t.Errorf("offset: %d != %d", got, want)
+	}
+	f.limit = 0
+	atomic.StoreInt64(&served, 0)
+	if _, err = d.Do(client, req, f); err != nil {
+		t.Fatalf("resume download: %v", err)
+	}
+	if !bytes.Equal(f.data, data) {
+		t.Errorf("download: %q != %q", f.data, data)
+	}
+	if got, want := atomic.LoadInt64(&served), int64((len(data)-1024+255)/256); got != want {
+		t.Errorf("served: %d != %d", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+package coap
+
+import (
+	"net"
+
+	"github.com/pion/dtls/v2"
+)
+
+// DTLSState DTLS链接的对端身份信息
+type DTLSState struct {
+	// PSK身份标识, 使用PSK密码套件时有效
+	PSKIdentity []byte
+
+	// 对端证书链, X.509证书或原始公钥, 以DER编码
+	PeerCertificates [][]byte
+}
+
+func newDTLSState(conn net.Conn) *DTLSState {
+	c, ok := conn.(*dtls.Conn)
+	if !ok {
+		return nil
+	}
+	state := c.ConnectionState()
+	return &DTLSState{
+		PSKIdentity:      state.IdentityHint,
+		PeerCertificates: state.PeerCertificates,
+	}
+}
+
+func dialDTLS(address string, config *dtls.Config) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return dtls.Dial("udp", addr, config)
+}
+
+func listenDTLS(address string, config *dtls.Config) (net.Listener, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return dtls.Listen("udp", addr, config)
+}
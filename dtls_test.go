@@ -0,0 +1,122 @@
+package coap_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/ironzhang/coap"
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
+)
+
+type TestDTLSHandler struct{}
+
+func (h TestDTLSHandler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	if r.DTLS == nil {
+		w.WriteCode(coap.Unauthorized)
+		return
+	}
+	if len(r.DTLS.PSKIdentity) > 0 {
+		fmt.Fprintf(w, "psk:%s", r.DTLS.PSKIdentity)
+		return
+	}
+	fmt.Fprintf(w, "certs:%d", len(r.DTLS.PeerCertificates))
+}
+
+func ServeTestDTLS(t *testing.T, config *dtls.Config) (addr string, closer func()) {
+	ln, err := dtls.Listen("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, config)
+	if err != nil {
+		t.Fatalf("dtls listen: %v", err)
+	}
+	s := &coap.Server{Handler: TestDTLSHandler{}}
+	go s.ServeDTLS(ln)
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestDTLSPSK(t *testing.T) {
+	psk := func(hint []byte) ([]byte, error) {
+		return []byte{0xAB, 0xC1, 0x23}, nil
+	}
+	addr, closer := ServeTestDTLS(t, &dtls.Config{
+		PSK:             psk,
+		PSKIdentityHint: []byte("server"),
+		CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+	})
+	defer closer()
+
+	client := &coap.Client{
+		DTLSConfig: &dtls.Config{
+			PSK:             psk,
+			PSKIdentityHint: []byte("device-1"),
+			CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+		},
+	}
+	req, err := coap.NewRequest(true, coap.GET, "coaps://"+addr+"/identity", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := client.SendRequest(req)
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if got, want := resp.Status, coap.Content; got != want {
+		t.Errorf("status: %v != %v", got, want)
+	}
+	if got, want := string(resp.Payload), "psk:device-1"; got != want {
+		t.Errorf("payload: %q != %q", got, want)
+	}
+}
+
+func TestDTLSCertificate(t *testing.T) {
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatalf("generate server certificate: %v", err)
+	}
+	clientCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatalf("generate client certificate: %v", err)
+	}
+	addr, closer := ServeTestDTLS(t, &dtls.Config{
+		Certificates:         []tls.Certificate{serverCert},
+		ClientAuth:           dtls.RequireAnyClientCert,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	})
+	defer closer()
+
+	client := &coap.Client{
+		DTLSConfig: &dtls.Config{
+			Certificates:         []tls.Certificate{clientCert},
+			InsecureSkipVerify:   true,
+			ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		},
+	}
+	conn, err := client.Dial("coaps://"+addr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, err := coap.NewRequest(true, coap.GET, "coaps://"+addr+"/identity", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := conn.SendRequest(req)
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if got, want := string(resp.Payload), "certs:1"; got != want {
+		t.Errorf("payload: %q != %q", got, want)
+	}
+}
+
+func TestDTLSWithoutConfig(t *testing.T) {
+	req, err := coap.NewRequest(true, coap.GET, "coaps://127.0.0.1:1/identity", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if _, err = (&coap.Client{}).SendRequest(req); err == nil {
+		t.Errorf("send request without DTLSConfig should fail")
+	}
+}
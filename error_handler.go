@@ -1,7 +1,7 @@
 package coap
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/ironzhang/coap/internal/stack/base"
 )
@@ -16,7 +16,7 @@ func (h errorHandler) handle(s *session, m base.Message, e error) {
 	case base.CON, base.NON:
 		h.handleMSG(s, m, e)
 	default:
-		log.Printf("[%s] ignore %s", h.name, m.String())
+		s.logger().Log(LevelDebug, "ignore message", "handler", h.name, "message", m)
 	}
 }
 
@@ -32,18 +32,18 @@ func (h errorHandler) handleMSG(s *session, m base.Message, e error) {
 	case c >= 2 && c <= 5:
 		h.handleResponse(s, m, e)
 	default:
-		log.Printf("[%s] reserved code: %d.%d", h.name, c, m.Code&0x1f)
+		s.logger().Log(LevelWarn, "reserved code", "handler", h.name, "code", fmt.Sprintf("%d.%02d", c, m.Code&0x1f))
 	}
 }
 
 func (h errorHandler) handleRequest(s *session, m base.Message, e error) {
 	if m.Type == base.CON {
 		if err := h.conRequestHandler(s, m, e); err != nil {
-			log.Printf("[%s] handle con request: %v", h.name, err)
+			s.logger().Log(LevelWarn, "handle con request", "handler", h.name, "error", err)
 		}
 	} else {
 		if err := s.directSendRST(m.MessageID); err != nil {
-			log.Printf("[%s] handle non request: %v", h.name, err)
+			s.logger().Log(LevelWarn, "handle non request", "handler", h.name, "error", err)
 		}
 	}
 }
@@ -51,10 +51,10 @@ func (h errorHandler) handleRequest(s *session, m base.Message, e error) {
 func (h errorHandler) handleResponse(s *session, m base.Message, e error) {
 	if m.Type == base.CON {
 		if err := s.directSendRST(m.MessageID); err != nil {
-			log.Printf("[%s] handle con response: %v", h.name, err)
+			s.logger().Log(LevelWarn, "handle con response", "handler", h.name, "error", err)
 		}
 	} else {
-		log.Printf("[%s] ignore non response: %s", h.name, m.String())
+		s.logger().Log(LevelDebug, "ignore non response", "handler", h.name, "message", m)
 	}
 }
 
@@ -20,7 +20,7 @@ func NewErrorHandlerSession(w io.Writer) *session {
 	if err != nil {
 		panic(err)
 	}
-	return newSession(w, nil, nil, la, ra, "coap")
+	return newSession(w, nil, nil, la, ra, "coap", sessionConfig{})
 }
 
 func TestMessageErrorHandler(t *testing.T) {
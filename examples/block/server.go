@@ -1,12 +1,16 @@
 package main
 
-import "github.com/ironzhang/coap"
+import (
+	"io"
+
+	"github.com/ironzhang/coap"
+)
 
 type Handler struct {
 }
 
 func (h Handler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
-	w.Write(r.Payload)
+	io.Copy(w, r.Body)
 }
 
 func main() {
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"io/ioutil"
+	"io"
 	"log"
+	"os"
 
 	"github.com/ironzhang/coap"
 )
@@ -11,10 +12,21 @@ type Handler struct {
 }
 
 func (h Handler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
-	log.Printf("payload length: %d", len(r.Payload))
-	if err := ioutil.WriteFile("output.html", r.Payload, 0664); err != nil {
+	f, err := os.Create("output.html")
+	if err != nil {
+		log.Printf("create file: %v", err)
+		w.WriteCode(coap.InternalServerError)
+		return
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r.Body)
+	if err != nil {
 		log.Printf("write file: %v", err)
+		w.WriteCode(coap.InternalServerError)
+		return
 	}
+	log.Printf("payload length: %d", n)
 }
 
 func main() {
@@ -8,7 +8,6 @@ import (
 )
 
 func main() {
-	coap.Verbose = 0
 	for i := 0; i < 10; i++ {
 		var client coap.Client
 		req, err := coap.NewRequest(true, coap.POST, "coap://localhost/ping", []byte("ping"))
@@ -14,9 +14,8 @@ func (h Handler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
 }
 
 func main() {
-	coap.Verbose = 2
-
-	if err := coap.ListenAndServe(":5683", Handler{}, nil); err != nil {
+	s := coap.Server{Handler: Handler{}, Trace: coap.TraceFull}
+	if err := s.ListenAndServe(":5683"); err != nil {
 		log.Fatalf("listen and serve: %v", err)
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"log"
 
 	"github.com/ironzhang/coap"
@@ -11,12 +12,12 @@ type Handler struct {
 
 func (h Handler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
 	log.Println(r.URL.String())
-	w.Write(r.Payload)
+	io.Copy(w, r.Body)
 }
 
 func main() {
-	coap.Verbose = 2
-	if err := coap.ListenAndServe(":5683", Handler{}, nil); err != nil {
+	s := coap.Server{Handler: Handler{}, Trace: coap.TraceFull}
+	if err := s.ListenAndServe(":5683"); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -1,3 +1,9 @@
 module github.com/ironzhang/coap
 
 go 1.14
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/pion/dtls/v2 v2.2.12
+	github.com/pion/transport/v2 v2.2.10 // indirect
+)
@@ -44,6 +44,35 @@ func (t *Table) Remove(key string) {
 	b.remove(key)
 }
 
+// Range 遍历表中所有对象, f返回false时停止遍历.
+func (t *Table) Range(f func(Object) bool) {
+	t.mu.Lock()
+	buckets := t.buckets
+	t.mu.Unlock()
+	for i := range buckets {
+		for _, object := range buckets[i].objects() {
+			if !f(object) {
+				return
+			}
+		}
+	}
+}
+
+// Len 返回表中对象数, 已过期但尚未回收的对象也计算在内.
+func (t *Table) Len() int {
+	t.mu.Lock()
+	buckets := t.buckets
+	t.mu.Unlock()
+	n := 0
+	for i := range buckets {
+		b := &buckets[i]
+		b.mu.Lock()
+		n += len(b.m)
+		b.mu.Unlock()
+	}
+	return n
+}
+
 func (t *Table) getBucket(key string) *bucket {
 	t.mu.Lock()
 	if t.buckets == nil {
@@ -98,6 +127,19 @@ func (b *bucket) get(key string) (Object, bool) {
 	return object, ok
 }
 
+func (b *bucket) objects() []Object {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.m) <= 0 {
+		return nil
+	}
+	objects := make([]Object, 0, len(b.m))
+	for _, object := range b.m {
+		objects = append(objects, object)
+	}
+	return objects
+}
+
 func (b *bucket) gc() {
 	if len(b.m) <= b.threshold && time.Since(b.lastGC) < gcInterval {
 		return
@@ -191,3 +191,31 @@ func TestTable(t *testing.T) {
 		t.Errorf("table get objects: %v != %v", got, want)
 	}
 }
+
+func TestTableRange(t *testing.T) {
+	var tb Table
+	var n = 1000
+	var keys = MakeTestKeys(n)
+	TableAddObjects(&tb, keys, time.Minute)
+	if got, want := tb.Len(), n; got != want {
+		t.Errorf("table len: %v != %v", got, want)
+	}
+
+	count := 0
+	tb.Range(func(o Object) bool {
+		count++
+		return true
+	})
+	if got, want := count, n; got != want {
+		t.Errorf("range objects: %v != %v", got, want)
+	}
+
+	count = 0
+	tb.Range(func(o Object) bool {
+		count++
+		return count < 10
+	})
+	if got, want := count, 10; got != want {
+		t.Errorf("range stop: %v != %v", got, want)
+	}
+}
@@ -27,3 +27,50 @@ const (
 const (
 	MAX_BLOCKSIZE = 1024
 )
+
+// Params 传输参数, 衍生时间按RFC 7252 4.8.2计算
+type Params struct {
+	AckTimeout      time.Duration
+	AckRandomFactor float64
+	MaxRetransmit   int
+	MaxLatency      time.Duration
+	ProcessingDelay time.Duration
+	MaxBlockSize    uint32
+}
+
+// DefaultParams 返回协议默认的传输参数
+func DefaultParams() Params {
+	return Params{
+		AckTimeout:      ACK_TIMEOUT,
+		AckRandomFactor: ACK_RANDOM_FACTOR,
+		MaxRetransmit:   MAX_RETRANSMIT,
+		MaxLatency:      MAX_LATENCY,
+		ProcessingDelay: PROCESSING_DELAY,
+		MaxBlockSize:    MAX_BLOCKSIZE,
+	}
+}
+
+// MaxTransmitSpan ACK_TIMEOUT * ((2 ** MAX_RETRANSMIT) - 1) * ACK_RANDOM_FACTOR
+func (p Params) MaxTransmitSpan() time.Duration {
+	return time.Duration(float64(p.AckTimeout) * float64(int(1)<<uint(p.MaxRetransmit)-1) * p.AckRandomFactor)
+}
+
+// MaxTransmitWait ACK_TIMEOUT * ((2 ** (MAX_RETRANSMIT + 1)) - 1) * ACK_RANDOM_FACTOR
+func (p Params) MaxTransmitWait() time.Duration {
+	return time.Duration(float64(p.AckTimeout) * float64(int(1)<<uint(p.MaxRetransmit+1)-1) * p.AckRandomFactor)
+}
+
+// MaxRTT (2 * MAX_LATENCY) + PROCESSING_DELAY
+func (p Params) MaxRTT() time.Duration {
+	return 2*p.MaxLatency + p.ProcessingDelay
+}
+
+// ExchangeLifetime MAX_TRANSMIT_SPAN + (2 * MAX_LATENCY) + PROCESSING_DELAY
+func (p Params) ExchangeLifetime() time.Duration {
+	return p.MaxTransmitSpan() + 2*p.MaxLatency + p.ProcessingDelay
+}
+
+// NonLifetime MAX_TRANSMIT_SPAN + MAX_LATENCY
+func (p Params) NonLifetime() time.Duration {
+	return p.MaxTransmitSpan() + p.MaxLatency
+}
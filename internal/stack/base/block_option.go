@@ -21,6 +21,12 @@ func getBlockOption(m Message, id uint16) (BlockOption, bool) {
 	return ParseBlockOption(v.(uint32)), true
 }
 
+// IsFirstBlock 判断消息是否为块传输的第一块, 即选项id的块号为0且还有后续块
+func IsFirstBlock(m Message, id uint16) bool {
+	opt, ok := getBlockOption(m, id)
+	return ok && opt.Num == 0 && opt.More
+}
+
 func ParseBlockOption(value uint32) BlockOption {
 	return BlockOption{
 		Num:  value >> 4,
@@ -0,0 +1,33 @@
+package base
+
+import "sync/atomic"
+
+// Counter 协议栈统计计数项
+type Counter int
+
+const (
+	CounterRetransmit     Counter = iota // CON消息重传次数
+	CounterAckTimeout                    // 等待ACK超时次数
+	CounterDuplicate                     // 收到的重复CON及NON消息数
+	CounterBlock1Transfer                // 发送或接收的Block1块传输数
+	CounterBlock2Transfer                // 发送或接收的Block2块传输数
+	numCounters
+)
+
+// Counters 协议栈统计计数, 可由多个会话的协议栈共享, 并发安全
+type Counters [numCounters]uint64
+
+// Add 增加计数, c为nil时不计数
+func (c *Counters) Add(k Counter, n uint64) {
+	if c != nil {
+		atomic.AddUint64(&c[k], n)
+	}
+}
+
+// Load 返回计数, c为nil时返回0
+func (c *Counters) Load(k Counter) uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c[k])
+}
@@ -18,17 +18,33 @@ type Sender interface {
 type Setter interface {
 	SetRecver(Recver)
 	SetSender(Sender)
+	SetLogger(Logger)
+	SetCounters(*Counters)
+}
+
+type Canceler interface {
+	// Cancel 取消token对应的请求, 丢弃该请求的重传及块传输状态
+	Cancel(token string)
+}
+
+type Idler interface {
+	// Idle 判断是否没有进行中的消息交互
+	Idle() bool
 }
 
 type Layer interface {
 	Update()
+	Canceler
+	Idler
 	Recver
 	Sender
 	Setter
 }
 
 type BaseLayer struct {
-	Name string
+	Name     string
+	Logger   Logger    // 为nil时使用DefaultLogger
+	Counters *Counters // 为nil时不计数
 	Recver
 	Sender
 }
@@ -41,6 +57,35 @@ func (l *BaseLayer) SetSender(sender Sender) {
 	l.Sender = sender
 }
 
+func (l *BaseLayer) SetLogger(logger Logger) {
+	l.Logger = logger
+}
+
+func (l *BaseLayer) SetCounters(counters *Counters) {
+	l.Counters = counters
+}
+
+// Count 统计计数项加1
+func (l *BaseLayer) Count(k Counter) {
+	l.Counters.Add(k, 1)
+}
+
+// Log 输出日志, 附加协议层名称
+func (l *BaseLayer) Log(level Level, msg string, keyvals ...interface{}) {
+	logger := l.Logger
+	if logger == nil {
+		logger = DefaultLogger
+	}
+	logger.Log(level, msg, append([]interface{}{"layer", l.Name}, keyvals...)...)
+}
+
+func (l *BaseLayer) Cancel(token string) {
+}
+
+func (l *BaseLayer) Idle() bool {
+	return true
+}
+
 func (l *BaseLayer) NewError(cause error) error {
 	return Error{Layer: l.Name, Cause: cause}
 }
@@ -0,0 +1,124 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Level 日志级别
+type Level int8
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "Level(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// Logger 结构化日志接口, keyvals为交替出现的键值对
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// StdLogger 以key=value文本格式输出到log.Logger的Logger
+type StdLogger struct {
+	Logger *log.Logger // 为nil时使用log包的标准Logger
+	Level  Level       // 低于该级别的日志被丢弃
+}
+
+func (l *StdLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.Level {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "level=%s msg=%s", level, quoteValue(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		fmt.Fprintf(&buf, " %v=%s", keyvals[i], quoteValue(formatValue(v)))
+	}
+	if l.Logger != nil {
+		l.Logger.Print(buf.String())
+	} else {
+		log.Print(buf.String())
+	}
+}
+
+func formatValue(v interface{}) string {
+	switch tv := v.(type) {
+	case string:
+		return tv
+	case error:
+		return tv.Error()
+	case fmt.Stringer:
+		return tv.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func quoteValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// DefaultLogger 未设置Logger时使用的Logger, 输出Info及以上级别的日志
+var DefaultLogger Logger = &StdLogger{Level: LevelInfo}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...interface{}) {}
+
+// NopLogger 丢弃所有日志的Logger
+var NopLogger Logger = nopLogger{}
+
+type contextLogger struct {
+	logger  Logger
+	keyvals []interface{}
+}
+
+func (l *contextLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	kvs := make([]interface{}, 0, len(l.keyvals)+len(keyvals))
+	kvs = append(kvs, l.keyvals...)
+	kvs = append(kvs, keyvals...)
+	l.logger.Log(level, msg, kvs...)
+}
+
+// With 返回在每条日志前附加keyvals的Logger, logger为nil时使用DefaultLogger
+func With(logger Logger, keyvals ...interface{}) Logger {
+	if logger == nil {
+		logger = DefaultLogger
+	}
+	if len(keyvals) == 0 {
+		return logger
+	}
+	if l, ok := logger.(*contextLogger); ok {
+		kvs := make([]interface{}, 0, len(l.keyvals)+len(keyvals))
+		kvs = append(kvs, l.keyvals...)
+		kvs = append(kvs, keyvals...)
+		return &contextLogger{logger: l.logger, keyvals: kvs}
+	}
+	return &contextLogger{logger: logger, keyvals: keyvals}
+}
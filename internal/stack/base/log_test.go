@@ -0,0 +1,33 @@
+package base
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := &StdLogger{Logger: log.New(&buf, "", 0), Level: LevelInfo}
+	tests := []struct {
+		logger  Logger
+		level   Level
+		msg     string
+		keyvals []interface{}
+		output  string
+	}{
+		{logger: l, level: LevelDebug, msg: "hidden", output: ""},
+		{logger: l, level: LevelInfo, msg: "recv", keyvals: []interface{}{"type", "CON"}, output: "level=info msg=recv type=CON\n"},
+		{logger: l, level: LevelWarn, msg: "send rst", keyvals: []interface{}{"error", errors.New("io timeout")}, output: "level=warn msg=\"send rst\" error=\"io timeout\"\n"},
+		{logger: l, level: LevelError, msg: "odd", keyvals: []interface{}{"key"}, output: "level=error msg=odd key=(MISSING)\n"},
+		{logger: With(With(l, "peer", "1.2.3.4:5683"), "token", "01"), level: LevelInfo, msg: "req", keyvals: []interface{}{"code", 1}, output: "level=info msg=req peer=1.2.3.4:5683 token=01 code=1\n"},
+	}
+	for i, tt := range tests {
+		buf.Reset()
+		tt.logger.Log(tt.level, tt.msg, tt.keyvals...)
+		if got, want := buf.String(), tt.output; got != want {
+			t.Errorf("case%d: %q != %q", i, got, want)
+		}
+	}
+}
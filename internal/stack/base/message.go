@@ -5,6 +5,8 @@ package base
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -87,6 +89,9 @@ const (
 	POST   = 0<<5 | 2
 	PUT    = 0<<5 | 3
 	DELETE = 0<<5 | 4
+	FETCH  = 0<<5 | 5 // RFC 8132
+	PATCH  = 0<<5 | 6 // RFC 8132
+	IPATCH = 0<<5 | 7 // RFC 8132
 )
 
 // Responses Codes
@@ -106,9 +111,11 @@ const (
 	MethodNotAllowed         = 4<<5 | 5
 	NotAcceptable            = 4<<5 | 6
 	RequestEntityIncomplete  = 4<<5 | 8
+	Conflict                 = 4<<5 | 9
 	PreconditionFailed       = 4<<5 | 12
 	RequestEntityTooLarge    = 4<<5 | 13
 	UnsupportedContentFormat = 4<<5 | 15
+	UnprocessableEntity      = 4<<5 | 22
 
 	InternalServerError  = 5<<5 | 0
 	NotImplemented       = 5<<5 | 1
@@ -118,11 +125,23 @@ const (
 	ProxyingNotSupported = 5<<5 | 5
 )
 
+// Signaling Codes, 仅用于可靠传输(RFC 8323)
+const (
+	CSM     = 7<<5 | 1
+	Ping    = 7<<5 | 2
+	Pong    = 7<<5 | 3
+	Release = 7<<5 | 4
+	Abort   = 7<<5 | 5
+)
+
 var codeNames = [256]string{
 	GET:                      "GET",
 	POST:                     "POST",
 	PUT:                      "PUT",
 	DELETE:                   "DELETE",
+	FETCH:                    "FETCH",
+	PATCH:                    "PATCH",
+	IPATCH:                   "iPATCH",
 	Created:                  "Created",
 	Deleted:                  "Deleted",
 	Valid:                    "Valid",
@@ -137,15 +156,22 @@ var codeNames = [256]string{
 	MethodNotAllowed:         "MethodNotAllowed",
 	NotAcceptable:            "NotAcceptable",
 	RequestEntityIncomplete:  "RequestEntityIncomplete",
+	Conflict:                 "Conflict",
 	PreconditionFailed:       "PreconditionFailed",
 	RequestEntityTooLarge:    "RequestEntityTooLarge",
 	UnsupportedContentFormat: "UnsupportedContentFormat",
+	UnprocessableEntity:      "UnprocessableEntity",
 	InternalServerError:      "InternalServerError",
 	NotImplemented:           "NotImplemented",
 	BadGateway:               "BadGateway",
 	ServiceUnavailable:       "ServiceUnavailable",
 	GatewayTimeout:           "GatewayTimeout",
 	ProxyingNotSupported:     "ProxyingNotSupported",
+	CSM:                      "CSM",
+	Ping:                     "Ping",
+	Pong:                     "Pong",
+	Release:                  "Release",
+	Abort:                    "Abort",
 }
 
 func init() {
@@ -161,6 +187,11 @@ func CodeName(c uint8) string {
 	return codeNames[c]
 }
 
+// IsMethod 判断c是否为已定义的请求方法
+func IsMethod(c uint8) bool {
+	return c >= GET && c <= IPATCH
+}
+
 type fixHeader struct {
 	Flags     uint8
 	Code      uint8
@@ -278,6 +309,29 @@ func (m *Message) Marshal() ([]byte, error) {
 	}
 	buf.WriteString(m.Token)
 
+	// options & payload
+	if err = m.marshalBody(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalBody 编码选项及payload, 不含消息头及token
+func (m *Message) MarshalBody() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.marshalBody(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBody 解码选项及payload, 需预先设置Code
+func (m *Message) UnmarshalBody(data []byte) error {
+	return m.unmarshalBody(bytes.NewBuffer(data))
+}
+
+func (m *Message) marshalBody(buf *bytes.Buffer) error {
 	// options
 	sort.Slice(m.Options, func(i, j int) bool {
 		if m.Options[i].ID == m.Options[j].ID {
@@ -286,14 +340,14 @@ func (m *Message) Marshal() ([]byte, error) {
 		return m.Options[i].ID < m.Options[j].ID
 	})
 	var prev uint16
-	enc := optionEncoder{w: &buf}
+	enc := optionEncoder{w: buf}
 	for _, opt := range m.Options {
 		data, err := optionValueToBytes(opt.Value)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if err = enc.Encode(uint32(opt.ID-prev), data); err != nil {
-			return nil, err
+			return err
 		}
 		prev = opt.ID
 	}
@@ -303,8 +357,7 @@ func (m *Message) Marshal() ([]byte, error) {
 		buf.WriteByte(0xff)
 		buf.Write(m.Payload)
 	}
-
-	return buf.Bytes(), nil
+	return nil
 }
 
 func (m *Message) Unmarshal(data []byte) (err error) {
@@ -342,6 +395,10 @@ func (m *Message) Unmarshal(data []byte) (err error) {
 		m.Token = string(token)
 	}
 
+	return m.unmarshalBody(buf)
+}
+
+func (m *Message) unmarshalBody(buf *bytes.Buffer) error {
 	// options
 	var id uint16
 	var repeat int
@@ -367,6 +424,12 @@ func (m *Message) Unmarshal(data []byte) (err error) {
 			id += uint16(delta)
 		}
 
+		if IsSignal(m.Code) {
+			// 信令消息的选项编号由信令码决定, 不参与选项定义检查
+			val := bytesToSignalOptionValue(m.Code, id, data)
+			m.Options = append(m.Options, Option{ID: id, Value: val})
+			continue
+		}
 		if !recognize(id, data, repeat) {
 			if !Critical(id) {
 				continue
@@ -380,7 +443,7 @@ func (m *Message) Unmarshal(data []byte) (err error) {
 	// payload
 	if buf.Len() > 0 {
 		m.Payload = make([]byte, buf.Len())
-		if _, err = io.ReadFull(buf, m.Payload); err != nil {
+		if _, err := io.ReadFull(buf, m.Payload); err != nil {
 			return err
 		}
 	}
@@ -446,6 +509,8 @@ func optionValueToBytes(v interface{}) ([]byte, error) {
 		return []byte(tv), nil
 	case []byte:
 		return []byte(tv), nil
+	case struct{}:
+		return nil, nil
 	}
 
 	var u uint32
@@ -636,3 +701,46 @@ func (p *MessageStringer) MessageString(m Message) string {
 	}
 	return buf.String()
 }
+
+type jsonOption struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type jsonMessage struct {
+	Type        string       `json:"type"`
+	Code        string       `json:"code"`
+	MessageID   uint16       `json:"messageID"`
+	Token       string       `json:"token,omitempty"`
+	Options     []jsonOption `json:"options,omitempty"`
+	PayloadSize int          `json:"payloadSize"`
+	Payload     string       `json:"payload,omitempty"`
+}
+
+// MessageJSON 返回消息的JSON表示, 不透明选项值及token以十六进制表示, 负载由WritePayload输出
+func (p *MessageStringer) MessageJSON(m Message) string {
+	jm := jsonMessage{
+		Type:        TypeName(m.Type),
+		Code:        CodeName(m.Code),
+		MessageID:   m.MessageID,
+		Token:       TokenString(m.Token),
+		PayloadSize: len(m.Payload),
+	}
+	for _, o := range m.Options {
+		v := o.Value
+		if b, ok := v.([]byte); ok {
+			v = hex.EncodeToString(b)
+		}
+		jm.Options = append(jm.Options, jsonOption{Name: OptionName(o.ID), Value: v})
+	}
+	if p.WritePayload != nil {
+		var buf bytes.Buffer
+		p.WritePayload(&buf, m.Payload)
+		jm.Payload = buf.String()
+	}
+	data, err := json.Marshal(jm)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(data)
+}
@@ -17,6 +17,9 @@ func TestCode(t *testing.T) {
 		{code: POST, want: 2},
 		{code: PUT, want: 3},
 		{code: DELETE, want: 4},
+		{code: FETCH, want: 5},
+		{code: PATCH, want: 6},
+		{code: IPATCH, want: 7},
 
 		{code: Created, want: 65},
 		{code: Deleted, want: 66},
@@ -32,9 +35,11 @@ func TestCode(t *testing.T) {
 		{code: NotFound, want: 132},
 		{code: MethodNotAllowed, want: 133},
 		{code: NotAcceptable, want: 134},
+		{code: Conflict, want: 137},
 		{code: PreconditionFailed, want: 140},
 		{code: RequestEntityTooLarge, want: 141},
 		{code: UnsupportedContentFormat, want: 143},
+		{code: UnprocessableEntity, want: 150},
 
 		{code: InternalServerError, want: 160},
 		{code: NotImplemented, want: 161},
@@ -279,6 +284,32 @@ func TestMessageStringer(t *testing.T) {
 	}
 }
 
+func TestMessageJSON(t *testing.T) {
+	mser := MessageStringer{
+		WritePayload: func(w io.Writer, payload []byte) {
+			fmt.Fprintf(w, "%s", payload)
+		},
+	}
+	m := Message{
+		Type:      CON,
+		Code:      GET,
+		MessageID: 1,
+		Token:     string([]byte{1, 2, 3, 4}),
+		Options: []Option{
+			{URIPath, "temp"},
+			{ETag, []byte{0x0a, 0x0b}},
+			{Accept, uint32(50)},
+		},
+		Payload: []byte("hello"),
+	}
+	want := `{"type":"Confirmable","code":"GET","messageID":1,"token":"01020304",` +
+		`"options":[{"name":"Uri-Path","value":"temp"},{"name":"ETag","value":"0a0b"},{"name":"Accept","value":50}],` +
+		`"payloadSize":5,"payload":"hello"}`
+	if got := mser.MessageJSON(m); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func TestMessageAddOption(t *testing.T) {
 	options := []Option{
 		{1, "1"},
@@ -45,6 +45,12 @@ import "fmt"
 	|  28 |   |   | x |   | Size2 | uint   |    0-4 | (none)  |
 	+-----+---+---+---+---+-------+--------+--------+---------+
 
+	+-----+---+---+---+---+--------+--------+--------+---------+
+	| No. | C | U | N | R | Name   | Format | Length | Default |
+	+-----+---+---+---+---+--------+--------+--------+---------+
+	|   9 | x |   |   |   | OSCORE | opaque | 0-255  | (none)  |
+	+-----+---+---+---+---+--------+--------+--------+---------+
+
 	C=Critical, U=Unsafe, N=No-Cache-Key, R=Repeatable
 */
 const (
@@ -68,6 +74,10 @@ const (
 	Block2  = 23
 	Block1  = 27
 	Size2   = 28
+
+	OSCORE  = 9  // 由oscore包注册
+	QBlock1 = 19 // 由qblock包注册
+	QBlock2 = 31 // 由qblock包注册
 )
 
 // option format
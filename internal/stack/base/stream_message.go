@@ -0,0 +1,215 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 可靠传输消息格式(RFC 8323)
+/*
+	  0   1   2   3   4   5   6   7
+	+---------------+---------------+
+	|               |               |
+	|  Len          |   TKL         |   1 byte
+	|               |               |
+	+---------------+---------------+
+	|                               |
+	|  Extended Length (if any)     |   0-4 bytes
+	|                               |
+	+-------------------------------+
+	|                               |
+	|  Code                         |   1 byte
+	|                               |
+	+-------------------------------+
+	|   Token (if any, TKL bytes) ...
+	+-------------------------------+
+	|   Options (if any) ...
+	+-------------------------------+
+	|1 1 1 1 1 1 1 1|    Payload (if any) ...
+	+-------------------------------+
+
+	Len为Options及Payload(含Payload Marker)的总长度, 取值13/14/15时分别表示后续有1/2/4字节的扩展长度.
+*/
+
+// 信令选项, 选项编号由所属的信令码决定
+const (
+	MaxMessageSize     = 2 // CSM
+	BlockWiseTransfer  = 4 // CSM
+	Custody            = 2 // Ping, Pong
+	AlternativeAddress = 2 // Release
+	HoldOff            = 4 // Release
+	BadCSMOption       = 2 // Abort
+)
+
+// DEFAULT_MAX_MESSAGE_SIZE 未收到对端CSM时使用的最大消息长度
+const DEFAULT_MAX_MESSAGE_SIZE = 1152
+
+// IsSignal 判断是否为信令消息码
+func IsSignal(code uint8) bool {
+	return code>>5 == 7
+}
+
+func bytesToSignalOptionValue(code uint8, id uint16, buf []byte) interface{} {
+	switch {
+	case code == CSM && id == MaxMessageSize,
+		code == Release && id == HoldOff,
+		code == Abort && id == BadCSMOption:
+		if len(buf) <= 4 {
+			return decodeUintVariant(buf)
+		}
+		return buf
+	case code == Release && id == AlternativeAddress:
+		return string(buf)
+	case len(buf) == 0:
+		return struct{}{}
+	default:
+		return buf
+	}
+}
+
+// MarshalTCP 以TCP格式编码消息, 编码时忽略Type及MessageID
+func (m *Message) MarshalTCP() ([]byte, error) {
+	return m.marshalStream(true)
+}
+
+// UnmarshalTCP 解码TCP格式的消息.
+//
+// 可靠传输没有消息类型及消息ID, 请求解码为CON消息, 响应解码为ACK消息.
+func (m *Message) UnmarshalTCP(data []byte) error {
+	return m.unmarshalStream(data, true)
+}
+
+// MarshalWS 以WebSocket格式编码消息, 与TCP格式相同但不包含长度字段
+func (m *Message) MarshalWS() ([]byte, error) {
+	return m.marshalStream(false)
+}
+
+// UnmarshalWS 解码WebSocket格式的消息, data为一个完整的WebSocket二进制帧
+func (m *Message) UnmarshalWS(data []byte) error {
+	return m.unmarshalStream(data, false)
+}
+
+func (m *Message) marshalStream(withLength bool) ([]byte, error) {
+	if len(m.Token) > 8 {
+		return nil, errors.New("invalid token")
+	}
+	var body bytes.Buffer
+	if err := m.marshalBody(&body); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	tkl := uint8(len(m.Token))
+	if withLength {
+		n := uint32(body.Len())
+		switch {
+		case n < 13:
+			buf.WriteByte(uint8(n)<<4 | tkl)
+		case n < 269:
+			buf.WriteByte(13<<4 | tkl)
+			buf.Write(encodeUint8(uint8(n - 13)))
+		case n < 65805:
+			buf.WriteByte(14<<4 | tkl)
+			buf.Write(encodeUint16(uint16(n - 269)))
+		default:
+			buf.WriteByte(15<<4 | tkl)
+			buf.Write(encodeUint32(n - 65805))
+		}
+	} else {
+		buf.WriteByte(tkl)
+	}
+	buf.WriteByte(m.Code)
+	buf.WriteString(m.Token)
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+func (m *Message) unmarshalStream(data []byte, withLength bool) error {
+	buf := bytes.NewBuffer(data)
+	flag, err := buf.ReadByte()
+	if err != nil {
+		return messageFormatError{"short packet"}
+	}
+	if withLength {
+		if _, err = readExtendedLength(buf, flag>>4); err != nil {
+			return messageFormatError{"length truncated"}
+		}
+	} else if flag>>4 != 0 {
+		return messageFormatError{"invalid length"}
+	}
+	if m.Code, err = buf.ReadByte(); err != nil {
+		return messageFormatError{"code truncated"}
+	}
+
+	// token
+	tokenLen := int(flag & 0x0f)
+	if tokenLen > 8 {
+		return messageFormatError{"token length too long"}
+	}
+	if buf.Len() < tokenLen {
+		return messageFormatError{"token truncated"}
+	}
+	if tokenLen > 0 {
+		m.Token = string(buf.Next(tokenLen))
+	}
+
+	switch c := m.Code >> 5; {
+	case c == 0:
+		m.Type = CON
+	case c >= 2 && c <= 5:
+		m.Type = ACK
+	}
+	return m.unmarshalBody(buf)
+}
+
+func readExtendedLength(r io.Reader, l uint8) (uint32, error) {
+	var b [4]byte
+	switch l {
+	case 13:
+		if _, err := io.ReadFull(r, b[:1]); err != nil {
+			return 0, err
+		}
+		return uint32(b[0]) + 13, nil
+	case 14:
+		if _, err := io.ReadFull(r, b[:2]); err != nil {
+			return 0, err
+		}
+		return uint32(binary.BigEndian.Uint16(b[:2])) + 269, nil
+	case 15:
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint32(b[:4]) + 65805, nil
+	default:
+		return uint32(l), nil
+	}
+}
+
+// ReadTCPFrame 从r中读取一个完整的TCP格式消息帧, 消息长度超过maxSize时返回错误
+func ReadTCPFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var head [1]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+
+	var ext bytes.Buffer
+	n, err := readExtendedLength(io.TeeReader(r, &ext), head[0]>>4)
+	if err != nil {
+		return nil, err
+	}
+	size := 1 + int(head[0]&0x0f) + int(n)
+	if maxSize > 0 && size > maxSize {
+		return nil, fmt.Errorf("message size %d exceeds %d", size, maxSize)
+	}
+
+	frame := make([]byte, 1+ext.Len()+size)
+	frame[0] = head[0]
+	copy(frame[1:], ext.Bytes())
+	if _, err = io.ReadFull(r, frame[1+ext.Len():]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
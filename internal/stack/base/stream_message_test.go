@@ -0,0 +1,123 @@
+package base
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTCPMessage(t *testing.T) {
+	tests := []struct {
+		m Message
+		b []byte
+	}{
+		{
+			m: Message{Type: CON, Code: GET},
+			b: []byte{0x00, 0x01},
+		},
+		{
+			m: Message{
+				Type:  CON,
+				Code:  GET,
+				Token: "\x01\x02",
+				Options: []Option{
+					{ID: URIPath, Value: "a"},
+				},
+			},
+			b: []byte{0x22, 0x01, 0x01, 0x02, 0xb1, 0x61},
+		},
+		{
+			m: Message{
+				Type:    ACK,
+				Code:    Content,
+				Payload: []byte("hello"),
+			},
+			b: []byte{0x60, 0x45, 0xff, 0x68, 0x65, 0x6c, 0x6c, 0x6f},
+		},
+		{
+			m: Message{
+				Code: CSM,
+				Options: []Option{
+					{ID: MaxMessageSize, Value: uint32(1152)},
+					{ID: BlockWiseTransfer, Value: struct{}{}},
+				},
+			},
+			b: []byte{0x40, 0xe1, 0x22, 0x04, 0x80, 0x20},
+		},
+	}
+	for i, tt := range tests {
+		b, err := tt.m.MarshalTCP()
+		if err != nil {
+			t.Fatalf("case%d: marshal: %v", i, err)
+		}
+		if got, want := b, tt.b; !bytes.Equal(got, want) {
+			t.Errorf("case%d: % x != % x", i, got, want)
+		}
+
+		var m Message
+		if err = m.UnmarshalTCP(b); err != nil {
+			t.Fatalf("case%d: unmarshal: %v", i, err)
+		}
+		if got, want := m, tt.m; !reflect.DeepEqual(got, want) {
+			t.Errorf("case%d: %v != %v", i, got, want)
+		}
+	}
+}
+
+func TestReadTCPFrame(t *testing.T) {
+	sizes := []int{0, 12, 13, 268, 269, 65804, 65805, 70000}
+
+	var buf bytes.Buffer
+	for _, n := range sizes {
+		m := Message{Code: POST, Token: "t", Payload: bytes.Repeat([]byte{'x'}, n)}
+		b, err := m.MarshalTCP()
+		if err != nil {
+			t.Fatalf("marshal %d: %v", n, err)
+		}
+		buf.Write(b)
+	}
+	for i, n := range sizes {
+		frame, err := ReadTCPFrame(&buf, 0)
+		if err != nil {
+			t.Fatalf("case%d: read frame: %v", i, err)
+		}
+		var m Message
+		if err = m.UnmarshalTCP(frame); err != nil {
+			t.Fatalf("case%d: unmarshal: %v", i, err)
+		}
+		if got, want := len(m.Payload), n; got != want {
+			t.Errorf("case%d: payload length: %d != %d", i, got, want)
+		}
+		if got, want := m.Token, "t"; got != want {
+			t.Errorf("case%d: token: %q != %q", i, got, want)
+		}
+	}
+
+	m := Message{Code: POST, Payload: make([]byte, 100)}
+	b, _ := m.MarshalTCP()
+	if _, err := ReadTCPFrame(bytes.NewReader(b), 64); err == nil {
+		t.Errorf("read frame exceeds max size should fail")
+	}
+}
+
+func TestWSMessage(t *testing.T) {
+	m := Message{Type: ACK, Code: Content, Token: "\x01", Payload: []byte("hi")}
+	b, err := m.MarshalWS()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if got, want := b, []byte{0x01, 0x45, 0x01, 0xff, 0x68, 0x69}; !bytes.Equal(got, want) {
+		t.Errorf("% x != % x", got, want)
+	}
+
+	var got Message
+	if err = got.UnmarshalWS(b); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("%v != %v", got, m)
+	}
+	if err = got.UnmarshalWS([]byte{0x10, 0x45, 0x00}); err == nil {
+		t.Errorf("unmarshal frame with length should fail")
+	}
+}
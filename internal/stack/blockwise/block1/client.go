@@ -11,6 +11,8 @@ type cstate struct {
 	messageID uint16
 	source    base.Message
 	buffer    base.BlockBuffer
+	num       uint32 // 最近发送的块号
+	size      uint32 // 本次交互的块大小
 }
 
 type cstatus struct {
@@ -53,6 +55,26 @@ func (p *cstatus) del(messageID uint16) {
 	}
 }
 
+func (p *cstatus) idle() bool {
+	for _, s := range p.states {
+		if !s.deleted {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *cstatus) delByToken(token string) {
+	for _, s := range p.states {
+		if s.deleted {
+			continue
+		}
+		if s.source.Token == token {
+			s.deleted = true
+		}
+	}
+}
+
 func (p *cstatus) get(messageID uint16) (*cstate, bool) {
 	for _, s := range p.states {
 		if s.deleted {
@@ -78,6 +100,10 @@ func (c *client) init(b *base.BaseLayer, f func() uint16, blockSize uint32) {
 	c.blockSize = blockSize
 }
 
+func (c *client) Cancel(token string) {
+	c.status.delByToken(token)
+}
+
 func (c *client) OnAckTimeout(m base.Message) {
 	if state, ok := c.status.get(m.MessageID); ok {
 		c.status.del(m.MessageID)
@@ -88,14 +114,20 @@ func (c *client) OnAckTimeout(m base.Message) {
 }
 
 func (c *client) Send(m base.Message) error {
-	if len(m.Payload) <= int(c.blockSize) {
+	// 已携带Block1选项的消息由上层驱动块传输
+	if len(m.Payload) <= int(c.blockSize) || m.GetOption(base.Block1) != nil {
+		if base.IsFirstBlock(m, base.Block1) {
+			c.base.Count(base.CounterBlock1Transfer)
+		}
 		return c.base.Send(m)
 	}
+	c.base.Count(base.CounterBlock1Transfer)
 	state, err := c.status.add(m)
 	if err != nil {
 		return c.base.NewError(err)
 	}
-	return c.sendBlockMessage(m.MessageID, state, 0, c.blockSize)
+	state.size = c.blockSize
+	return c.sendBlockMessage(m.MessageID, state, 0, state.size)
 }
 
 func (c *client) Recv(m base.Message) error {
@@ -110,9 +142,13 @@ func (c *client) Recv(m base.Message) error {
 			c.status.del(m.MessageID)
 			return c.base.NewError(base.ErrNoBlock1Option)
 		}
-		c.blockSize = opt.Size
 		if opt.More {
-			return c.sendBlockMessage(c.generator(), state, opt.Num+1, opt.Size)
+			// 服务端要求更小的块大小时, 之后的块按新的大小划分(RFC 7959 2.5)
+			offset := (state.num + 1) * state.size
+			if opt.Size < state.size {
+				state.size = opt.Size
+			}
+			return c.sendBlockMessage(c.generator(), state, offset/state.size, state.size)
 		}
 	}
 	c.status.del(m.MessageID)
@@ -122,6 +158,7 @@ func (c *client) Recv(m base.Message) error {
 
 func (c *client) sendBlockMessage(messageID uint16, state *cstate, num, size uint32) error {
 	state.messageID = messageID
+	state.num = num
 	opt, payload, err := state.buffer.Read(num, size)
 	if err != nil {
 		return c.base.NewError(err)
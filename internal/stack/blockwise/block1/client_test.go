@@ -137,3 +137,57 @@ func TestClient(t *testing.T) {
 		t.Errorf("payload: (%d)%v != (%d)%v", len(got), got, len(want), want)
 	}
 }
+
+func TestClientSmallerBlockSize(t *testing.T) {
+	var id uint16
+	f := func() uint16 { id++; return id }
+	r := &base.CountRecver{}
+	s := &TestSender{}
+	c := NewTestClient(r, s, f, 32)
+	p := MakeTestPayload(100)
+
+	m := base.Message{
+		Type:      base.CON,
+		Code:      base.PUT,
+		MessageID: f(),
+		Token:     "1",
+		Payload:   p,
+	}
+	if err := c.Send(m); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	// 服务端在第一个32字节的块后要求16字节的块, 之后的块号从2开始
+	for _, num := range []uint32{0, 2, 3, 4, 5} {
+		ack := base.Message{
+			Type:      base.ACK,
+			Code:      base.Continue,
+			MessageID: id,
+			Token:     "1",
+		}
+		ack.SetOption(base.Block1, base.BlockOption{Num: num, More: true, Size: 16}.Value())
+		if err := c.Recv(ack); err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+	}
+	ack := base.Message{
+		Type:      base.ACK,
+		Code:      base.Changed,
+		MessageID: id,
+		Token:     "1",
+	}
+	ack.SetOption(base.Block1, base.BlockOption{Num: 6, Size: 16}.Value())
+	if err := c.Recv(ack); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+
+	if got, want := r.Count, 1; got != want {
+		t.Errorf("recv: %d != %d", got, want)
+	}
+	if got, want := s.Count, 6; got != want {
+		t.Errorf("send: %d != %d", got, want)
+	}
+	if got, want := s.Buffer.Bytes(), p; !reflect.DeepEqual(got, want) {
+		t.Errorf("payload: (%d)%v != (%d)%v", len(got), got, len(want), want)
+	}
+}
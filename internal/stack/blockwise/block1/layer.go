@@ -9,20 +9,48 @@ type Layer struct {
 }
 
 func NewLayer(generator func() uint16) *Layer {
-	return new(Layer).init(generator)
+	return NewLayerWithParams(generator, base.DefaultParams())
 }
 
-func (l *Layer) init(generator func() uint16) *Layer {
+func NewLayerWithParams(generator func() uint16, p base.Params) *Layer {
+	return new(Layer).init(generator, p)
+}
+
+func (l *Layer) init(generator func() uint16, p base.Params) *Layer {
 	l.BaseLayer.Name = "block1"
-	l.client.init(&l.BaseLayer, generator, base.MAX_BLOCKSIZE)
-	l.server.init(&l.BaseLayer, base.EXCHANGE_LIFETIME)
+	l.client.init(&l.BaseLayer, generator, p.MaxBlockSize)
+	l.server.init(&l.BaseLayer, p.MaxBlockSize, p.ExchangeLifetime())
 	return l
 }
 
+// SetMaxBodySize 设置接收的请求负载的最大长度, 超过时以RequestEntityTooLarge响应, 为0表示不限制
+func (l *Layer) SetMaxBodySize(n uint32) {
+	l.server.maxBodySize = n
+}
+
+// SetStreamBody 设置是否将收到的Block1请求的块逐个交给上层, 由上层以Continue确认非最后的块,
+// 否则组装出完整的负载后再交给上层
+func (l *Layer) SetStreamBody(on bool) {
+	l.server.stream = on
+}
+
+// SetMaxBlockSize 设置发送请求负载时的最大块大小, 如对端通过CSM告知的最大消息长度所限
+func (l *Layer) SetMaxBlockSize(n uint32) {
+	l.client.blockSize = n
+}
+
 func (l *Layer) Update() {
 	l.server.Update()
 }
 
+func (l *Layer) Idle() bool {
+	return l.client.status.idle() && l.server.status.idle()
+}
+
+func (l *Layer) Cancel(token string) {
+	l.client.Cancel(token)
+}
+
 func (l *Layer) OnAckTimeout(m base.Message) {
 	l.client.OnAckTimeout(m)
 }
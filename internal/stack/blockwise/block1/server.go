@@ -12,11 +12,17 @@ type sstate struct {
 	start     time.Time
 	waitAck   bool
 	token     string
+	size      int // 已收到的负载长度
 	buffer    bytes.Buffer
 	messageID uint16
 	block1    uint32
 }
 
+func (s *sstate) reset() {
+	s.size = 0
+	s.buffer.Reset()
+}
+
 func (s *sstate) WaitAck(messageID uint16, block1 uint32) {
 	s.waitAck = true
 	s.messageID = messageID
@@ -48,7 +54,7 @@ func (p *sstatus) add(token string) *sstate {
 		s.start = time.Now()
 		s.waitAck = false
 		s.token = token
-		s.buffer.Reset()
+		s.reset()
 		return s
 	}
 	s := &sstate{start: time.Now(), token: token}
@@ -70,6 +76,15 @@ func (p *sstatus) del(messageID uint16) {
 	}
 }
 
+// delToken 删除令牌为token的未完成的传输
+func (p *sstatus) delToken(token string) {
+	for _, s := range p.states {
+		if !s.deleted && !s.waitAck && s.token == token {
+			s.deleted = true
+		}
+	}
+}
+
 func (p *sstatus) get(messageID uint16) (*sstate, bool) {
 	for _, s := range p.states {
 		if s.deleted {
@@ -85,6 +100,15 @@ func (p *sstatus) get(messageID uint16) (*sstate, bool) {
 	return nil, false
 }
 
+func (p *sstatus) idle() bool {
+	for _, s := range p.states {
+		if !s.deleted {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *sstatus) update(timeout time.Duration) {
 	for _, s := range p.states {
 		if s.deleted {
@@ -97,13 +121,17 @@ func (p *sstatus) update(timeout time.Duration) {
 }
 
 type server struct {
-	base    *base.BaseLayer
-	timeout time.Duration
-	status  sstatus
+	base        *base.BaseLayer
+	maxSize     uint32 // 接收的最大块大小, 超过时要求对端使用更小的块
+	maxBodySize uint32 // 请求负载的最大长度, 为0表示不限制
+	stream      bool   // 是否将块逐个交给上层, 由上层确认
+	timeout     time.Duration
+	status      sstatus
 }
 
-func (s *server) init(b *base.BaseLayer, timeout time.Duration) {
+func (s *server) init(b *base.BaseLayer, maxSize uint32, timeout time.Duration) {
 	s.base = b
+	s.maxSize = maxSize
 	s.timeout = timeout
 }
 
@@ -112,22 +140,51 @@ func (s *server) Update() {
 }
 
 func (s *server) Recv(m base.Message) error {
+	if size1, ok := m.GetOption(base.Size1).(uint32); ok && s.tooLarge(int(size1)) {
+		return s.ackTooLarge(m.MessageID, m.Token)
+	}
 	opt, ok := base.ParseBlock1Option(m)
 	if !ok {
+		if s.tooLarge(len(m.Payload)) {
+			return s.ackTooLarge(m.MessageID, m.Token)
+		}
 		return s.base.Recv(m)
 	}
 
+	if opt.Num == 0 && opt.More {
+		s.base.Count(base.CounterBlock1Transfer)
+	}
 	state := s.status.add(m.Token)
-	if state.buffer.Len() == int(opt.Num*opt.Size) {
-		state.buffer.Write(m.Payload)
-		if opt.More {
-			return s.ackContinue(m.MessageID, m.Token, opt.Value())
+	if opt.Num == 0 {
+		// 新的块传输, 丢弃同一令牌未完成的传输
+		state.reset()
+	}
+	if state.size != int(opt.Num*opt.Size) {
+		return s.ackIncomplete(m.MessageID, m.Token)
+	}
+	if s.tooLarge(state.size + len(m.Payload)) {
+		state.deleted = true
+		return s.ackTooLarge(m.MessageID, m.Token)
+	}
+	state.size += len(m.Payload)
+	if s.stream {
+		// 块保留Block1选项交给上层, 由上层在块被读取后以Continue确认
+		if !opt.More {
+			state.WaitAck(m.MessageID, opt.Value())
 		}
-		state.WaitAck(m.MessageID, opt.Value())
-		m.Payload = copyBuffer(state.buffer.Bytes())
 		return s.base.Recv(m)
 	}
-	return s.ackIncomplete(m.MessageID, m.Token)
+	state.buffer.Write(m.Payload)
+	if opt.More {
+		// 块大小超过上限时, 以更小的块大小应答, 要求对端之后使用该大小
+		if opt.Size > s.maxSize {
+			opt.Size = s.maxSize
+		}
+		return s.ackContinue(m.MessageID, m.Token, opt.Value())
+	}
+	state.WaitAck(m.MessageID, opt.Value())
+	m.Payload = copyBuffer(state.buffer.Bytes())
+	return s.base.Recv(m)
 }
 
 func (s *server) Send(m base.Message) error {
@@ -136,6 +193,25 @@ func (s *server) Send(m base.Message) error {
 		m.SetOption(base.Block1, state.block1)
 		return s.base.Send(m)
 	}
+	if _, ok := m.GetOption(base.Block1).(uint32); ok && m.Code != base.Continue {
+		// 上层在最后一块之前结束了块传输
+		s.status.delToken(m.Token)
+	}
+	return s.base.Send(m)
+}
+
+func (s *server) tooLarge(n int) bool {
+	return s.maxBodySize > 0 && n > int(s.maxBodySize)
+}
+
+func (s *server) ackTooLarge(messageID uint16, token string) error {
+	m := base.Message{
+		Type:      base.ACK,
+		Code:      base.RequestEntityTooLarge,
+		MessageID: messageID,
+		Token:     token,
+	}
+	m.SetOption(base.Size1, s.maxBodySize)
 	return s.base.Send(m)
 }
 
@@ -3,7 +3,6 @@ package block2
 import (
 	"bytes"
 	"errors"
-	"log"
 
 	"github.com/ironzhang/coap/internal/stack/base"
 )
@@ -54,6 +53,26 @@ func (p *cstatus) del(messageID uint16) {
 	}
 }
 
+func (p *cstatus) idle() bool {
+	for _, s := range p.states {
+		if !s.deleted {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *cstatus) delByToken(token string) {
+	for _, s := range p.states {
+		if s.deleted {
+			continue
+		}
+		if s.source.Token == token {
+			s.deleted = true
+		}
+	}
+}
+
 func (p *cstatus) get(messageID uint16) (*cstate, error) {
 	for _, s := range p.states {
 		if s.deleted {
@@ -77,17 +96,25 @@ func (c *client) init(b *base.BaseLayer, f func() uint16) {
 	c.generator = f
 }
 
+func (c *client) Cancel(token string) {
+	c.status.delByToken(token)
+}
+
 func (c *client) OnAckTimeout(m base.Message) {
 	state, err := c.status.get(m.MessageID)
 	if err == nil {
 		c.status.del(m.MessageID)
 		c.base.OnAckTimeout(state.source)
 	} else {
-		log.Printf("get state: %v", err)
+		c.base.OnAckTimeout(m)
 	}
 }
 
 func (c *client) Send(m base.Message) error {
+	// 已携带Block2选项的请求由上层驱动块传输, 响应原样上传
+	if m.GetOption(base.Block2) != nil {
+		return c.base.Send(m)
+	}
 	if err := c.status.add(m); err != nil {
 		return c.base.NewError(err)
 	}
@@ -95,9 +122,13 @@ func (c *client) Send(m base.Message) error {
 }
 
 func (c *client) Recv(m base.Message) error {
+	if base.IsFirstBlock(m, base.Block2) {
+		c.base.Count(base.CounterBlock2Transfer)
+	}
 	state, err := c.status.get(m.MessageID)
 	if err != nil {
-		return c.base.NewError(err)
+		// 可靠传输中的观察通知等没有对应的请求状态
+		return c.base.Recv(m)
 	}
 
 	opt, ok := base.ParseBlock2Option(m)
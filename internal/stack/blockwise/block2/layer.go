@@ -9,20 +9,37 @@ type Layer struct {
 }
 
 func NewLayer(generator func() uint16) *Layer {
-	return new(Layer).init(generator)
+	return NewLayerWithParams(generator, base.DefaultParams())
 }
 
-func (l *Layer) init(generator func() uint16) *Layer {
+func NewLayerWithParams(generator func() uint16, p base.Params) *Layer {
+	return new(Layer).init(generator, p)
+}
+
+func (l *Layer) init(generator func() uint16, p base.Params) *Layer {
 	l.BaseLayer.Name = "block2"
 	l.client.init(&l.BaseLayer, generator)
-	l.server.init(&l.BaseLayer, base.MAX_BLOCKSIZE, base.EXCHANGE_LIFETIME)
+	l.server.init(&l.BaseLayer, p.MaxBlockSize, p.ExchangeLifetime())
 	return l
 }
 
+// SetMaxBlockSize 设置发送响应负载时的最大块大小, 如对端通过CSM告知的最大消息长度所限
+func (l *Layer) SetMaxBlockSize(n uint32) {
+	l.server.maxSize = n
+}
+
 func (l *Layer) Update() {
 	l.server.Update()
 }
 
+func (l *Layer) Idle() bool {
+	return l.client.status.idle() && len(l.server.status.states) == 0
+}
+
+func (l *Layer) Cancel(token string) {
+	l.client.Cancel(token)
+}
+
 func (l *Layer) OnAckTimeout(m base.Message) {
 	l.client.OnAckTimeout(m)
 }
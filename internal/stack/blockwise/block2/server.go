@@ -10,7 +10,7 @@ import (
 type sstate struct {
 	start  time.Time
 	source base.Message
-	buffer base.BlockBuffer
+	size2  bool // 对端是否要求Size2选项
 }
 
 type sstatus struct {
@@ -24,7 +24,7 @@ func (p *sstatus) add(m base.Message) (*sstate, error) {
 	if _, ok := p.states[m.Token]; ok {
 		return nil, errors.New("token duplicate")
 	}
-	s := &sstate{start: time.Now(), source: m, buffer: m.Payload}
+	s := &sstate{start: time.Now(), source: m}
 	p.states[m.Token] = s
 	return s, nil
 }
@@ -46,67 +46,137 @@ func (p *sstatus) update(timeout time.Duration) {
 	}
 }
 
+// srequest 尚未响应的请求对块传输的要求
+type srequest struct {
+	start  time.Time
+	method uint8
+	num    uint32
+	size   uint32
+	size2  bool
+}
+
 type server struct {
-	base      *base.BaseLayer
-	blockSize uint32
-	timeout   time.Duration
-	status    sstatus
+	base     *base.BaseLayer
+	maxSize  uint32 // 发送的最大块大小
+	timeout  time.Duration
+	status   sstatus
+	requests map[string]srequest
 }
 
-func (s *server) init(b *base.BaseLayer, blockSize uint32, timeout time.Duration) {
+func (s *server) init(b *base.BaseLayer, maxSize uint32, timeout time.Duration) {
 	s.base = b
-	s.blockSize = blockSize
+	s.maxSize = maxSize
 	s.timeout = timeout
+	s.requests = make(map[string]srequest)
 }
 
 func (s *server) Update() {
 	s.status.update(s.timeout)
+	for token, r := range s.requests {
+		if time.Since(r.start) > s.timeout {
+			delete(s.requests, token)
+		}
+	}
+}
+
+// blockSize 返回不超过上限的块大小
+func (s *server) blockSize(size uint32) uint32 {
+	if size == 0 || size > s.maxSize {
+		return s.maxSize
+	}
+	return size
 }
 
 func (s *server) Send(m base.Message) error {
-	if len(m.Payload) <= int(s.blockSize) {
+	r, ok := s.requests[m.Token]
+	if ok {
+		delete(s.requests, m.Token)
+	}
+	// 已携带Block2选项的响应由上层驱动块传输
+	if m.GetOption(base.Block2) != nil {
+		if base.IsFirstBlock(m, base.Block2) {
+			s.base.Count(base.CounterBlock2Transfer)
+		}
+		return s.base.Send(m)
+	}
+	size := s.blockSize(r.size)
+	if len(m.Payload) <= int(size) {
+		if r.size2 {
+			m.SetOption(base.Size2, uint32(len(m.Payload)))
+		}
 		return s.base.Send(m)
 	}
+	// 请求要求的块大小超过上限时, 按上限换算块号; 请求未携带Block2时从第0块开始
+	var num uint32
+	if r.size != 0 {
+		num = r.num * r.size / size
+	}
+
+	// GET及FETCH请求的表示是稳定的, 后续块由Handler重新生成, 不保存传输状态
+	if ok && (r.method == base.GET || r.method == base.FETCH) {
+		_, err := s.sendBlockMessage(m.MessageID, m, num, size, r.size2)
+		return err
+	}
 	state, err := s.status.add(m)
 	if err != nil {
 		return s.base.NewError(err)
 	}
-	return s.sendBlockMessage(m.MessageID, state, 0, s.blockSize)
+	state.size2 = r.size2
+	return s.sendStateBlock(m.MessageID, state, num, size)
 }
 
 func (s *server) Recv(m base.Message) error {
-	state, ok := s.status.get(m.Token)
-	if !ok {
-		opt, ok := base.ParseBlock2Option(m)
-		if ok {
-			s.blockSize = opt.Size
+	opt, hasBlock2 := base.ParseBlock2Option(m)
+	size2 := m.GetOption(base.Size2) != nil
+	if state, ok := s.status.get(m.Token); ok {
+		if hasBlock2 {
+			state.size2 = size2
+			size := s.blockSize(opt.Size)
+			return s.sendStateBlock(m.MessageID, state, opt.Num*opt.Size/size, size)
 		}
-		return s.base.Recv(m)
+		// 同一token的新请求
+		s.status.del(m.Token)
 	}
-	opt, ok := base.ParseBlock2Option(m)
-	if !ok {
-		return s.base.NewError(base.ErrNoBlock2Option)
+
+	// 记录请求方法及要求的块大小, 响应时按该大小分块
+	s.requests[m.Token] = srequest{start: time.Now(), method: m.Code, num: opt.Num, size: opt.Size, size2: size2}
+	return s.base.Recv(m)
+}
+
+func (s *server) sendStateBlock(messageID uint16, state *sstate, num, size uint32) error {
+	more, err := s.sendBlockMessage(messageID, state.source, num, size, state.size2)
+	if !more {
+		s.status.del(state.source.Token)
 	}
-	s.blockSize = opt.Size
-	return s.sendBlockMessage(m.MessageID, state, opt.Num, opt.Size)
+	return err
 }
 
-func (s *server) sendBlockMessage(messageID uint16, state *sstate, num, size uint32) error {
-	opt, payload, err := state.buffer.Read(num, size)
+// sendBlockMessage 发送source负载的第num块, 块号超出范围时以BadOption响应(RFC 7959 2.2)
+func (s *server) sendBlockMessage(messageID uint16, source base.Message, num, size uint32, size2 bool) (bool, error) {
+	opt, payload, err := base.BlockBuffer(source.Payload).Read(num, size)
 	if err != nil {
-		return s.base.NewError(err)
-	}
-	if !opt.More {
-		s.status.del(state.source.Token)
+		m := base.Message{
+			Type:      base.ACK,
+			Code:      base.BadOption,
+			MessageID: messageID,
+			Token:     source.Token,
+		}
+		return false, s.base.Send(m)
 	}
 	m := base.Message{
 		Type:      base.ACK,
-		Code:      state.source.Code,
+		Code:      source.Code,
 		MessageID: messageID,
-		Token:     state.source.Token,
-		Options:   state.source.Options,
+		Token:     source.Token,
+		Options:   source.Options,
 		Payload:   payload,
 	}
 	m.SetOption(base.Block2, opt.Value())
-	return s.base.Send(m)
+	if size2 {
+		m.SetOption(base.Size2, uint32(len(source.Payload)))
+	}
+	if base.IsFirstBlock(m, base.Block2) {
+		s.base.Count(base.CounterBlock2Transfer)
+	}
+	return opt.More, s.base.Send(m)
 }
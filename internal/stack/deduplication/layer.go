@@ -2,7 +2,6 @@ package deduplication
 
 import (
 	"errors"
-	"log"
 	"time"
 
 	"github.com/ironzhang/coap/internal/stack/base"
@@ -48,10 +47,14 @@ type Layer struct {
 }
 
 func NewLayer() *Layer {
+	return NewLayerWithParams(base.DefaultParams())
+}
+
+func NewLayerWithParams(p base.Params) *Layer {
 	return &Layer{
 		BaseLayer:        base.BaseLayer{Name: "deduplication"},
-		NonLifetime:      base.NON_LIFETIME,
-		ExchangeLifetime: base.EXCHANGE_LIFETIME,
+		NonLifetime:      p.NonLifetime(),
+		ExchangeLifetime: p.ExchangeLifetime(),
 		states:           make(map[uint16]*state),
 	}
 }
@@ -73,6 +76,7 @@ func (l *Layer) Recv(m base.Message) error {
 	if !ok {
 		return l.recv(m)
 	}
+	l.Count(base.CounterDuplicate)
 
 	switch {
 	case s.Type == base.NON && m.Type == base.NON:
@@ -88,13 +92,13 @@ func (l *Layer) Recv(m base.Message) error {
 		if msg.Token == "" || msg.Token == m.Token {
 			// 正常情况，回复保存的消息
 			if err := l.BaseLayer.Send(msg); err != nil {
-				log.Printf("send: %v", err)
+				l.Log(base.LevelWarn, "send saved message", "error", err)
 			}
 		} else {
 			// 异常情况，回复RST
-			log.Printf("ingore %s, send rst for duplicate %s", msg, m)
+			l.Log(base.LevelWarn, "duplicate message with different token, send rst", "saved", msg, "message", m)
 			if err := l.BaseLayer.SendRST(m.MessageID); err != nil {
-				log.Printf("send rst: %v", err)
+				l.Log(base.LevelWarn, "send rst", "error", err)
 			}
 		}
 		return nil
@@ -102,13 +106,13 @@ func (l *Layer) Recv(m base.Message) error {
 	case s.Type == base.NON && m.Type == base.CON:
 		// 异常分支，回复RST
 		if err := l.BaseLayer.SendRST(m.MessageID); err != nil {
-			log.Printf("send rst: %v", err)
+			l.Log(base.LevelWarn, "send rst", "error", err)
 		}
 		return nil
 
 	case s.Type == base.CON && m.Type == base.NON:
 		// 异常分支，忽略消息
-		log.Printf("ingore %s, do nothing", m)
+		l.Log(base.LevelDebug, "ignore non-confirmable duplicate", "message", m)
 		return nil
 	}
 	return nil
@@ -163,9 +163,11 @@ func TestRecvTimeout(t *testing.T) {
 func TestRecvMessage(t *testing.T) {
 	r := base.CountRecver{}
 	s := base.CountSender{}
+	c := base.Counters{}
 	l := NewLayer()
 	l.BaseLayer.Recver = &r
 	l.BaseLayer.Sender = &s
+	l.SetCounters(&c)
 
 	tests := []struct {
 		mesgs     []base.Message
@@ -233,6 +235,9 @@ func TestRecvMessage(t *testing.T) {
 		r.Count = 0
 		s.Count = 0
 	}
+	if got, want := c.Load(base.CounterDuplicate), uint64(8); got != want {
+		t.Errorf("duplicate counter: %d != %d", got, want)
+	}
 }
 
 func TestRecvSend(t *testing.T) {
@@ -0,0 +1,214 @@
+package oscore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"sync"
+
+	"github.com/pion/dtls/v2/pkg/crypto/ccm"
+)
+
+// AES-CCM-16-64-128算法参数(RFC 8152 10.2)
+const (
+	algAEAD  = 10
+	keyLen   = 16
+	nonceLen = 13
+	tagLen   = 8
+)
+
+// maxIDLen 发送者ID的最大长度, nonceLen-6
+const maxIDLen = nonceLen - 6
+
+// maxSeq Partial IV最多5个字节
+const maxSeq = 1<<40 - 1
+
+var (
+	ErrIDTooLong       = errors.New("oscore: sender or recipient id too long")
+	ErrSeqExhausted    = errors.New("oscore: sender sequence number exhausted")
+	ErrReplay          = errors.New("oscore: replay detected")
+	ErrDecryption      = errors.New("oscore: decryption failed")
+	ErrInvalidOption   = errors.New("oscore: invalid OSCORE option")
+	ErrContextNotFound = errors.New("oscore: security context not found")
+)
+
+// Context OSCORE安全上下文(RFC 8613 3), 包括公共上下文、发送者上下文及接收者上下文.
+//
+// 发送序号及重放窗口仅保存在内存中, 重启后须重新建立上下文(如更换Master Salt或ID Context).
+type Context struct {
+	SenderID    []byte
+	RecipientID []byte
+	IDContext   []byte
+
+	commonIV  []byte
+	sender    cipher.AEAD
+	recipient cipher.AEAD
+
+	mu     sync.Mutex
+	seq    uint64
+	window replayWindow
+}
+
+// NewContext 由Master Secret等参数派生安全上下文(RFC 8613 3.2), 使用HKDF-SHA256及AES-CCM-16-64-128.
+func NewContext(secret, salt, senderID, recipientID, idContext []byte) (*Context, error) {
+	if len(senderID) > maxIDLen || len(recipientID) > maxIDLen {
+		return nil, ErrIDTooLong
+	}
+	c := &Context{
+		SenderID:    senderID,
+		RecipientID: recipientID,
+		IDContext:   idContext,
+		commonIV:    deriveKey(secret, salt, nil, idContext, "IV", nonceLen),
+	}
+	var err error
+	if c.sender, err = newAEAD(deriveKey(secret, salt, senderID, idContext, "Key", keyLen)); err != nil {
+		return nil, err
+	}
+	if c.recipient, err = newAEAD(deriveKey(secret, salt, recipientID, idContext, "Key", keyLen)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return ccm.NewCCM(block, tagLen, nonceLen)
+}
+
+// deriveKey HKDF-SHA256, info为CBOR数组[id, id_context, alg_aead, type, L]
+func deriveKey(secret, salt, id, idContext []byte, typ string, n int) []byte {
+	var info cborWriter
+	info.array(5)
+	info.bytes(id)
+	if idContext == nil {
+		info.null()
+	} else {
+		info.bytes(idContext)
+	}
+	info.uint(algAEAD)
+	info.text(typ)
+	info.uint(uint64(n))
+	return hkdf(secret, salt, info.buf, n)
+}
+
+func hkdf(secret, salt, info []byte, n int) []byte {
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		expander := hmac.New(sha256.New, prk)
+		expander.Write(t)
+		expander.Write(info)
+		expander.Write([]byte{i})
+		t = expander.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// nonce 由发送者ID及Partial IV构造AEAD nonce(RFC 8613 5.2)
+func (c *Context) nonce(id, piv []byte) []byte {
+	n := make([]byte, nonceLen)
+	n[0] = byte(len(id))
+	copy(n[1+maxIDLen-len(id):], id)
+	copy(n[nonceLen-len(piv):], piv)
+	for i := range n {
+		n[i] ^= c.commonIV[i]
+	}
+	return n
+}
+
+// nextPIV 分配发送序号, 返回其Partial IV编码
+func (c *Context) nextPIV() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seq > maxSeq {
+		return nil, ErrSeqExhausted
+	}
+	piv := encodePIV(c.seq)
+	c.seq++
+	return piv, nil
+}
+
+// checkReplay 检查接收的Partial IV是否重放, accept为true时更新重放窗口
+func (c *Context) checkReplay(piv []byte, accept bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seq := decodePIV(piv)
+	if !c.window.check(seq) {
+		return ErrReplay
+	}
+	if accept {
+		c.window.update(seq)
+	}
+	return nil
+}
+
+// encodePIV 以最少的字节编码序号, 0编码为一个字节
+func encodePIV(seq uint64) []byte {
+	var b []byte
+	for v := seq; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+	return b
+}
+
+func decodePIV(piv []byte) uint64 {
+	var seq uint64
+	for _, b := range piv {
+		seq = seq<<8 | uint64(b)
+	}
+	return seq
+}
+
+// replayWindowSize 重放窗口大小
+const replayWindowSize = 32
+
+// replayWindow 接收序号的滑动窗口(RFC 8613 7.4)
+type replayWindow struct {
+	initialized bool
+	highest     uint64
+	bitmap      uint32 // 第i位表示highest-i已接收
+}
+
+func (w *replayWindow) check(seq uint64) bool {
+	if !w.initialized || seq > w.highest {
+		return true
+	}
+	diff := w.highest - seq
+	if diff >= replayWindowSize {
+		return false
+	}
+	return w.bitmap&(1<<diff) == 0
+}
+
+func (w *replayWindow) update(seq uint64) {
+	if !w.initialized {
+		w.initialized = true
+		w.highest = seq
+		w.bitmap = 1
+		return
+	}
+	if seq > w.highest {
+		shift := seq - w.highest
+		if shift >= replayWindowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.bitmap |= 1
+		w.highest = seq
+		return
+	}
+	w.bitmap |= 1 << (w.highest - seq)
+}
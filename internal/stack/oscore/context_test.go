@@ -0,0 +1,111 @@
+package oscore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// RFC 8613 C.1
+func TestDeriveKey(t *testing.T) {
+	secret := unhex("0102030405060708090a0b0c0d0e0f10")
+	salt := unhex("9e7ca92223786340")
+	tests := []struct {
+		id   []byte
+		typ  string
+		n    int
+		want string
+	}{
+		{id: []byte{}, typ: "Key", n: keyLen, want: "f0910ed7295e6ad4b54fc793154302ff"},
+		{id: []byte{0x01}, typ: "Key", n: keyLen, want: "ffb14e093c94c9cac9471648b4f98710"},
+		{id: []byte{}, typ: "IV", n: nonceLen, want: "4622d4dd6d944168eefb54987c"},
+	}
+	for i, tt := range tests {
+		if got := hex.EncodeToString(deriveKey(secret, salt, tt.id, nil, tt.typ, tt.n)); got != tt.want {
+			t.Errorf("case%d: %s != %s", i, got, tt.want)
+		}
+	}
+}
+
+func TestNonce(t *testing.T) {
+	c, err := NewContext(unhex("0102030405060708090a0b0c0d0e0f10"), unhex("9e7ca92223786340"), []byte{}, []byte{0x01}, nil)
+	if err != nil {
+		t.Fatalf("new context: %v", err)
+	}
+	tests := []struct {
+		id   []byte
+		piv  []byte
+		want string
+	}{
+		{id: c.SenderID, piv: encodePIV(0), want: "4622d4dd6d944168eefb54987c"},
+		{id: c.RecipientID, piv: encodePIV(0), want: "4722d4dd6d944169eefb54987c"},
+		{id: c.SenderID, piv: encodePIV(20), want: "4622d4dd6d944168eefb549868"},
+	}
+	for i, tt := range tests {
+		if got := hex.EncodeToString(c.nonce(tt.id, tt.piv)); got != tt.want {
+			t.Errorf("case%d: %s != %s", i, got, tt.want)
+		}
+	}
+}
+
+func TestOptionValue(t *testing.T) {
+	tests := []struct {
+		value optionValue
+		data  string
+	}{
+		{value: optionValue{}, data: ""},
+		{value: optionValue{piv: []byte{0x14}, kid: []byte{}, hasKid: true}, data: "0914"},
+		{value: optionValue{piv: []byte{0x14}, kid: []byte{0x00}, hasKid: true}, data: "091400"},
+		{value: optionValue{piv: []byte{0x14}, kid: []byte{}, hasKid: true, kidContext: unhex("37cbf3210017a2d3")}, data: "19140837cbf3210017a2d3"},
+		{value: optionValue{piv: []byte{0x00}}, data: "0100"},
+	}
+	for i, tt := range tests {
+		data := tt.value.marshal()
+		if got := hex.EncodeToString(data); got != tt.data {
+			t.Errorf("case%d: marshal: %s != %s", i, got, tt.data)
+		}
+		var v optionValue
+		if err := v.unmarshal(data); err != nil {
+			t.Errorf("case%d: unmarshal: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(v.piv, tt.value.piv) || !bytes.Equal(v.kid, tt.value.kid) || v.hasKid != tt.value.hasKid || !bytes.Equal(v.kidContext, tt.value.kidContext) {
+			t.Errorf("case%d: unmarshal: %+v != %+v", i, v, tt.value)
+		}
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	tests := []struct {
+		seq uint64
+		ok  bool
+	}{
+		{seq: 5, ok: true},
+		{seq: 5, ok: false},
+		{seq: 3, ok: true},
+		{seq: 3, ok: false},
+		{seq: 40, ok: true},
+		{seq: 8, ok: false},
+		{seq: 9, ok: true},
+		{seq: 39, ok: true},
+		{seq: 40, ok: false},
+	}
+	var w replayWindow
+	for i, tt := range tests {
+		ok := w.check(tt.seq)
+		if ok != tt.ok {
+			t.Errorf("case%d: check(%d): %v != %v", i, tt.seq, ok, tt.ok)
+		}
+		if ok {
+			w.update(tt.seq)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package oscore
+
+// cborWriter 生成OSCORE所需的少量CBOR编码(RFC 7049)
+type cborWriter struct {
+	buf []byte
+}
+
+func (w *cborWriter) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, major<<5|byte(n))
+	case n < 1<<8:
+		w.buf = append(w.buf, major<<5|24, byte(n))
+	case n < 1<<16:
+		w.buf = append(w.buf, major<<5|25, byte(n>>8), byte(n))
+	default:
+		w.buf = append(w.buf, major<<5|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func (w *cborWriter) uint(n uint64) {
+	w.head(0, n)
+}
+
+func (w *cborWriter) bytes(b []byte) {
+	w.head(2, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *cborWriter) text(s string) {
+	w.head(3, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *cborWriter) array(n int) {
+	w.head(4, uint64(n))
+}
+
+func (w *cborWriter) null() {
+	w.buf = append(w.buf, 0xf6)
+}
+
+// additionalData 构造AEAD附加数据, 即COSE Enc_structure(RFC 8613 5.4).
+//
+// 请求及其响应均使用请求的kid及Partial IV, 不支持Class I选项.
+func additionalData(kid, piv []byte) []byte {
+	var aad cborWriter
+	aad.array(5)
+	aad.uint(1) // oscore_version
+	aad.array(1)
+	aad.uint(algAEAD)
+	aad.bytes(kid)
+	aad.bytes(piv)
+	aad.bytes(nil)
+
+	var enc cborWriter
+	enc.array(3)
+	enc.text("Encrypt0")
+	enc.bytes(nil)
+	enc.bytes(aad.buf)
+	return enc.buf
+}
+
+// optionValue OSCORE选项值(RFC 8613 6.1)
+/*
+	 0 1 2 3 4 5 6 7 <------------- n bytes -------------->
+	+-+-+-+-+-+-+-+-+--------------------------------------
+	|0 0 0|h|k|  n  |       Partial IV (if any) ...
+	+-+-+-+-+-+-+-+-+--------------------------------------
+
+	 <- 1 byte -> <----- s bytes ------>
+	+------------+----------------------+------------------+
+	| s (if any) | kid context (if any) | kid (if any) ... |
+	+------------+----------------------+------------------+
+*/
+type optionValue struct {
+	piv        []byte
+	kid        []byte
+	hasKid     bool
+	kidContext []byte
+}
+
+func (v optionValue) marshal() []byte {
+	if len(v.piv) == 0 && !v.hasKid && v.kidContext == nil {
+		return []byte{}
+	}
+	flags := byte(len(v.piv))
+	if v.hasKid {
+		flags |= 0x08
+	}
+	if v.kidContext != nil {
+		flags |= 0x10
+	}
+	b := append([]byte{flags}, v.piv...)
+	if v.kidContext != nil {
+		b = append(b, byte(len(v.kidContext)))
+		b = append(b, v.kidContext...)
+	}
+	return append(b, v.kid...)
+}
+
+func (v *optionValue) unmarshal(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	flags := b[0]
+	b = b[1:]
+	n := int(flags & 0x07)
+	if flags&0xe0 != 0 || n > 5 || len(b) < n {
+		return ErrInvalidOption
+	}
+	v.piv, b = b[:n], b[n:]
+	if flags&0x10 != 0 {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return ErrInvalidOption
+		}
+		s := int(b[0])
+		v.kidContext, b = b[1:1+s], b[1+s:]
+	}
+	if flags&0x08 != 0 {
+		v.hasKid = true
+		v.kid = b
+	} else if len(b) > 0 {
+		return ErrInvalidOption
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package oscore
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+var _ base.Layer = &Layer{}
+
+func init() {
+	base.RegisterOptionDef(base.OSCORE, 1, "OSCORE", base.OpaqueValue, 0, 255)
+}
+
+// outerOptions 不加密的外部选项(Class U), 其余选项均加密(Class E).
+//
+// Observe及块传输选项只作为外部选项, 块传输在加密后的消息上进行.
+var outerOptions = map[uint16]bool{
+	base.URIHost:     true,
+	base.Observe:     true,
+	base.URIPort:     true,
+	base.OSCORE:      true,
+	base.Block2:      true,
+	base.Block1:      true,
+	base.Size2:       true,
+	base.ProxyScheme: true,
+	base.Size1:       true,
+}
+
+// exchange 受保护请求的信息, 用于保护或解除保护其响应
+type exchange struct {
+	ctx   *Context
+	kid   []byte
+	piv   []byte
+	nonce []byte
+
+	notified bool   // 是否已收到通知
+	seq      uint64 // 已收到的通知的最大Partial IV
+}
+
+// Lookup 按kid及kid context查找安全上下文
+type Lookup func(kid, kidContext []byte) *Context
+
+// Layer OSCORE(RFC 8613)对象安全层, 位于块传输层之上.
+//
+// client不为nil时, 发出的请求均使用client保护; lookup不为nil时, 只接受受保护的请求,
+// 并使用请求对应的安全上下文保护响应.
+type Layer struct {
+	base.BaseLayer
+	client    *Context
+	lookup    Lookup
+	generator func() uint16
+	requests  map[string]*exchange // 本端发出的受保护请求
+	responses map[string]*exchange // 对端发来的受保护请求
+}
+
+func NewLayer(client *Context, lookup Lookup, generator func() uint16) *Layer {
+	return &Layer{
+		BaseLayer: base.BaseLayer{Name: "oscore"},
+		client:    client,
+		lookup:    lookup,
+		generator: generator,
+		requests:  make(map[string]*exchange),
+		responses: make(map[string]*exchange),
+	}
+}
+
+func (l *Layer) Update() {
+}
+
+// Cancel 删除token对应的受保护请求, 用于取消请求或结束观察关系
+func (l *Layer) Cancel(token string) {
+	delete(l.requests, token)
+	delete(l.responses, token)
+}
+
+func (l *Layer) Recv(m base.Message) error {
+	switch c := m.Code >> 5; {
+	case m.Code == 0 || base.IsSignal(m.Code):
+		return l.BaseLayer.Recv(m)
+	case c == 0:
+		return l.recvRequest(m)
+	default:
+		return l.recvResponse(m)
+	}
+}
+
+func (l *Layer) Send(m base.Message) error {
+	switch c := m.Code >> 5; {
+	case m.Code == 0 || m.Type == base.RST || base.IsSignal(m.Code):
+		return l.BaseLayer.Send(m)
+	case c == 0:
+		return l.sendRequest(m)
+	default:
+		return l.sendResponse(m)
+	}
+}
+
+func (l *Layer) sendRequest(m base.Message) error {
+	if l.client == nil {
+		return l.BaseLayer.Send(m)
+	}
+	ctx := l.client
+	piv, err := ctx.nextPIV()
+	if err != nil {
+		return l.NewError(err)
+	}
+	ex := &exchange{ctx: ctx, kid: ctx.SenderID, piv: piv, nonce: ctx.nonce(ctx.SenderID, piv)}
+	opt := optionValue{piv: piv, kid: ctx.SenderID, hasKid: true, kidContext: ctx.IDContext}
+	code := uint8(base.POST)
+	if m.GetOption(base.Observe) != nil {
+		// 受保护的观察请求以FETCH作为外部请求码
+		code = base.FETCH
+	}
+	p, err := protect(m, code, opt, ex.nonce, ex)
+	if err != nil {
+		return l.NewError(err)
+	}
+	l.requests[m.Token] = ex
+	return l.BaseLayer.Send(p)
+}
+
+func (l *Layer) sendResponse(m base.Message) error {
+	ex, ok := l.responses[m.Token]
+	if !ok {
+		return l.BaseLayer.Send(m)
+	}
+	var opt optionValue
+	nonce := ex.nonce
+	code := uint8(base.Changed)
+	if m.GetOption(base.Observe) != nil {
+		// 通知须使用新的Partial IV, 避免nonce重复
+		piv, err := ex.ctx.nextPIV()
+		if err != nil {
+			return l.NewError(err)
+		}
+		opt.piv = piv
+		nonce = ex.ctx.nonce(ex.ctx.SenderID, piv)
+		code = base.Content
+	} else {
+		delete(l.responses, m.Token)
+	}
+	p, err := protect(m, code, opt, nonce, ex)
+	if err != nil {
+		return l.NewError(err)
+	}
+	return l.BaseLayer.Send(p)
+}
+
+func (l *Layer) recvRequest(m base.Message) error {
+	v, ok := m.GetOption(base.OSCORE).([]byte)
+	if !ok {
+		if l.lookup != nil {
+			return l.reject(m, base.Unauthorized, "OSCORE required")
+		}
+		return l.BaseLayer.Recv(m)
+	}
+	if l.lookup == nil {
+		return l.reject(m, base.BadOption, "OSCORE not supported")
+	}
+
+	var opt optionValue
+	if err := opt.unmarshal(v); err != nil || !opt.hasKid || len(opt.piv) == 0 {
+		return l.reject(m, base.BadRequest, "Invalid OSCORE option")
+	}
+	ctx := l.lookup(opt.kid, opt.kidContext)
+	if ctx == nil {
+		return l.reject(m, base.Unauthorized, "Security context not found")
+	}
+	if err := ctx.checkReplay(opt.piv, false); err != nil {
+		return l.reject(m, base.Unauthorized, "Replay detected")
+	}
+	ex := &exchange{ctx: ctx, kid: opt.kid, piv: opt.piv, nonce: ctx.nonce(opt.kid, opt.piv)}
+	inner, err := unprotect(m, ex.nonce, ex)
+	if err != nil {
+		return l.reject(m, base.BadRequest, "Decryption failed")
+	}
+	if err = ctx.checkReplay(opt.piv, true); err != nil {
+		return l.reject(m, base.Unauthorized, "Replay detected")
+	}
+	l.responses[m.Token] = ex
+	return l.BaseLayer.Recv(inner)
+}
+
+func (l *Layer) recvResponse(m base.Message) error {
+	ex, ok := l.requests[m.Token]
+	if !ok {
+		return l.BaseLayer.Recv(m)
+	}
+	v, ok := m.GetOption(base.OSCORE).([]byte)
+	if !ok {
+		// 对端在解除保护前失败时以不受保护的错误响应回复
+		if c := m.Code >> 5; c == 4 || c == 5 {
+			delete(l.requests, m.Token)
+			return l.BaseLayer.Recv(m)
+		}
+		return l.NewError(ErrInvalidOption)
+	}
+	var opt optionValue
+	if err := opt.unmarshal(v); err != nil {
+		return l.NewError(err)
+	}
+	nonce := ex.nonce
+	if len(opt.piv) > 0 {
+		nonce = ex.ctx.nonce(ex.ctx.RecipientID, opt.piv)
+	}
+	observe := m.GetOption(base.Observe) != nil
+	if observe {
+		// 通知须携带Partial IV, 且须比已收到的通知更新, 拒绝重放及乱序的旧通知(RFC 8613 7.4.1)
+		if len(opt.piv) == 0 {
+			return l.NewError(ErrInvalidOption)
+		}
+		if seq := decodePIV(opt.piv); ex.notified && seq <= ex.seq {
+			if m.Type == base.CON {
+				// 仍以空ACK确认旧的可靠通知, 以免对端重传
+				l.BaseLayer.Send(base.Message{Type: base.ACK, MessageID: m.MessageID})
+			}
+			return l.NewError(ErrReplay)
+		}
+	}
+	inner, err := unprotect(m, nonce, ex)
+	if err != nil {
+		return l.NewError(err)
+	}
+	if observe {
+		ex.notified = true
+		ex.seq = decodePIV(opt.piv)
+	} else {
+		delete(l.requests, m.Token)
+	}
+	return l.BaseLayer.Recv(inner)
+}
+
+// reject 以不受保护的错误响应拒绝请求
+func (l *Layer) reject(m base.Message, code uint8, diagnostic string) error {
+	r := base.Message{
+		Type:      base.NON,
+		Code:      code,
+		MessageID: l.generator(),
+		Token:     m.Token,
+		Payload:   []byte(diagnostic),
+	}
+	if m.Type == base.CON {
+		r.Type = base.ACK
+		r.MessageID = m.MessageID
+	}
+	r.AddOption(base.MaxAge, 0)
+	return l.BaseLayer.Send(r)
+}
+
+// protect 加密消息的请求码/响应码、内部选项及payload, 返回外部消息(RFC 8613 8.1, 8.3)
+func protect(m base.Message, code uint8, opt optionValue, nonce []byte, ex *exchange) (base.Message, error) {
+	outer, inner, err := splitOptions(m.Options)
+	if err != nil {
+		return base.Message{}, err
+	}
+	plain := base.Message{Code: m.Code, Options: inner, Payload: m.Payload}
+	body, err := plain.MarshalBody()
+	if err != nil {
+		return base.Message{}, err
+	}
+	plaintext := append([]byte{m.Code}, body...)
+
+	p := base.Message{
+		Type:      m.Type,
+		Code:      code,
+		MessageID: m.MessageID,
+		Token:     m.Token,
+		Options:   append(outer, base.Option{ID: base.OSCORE, Value: opt.marshal()}),
+		Payload:   ex.ctx.sender.Seal(nil, nonce, plaintext, additionalData(ex.kid, ex.piv)),
+	}
+	return p, nil
+}
+
+// unprotect 解密外部消息, 返回以内部请求码/响应码、外部选项及内部选项构成的消息(RFC 8613 8.2, 8.4)
+func unprotect(m base.Message, nonce []byte, ex *exchange) (base.Message, error) {
+	plaintext, err := ex.ctx.recipient.Open(nil, nonce, m.Payload, additionalData(ex.kid, ex.piv))
+	if err != nil || len(plaintext) < 1 {
+		return base.Message{}, ErrDecryption
+	}
+	inner := base.Message{
+		Type:      m.Type,
+		Code:      plaintext[0],
+		MessageID: m.MessageID,
+		Token:     m.Token,
+	}
+	for _, o := range m.Options {
+		if o.ID != base.OSCORE && outerOptions[o.ID] {
+			inner.Options = append(inner.Options, o)
+		}
+	}
+	if err = inner.UnmarshalBody(plaintext[1:]); err != nil {
+		return base.Message{}, err
+	}
+	return inner, nil
+}
+
+// splitOptions 区分外部选项及内部选项, Proxy-Uri分解为外部的Proxy-Scheme、Uri-Host、Uri-Port
+// 及内部的Uri-Path、Uri-Query(RFC 8613 4.1.3.3)
+func splitOptions(options []base.Option) (outer, inner []base.Option, err error) {
+	for _, o := range options {
+		switch {
+		case o.ID == base.OSCORE:
+		case o.ID == base.ProxyURI:
+			s, _ := o.Value.(string)
+			u, err := url.Parse(s)
+			if err != nil {
+				return nil, nil, err
+			}
+			outer = append(outer, base.Option{ID: base.ProxyScheme, Value: u.Scheme})
+			outer = append(outer, base.Option{ID: base.URIHost, Value: u.Hostname()})
+			if port, err := strconv.ParseUint(u.Port(), 10, 16); err == nil {
+				outer = append(outer, base.Option{ID: base.URIPort, Value: uint32(port)})
+			}
+			for _, seg := range strings.Split(strings.TrimPrefix(u.Path, "/"), "/") {
+				if seg != "" {
+					inner = append(inner, base.Option{ID: base.URIPath, Value: seg})
+				}
+			}
+			if u.RawQuery != "" {
+				for _, q := range strings.Split(u.RawQuery, "&") {
+					inner = append(inner, base.Option{ID: base.URIQuery, Value: q})
+				}
+			}
+		case outerOptions[o.ID]:
+			outer = append(outer, o)
+		default:
+			inner = append(inner, o)
+		}
+	}
+	return outer, inner, nil
+}
@@ -0,0 +1,233 @@
+package oscore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+type recorder struct {
+	messages []base.Message
+}
+
+func (r *recorder) Recv(m base.Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+func (r *recorder) OnAckTimeout(m base.Message) {
+}
+
+func (r *recorder) Send(m base.Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+func (r *recorder) last() base.Message {
+	return r.messages[len(r.messages)-1]
+}
+
+func newTestLayers(t *testing.T) (client, server *Layer) {
+	secret := unhex("0102030405060708090a0b0c0d0e0f10")
+	salt := unhex("9e7ca92223786340")
+	cctx, err := NewContext(secret, salt, []byte{}, []byte{0x01}, nil)
+	if err != nil {
+		t.Fatalf("new client context: %v", err)
+	}
+	sctx, err := NewContext(secret, salt, []byte{0x01}, []byte{}, nil)
+	if err != nil {
+		t.Fatalf("new server context: %v", err)
+	}
+	gen := func() uint16 { return 1 }
+	lookup := func(kid, kidContext []byte) *Context {
+		if bytes.Equal(kid, sctx.RecipientID) {
+			return sctx
+		}
+		return nil
+	}
+	client = NewLayer(cctx, nil, gen)
+	server = NewLayer(nil, lookup, gen)
+	for _, l := range []*Layer{client, server} {
+		r := &recorder{}
+		l.SetRecver(r)
+		l.SetSender(r)
+	}
+	return client, server
+}
+
+func marshal(t *testing.T, m base.Message) string {
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return hex.EncodeToString(data)
+}
+
+func unmarshal(t *testing.T, s string) base.Message {
+	var m base.Message
+	if err := m.Unmarshal(unhex(s)); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return m
+}
+
+// RFC 8613 C.4, C.7
+func TestProtect(t *testing.T) {
+	client, server := newTestLayers(t)
+	client.client.seq = 20
+
+	request := "44015d1f00003974396c6f63616c686f737483747631"
+	protectedRequest := "44025d1f00003974396c6f63616c686f7374620914ff612f1092f1776f1c1668b3825e"
+	response := "64455d1f00003974ff48656c6c6f20576f726c6421"
+	protectedResponse := "64445d1f0000397490ffdbaad1e9a7e7b2a813d3c31524378303cdafae119106"
+
+	if err := client.Send(unmarshal(t, request)); err != nil {
+		t.Fatalf("client send: %v", err)
+	}
+	if got := marshal(t, client.Sender.(*recorder).last()); got != protectedRequest {
+		t.Errorf("protected request: %s != %s", got, protectedRequest)
+	}
+
+	if err := server.Recv(unmarshal(t, protectedRequest)); err != nil {
+		t.Fatalf("server recv: %v", err)
+	}
+	if got := marshal(t, server.Recver.(*recorder).last()); got != request {
+		t.Errorf("unprotected request: %s != %s", got, request)
+	}
+
+	if err := server.Send(unmarshal(t, response)); err != nil {
+		t.Fatalf("server send: %v", err)
+	}
+	if got := marshal(t, server.Sender.(*recorder).last()); got != protectedResponse {
+		t.Errorf("protected response: %s != %s", got, protectedResponse)
+	}
+
+	if err := client.Recv(unmarshal(t, protectedResponse)); err != nil {
+		t.Fatalf("client recv: %v", err)
+	}
+	if got := marshal(t, client.Recver.(*recorder).last()); got != response {
+		t.Errorf("unprotected response: %s != %s", got, response)
+	}
+	if got, want := len(client.requests)+len(server.responses), 0; got != want {
+		t.Errorf("exchanges: %d != %d", got, want)
+	}
+}
+
+func TestReject(t *testing.T) {
+	client, server := newTestLayers(t)
+	request := base.Message{Type: base.CON, Code: base.GET, MessageID: 1, Token: "t"}
+	request.AddOption(base.URIPath, "secret")
+
+	tests := []struct {
+		message func() base.Message
+		code    uint8
+	}{
+		{
+			message: func() base.Message { return request },
+			code:    base.Unauthorized,
+		},
+		{
+			message: func() base.Message {
+				client.Send(request)
+				return client.Sender.(*recorder).last()
+			},
+			code: base.Content,
+		},
+		{
+			// 重放上一个请求
+			message: func() base.Message { return client.Sender.(*recorder).last() },
+			code:    base.Unauthorized,
+		},
+		{
+			message: func() base.Message {
+				client.Send(request)
+				m := client.Sender.(*recorder).last()
+				m.Payload[0] ^= 0xff
+				return m
+			},
+			code: base.BadRequest,
+		},
+	}
+	for i, tt := range tests {
+		sender := server.Sender.(*recorder)
+		recver := server.Recver.(*recorder)
+		sender.messages, recver.messages = nil, nil
+		if err := server.Recv(tt.message()); err != nil {
+			t.Fatalf("case%d: recv: %v", i, err)
+		}
+		if tt.code == base.Content {
+			if len(recver.messages) != 1 || recver.messages[0].GetOption(base.URIPath) != "secret" {
+				t.Errorf("case%d: unprotected request: %v", i, recver.messages)
+			}
+			continue
+		}
+		if len(sender.messages) != 1 || sender.last().Code != tt.code {
+			t.Errorf("case%d: response: %v, want %s", i, sender.messages, base.CodeName(tt.code))
+		}
+	}
+}
+
+func TestNotification(t *testing.T) {
+	client, server := newTestLayers(t)
+	request := base.Message{Type: base.CON, Code: base.GET, MessageID: 1, Token: "t"}
+	request.AddOption(base.Observe, uint32(0))
+	request.AddOption(base.URIPath, "temp")
+	if err := client.Send(request); err != nil {
+		t.Fatalf("client send: %v", err)
+	}
+	if err := server.Recv(client.Sender.(*recorder).last()); err != nil {
+		t.Fatalf("server recv: %v", err)
+	}
+
+	var notifications []base.Message
+	for i := 0; i < 3; i++ {
+		n := base.Message{Type: base.CON, Code: base.Content, MessageID: uint16(10 + i), Token: "t", Payload: []byte{byte(i)}}
+		n.AddOption(base.Observe, uint32(i+2))
+		if err := server.Send(n); err != nil {
+			t.Fatalf("server send notification%d: %v", i, err)
+		}
+		notifications = append(notifications, server.Sender.(*recorder).last())
+	}
+
+	// 只接受比已收到的通知更新的通知
+	tests := []struct {
+		notification base.Message
+		err          error
+	}{
+		{notification: notifications[0], err: nil},
+		{notification: notifications[2], err: nil},
+		{notification: notifications[1], err: ErrReplay},
+		{notification: notifications[0], err: ErrReplay},
+		{notification: notifications[2], err: ErrReplay},
+	}
+	for i, tt := range tests {
+		sender := client.Sender.(*recorder)
+		sender.messages = nil
+		err := client.Recv(tt.notification)
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("case%d: recv: %v", i, err)
+			}
+			continue
+		}
+		if e, ok := err.(base.Error); !ok || e.Cause != tt.err {
+			t.Errorf("case%d: recv: %v != %v", i, err, tt.err)
+		}
+		// 旧的可靠通知仍被确认
+		if len(sender.messages) != 1 || sender.last().Type != base.ACK || sender.last().MessageID != tt.notification.MessageID {
+			t.Errorf("case%d: ack: %v", i, sender.messages)
+		}
+	}
+
+	// 观察关系结束后删除受保护请求的信息
+	server.Cancel("t")
+	final := base.Message{Type: base.CON, Code: base.NotFound, MessageID: 20, Token: "t"}
+	if err := client.Recv(final); err != nil {
+		t.Fatalf("client recv final response: %v", err)
+	}
+	if got, want := len(client.requests)+len(server.responses), 0; got != want {
+		t.Errorf("exchanges: %d != %d", got, want)
+	}
+}
@@ -0,0 +1,278 @@
+package qblock
+
+import (
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// upload Q-Block1上传状态
+type upload struct {
+	source  base.Message // 原始请求
+	size    uint32
+	last    uint32 // 最后一块的块号
+	wait    uint32 // 当前组的最后一块, 等待对端以2.31 Continue应答
+	acked   bool   // 是否收到过对端的Q-Block1应答
+	first   bool   // 第0块是否已发送
+	sent    time.Time
+	retries int
+}
+
+// download Q-Block2下载状态
+type download struct {
+	source    base.Message // 原始请求
+	first     base.Message // 第0块的响应, 重组后作为响应上传
+	hasFirst  bool
+	blocks    blocks
+	size      uint32
+	started   bool   // 是否收到过Q-Block2响应
+	requested uint32 // 最近请求的组的起始块号
+	start     time.Time
+	recv      time.Time
+	retries   int
+}
+
+type client struct {
+	base      *base.BaseLayer
+	generator func() uint16
+	timing
+	uploads   map[string]*upload
+	downloads map[string]*download
+}
+
+func (c *client) init(b *base.BaseLayer, generator func() uint16, t timing) {
+	c.base = b
+	c.generator = generator
+	c.timing = t
+	c.uploads = make(map[string]*upload)
+	c.downloads = make(map[string]*download)
+}
+
+func (c *client) idle() bool {
+	if len(c.uploads) > 0 {
+		return false
+	}
+	for _, d := range c.downloads {
+		if d.started {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *client) Cancel(token string) {
+	delete(c.uploads, token)
+	delete(c.downloads, token)
+}
+
+func (c *client) Update() {
+	for token, u := range c.uploads {
+		if time.Since(u.sent) < c.receiveTimeout {
+			continue
+		}
+		if u.retries >= c.maxRetransmit {
+			delete(c.uploads, token)
+			c.base.OnAckTimeout(u.source)
+			continue
+		}
+		// 重发当前组的最后一块, 促使对端以2.31 Continue或4.08应答
+		u.retries++
+		u.sent = time.Now()
+		if err := c.sendBlock(u, u.wait); err != nil {
+			c.base.Log(base.LevelWarn, "send block", "token", base.TokenString(token), "error", err)
+		}
+	}
+
+	for token, d := range c.downloads {
+		if !d.started {
+			if time.Since(d.start) > c.lifetime {
+				delete(c.downloads, token)
+			}
+			continue
+		}
+		if time.Since(d.recv) < c.receiveTimeout {
+			continue
+		}
+		if d.retries >= c.maxRetransmit {
+			delete(c.downloads, token)
+			c.base.OnAckTimeout(d.source)
+			continue
+		}
+		// 索取缺失的块, 未收到最后一块时同时请求之后的组
+		d.retries++
+		d.recv = time.Now()
+		var opts []base.BlockOption
+		for _, num := range d.blocks.missing(maxPayloads) {
+			opts = append(opts, base.BlockOption{Num: num, Size: d.size})
+		}
+		if d.blocks.last < 0 {
+			opts = append(opts, base.BlockOption{Num: d.blocks.highest + 1, More: true, Size: d.size})
+		}
+		if err := c.requestBlocks(d, opts); err != nil {
+			c.base.Log(base.LevelWarn, "request blocks", "token", base.TokenString(token), "error", err)
+		}
+	}
+}
+
+func (c *client) Send(m base.Message) error {
+	// 已携带块选项的请求由上层驱动块传输
+	for _, id := range []uint16{base.QBlock1, base.QBlock2, base.Block1, base.Block2} {
+		if m.GetOption(id) != nil {
+			return c.base.Send(m)
+		}
+	}
+
+	if len(m.Payload) > int(c.blockSize) {
+		u := &upload{source: m, size: c.blockSize, last: blockCount(len(m.Payload), c.blockSize) - 1}
+		c.uploads[m.Token] = u
+		return c.sendSet(u, 0)
+	}
+	if m.Code == base.GET || m.Code == base.FETCH {
+		// 以Q-Block2选项要求对端以Q-Block2分块响应
+		c.downloads[m.Token] = &download{source: m, blocks: newBlocks(), size: c.blockSize, start: time.Now()}
+		m.SetOption(base.QBlock2, base.BlockOption{Size: c.blockSize}.Value())
+	}
+	return c.base.Send(m)
+}
+
+func (c *client) Recv(m base.Message) error {
+	if u, ok := c.uploads[m.Token]; ok {
+		return c.recvUpload(u, m)
+	}
+	if d, ok := c.downloads[m.Token]; ok {
+		return c.recvDownload(d, m)
+	}
+	return c.base.Recv(m)
+}
+
+func (c *client) recvUpload(u *upload, m base.Message) error {
+	switch {
+	case m.Code == base.Continue:
+		u.acked = true
+		if opt, ok := parseOption(m, base.QBlock1); ok && opt.Num == u.wait && u.wait < u.last {
+			u.retries = 0
+			return c.sendSet(u, u.wait+1)
+		}
+		return nil
+
+	case m.Code == base.RequestEntityIncomplete && m.GetOption(base.ContentFormat) == uint32(MissingBlocksFormat):
+		u.acked = true
+		nums, err := decodeMissing(m.Payload)
+		if err != nil {
+			return c.base.NewError(err)
+		}
+		u.sent = time.Now()
+		for _, num := range nums {
+			if num > u.last {
+				continue
+			}
+			if err = c.sendBlock(u, num); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case m.Code == base.BadOption && !u.acked:
+		// 对端不支持Q-Block1, 改由blockwise块传输层以Block1发送
+		delete(c.uploads, m.Token)
+		s := u.source
+		s.Type = base.CON
+		s.MessageID = c.generator()
+		return c.base.Send(s)
+	}
+
+	delete(c.uploads, m.Token)
+	return c.base.Recv(m)
+}
+
+func (c *client) recvDownload(d *download, m base.Message) error {
+	opt, ok := parseOption(m, base.QBlock2)
+	if !ok {
+		delete(c.downloads, m.Token)
+		if m.Code == base.BadOption && !d.started {
+			// 对端不支持Q-Block2, 去掉Q-Block2选项重发请求
+			s := d.source
+			s.MessageID = c.generator()
+			return c.base.Send(s)
+		}
+		return c.base.Recv(m)
+	}
+
+	d.started = true
+	d.recv = time.Now()
+	d.retries = 0
+	d.size = opt.Size
+	if opt.Num == 0 && !d.hasFirst {
+		d.first, d.hasFirst = m, true
+	}
+	d.blocks.add(opt.Num, opt.More, m.Payload)
+	if d.hasFirst && d.blocks.done() {
+		delete(c.downloads, m.Token)
+		r := d.first
+		r.Payload = d.blocks.bytes()
+		r.DelOption(base.QBlock2)
+		return c.base.Recv(r)
+	}
+
+	// 收齐一组后请求下一组
+	end := setEnd(opt.Num)
+	if d.blocks.last >= 0 && int64(end) >= d.blocks.last {
+		return nil
+	}
+	if end+1 > d.requested && d.blocks.complete(end+1-maxPayloads, end) {
+		d.requested = end + 1
+		return c.requestBlocks(d, []base.BlockOption{{Num: end + 1, More: true, Size: d.size}})
+	}
+	return nil
+}
+
+func (c *client) sendSet(u *upload, start uint32) error {
+	u.wait = setEnd(start)
+	if u.wait > u.last {
+		u.wait = u.last
+	}
+	u.sent = time.Now()
+	for num := start; num <= u.wait; num++ {
+		if err := c.sendBlock(u, num); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBlock 发送第num块, 第0块首次发送时沿用原请求的消息类型及消息ID,
+// 以便不支持Q-Block的对端以BadOption应答, 其余块以NON发送
+func (c *client) sendBlock(u *upload, num uint32) error {
+	payload, more := readBlock(u.source.Payload, num, u.size)
+	m := base.Message{
+		Type:      base.NON,
+		Code:      u.source.Code,
+		MessageID: c.generator(),
+		Token:     u.source.Token,
+		Options:   u.source.Options,
+		Payload:   payload,
+	}
+	if num == 0 && !u.first {
+		u.first = true
+		m.Type, m.MessageID = u.source.Type, u.source.MessageID
+	}
+	m.SetOption(base.QBlock1, base.BlockOption{Num: num, More: more, Size: u.size}.Value())
+	m.SetOption(base.Size1, uint32(len(u.source.Payload)))
+	return c.base.Send(m)
+}
+
+// requestBlocks 以携带多个Q-Block2选项的请求索取块, More为true的选项表示索取该块所在组中的其余块
+func (c *client) requestBlocks(d *download, opts []base.BlockOption) error {
+	m := base.Message{
+		Type:      base.NON,
+		Code:      d.source.Code,
+		MessageID: c.generator(),
+		Token:     d.source.Token,
+		Options:   append([]base.Option(nil), d.source.Options...),
+		Payload:   d.source.Payload,
+	}
+	for _, opt := range opts {
+		m.AddOption(base.QBlock2, opt.Value())
+	}
+	return c.base.Send(m)
+}
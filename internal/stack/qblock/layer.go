@@ -0,0 +1,208 @@
+// Package qblock 实现适用于高丢包、高时延链路的Q-Block1及Q-Block2块传输(RFC 9177).
+//
+// 负载以NON消息成组发送, 每组最多MAX_PAYLOADS块, 一组发完后等待对端的继续请求或2.31 Continue;
+// 接收方以4.08 RequestEntityIncomplete或携带多个Q-Block2选项的请求索取缺失的块.
+package qblock
+
+import (
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+var _ base.Layer = &Layer{}
+
+func init() {
+	base.RegisterOptionDef(base.QBlock1, 1, "Q-Block1", base.UintValue, 0, 3)
+	base.RegisterOptionDef(base.QBlock2, 0, "Q-Block2", base.UintValue, 0, 3)
+}
+
+// Q-Block参数(RFC 9177 7.2)
+const (
+	maxPayloads = 10 // MAX_PAYLOADS, 一组连续发送的最大块数
+)
+
+// MissingBlocksFormat 缺失块列表的Content-Format, 即application/missing-blocks+cbor-seq
+const MissingBlocksFormat = 272
+
+// timing 由传输参数推导的Q-Block时间参数
+type timing struct {
+	blockSize      uint32
+	nonTimeout     time.Duration // NON_TIMEOUT, 发送下一组前的等待时间
+	receiveTimeout time.Duration // NON_RECEIVE_TIMEOUT, 认定块丢失前的等待时间
+	maxRetransmit  int           // NON_MAX_RETRANSMIT
+	lifetime       time.Duration // 传输状态的保留时间
+}
+
+func newTiming(p base.Params) timing {
+	return timing{
+		blockSize:      p.MaxBlockSize,
+		nonTimeout:     p.AckTimeout,
+		receiveTimeout: 4 * p.AckTimeout,
+		maxRetransmit:  p.MaxRetransmit,
+		lifetime:       p.ExchangeLifetime(),
+	}
+}
+
+// Layer Q-Block块传输层, 位于blockwise块传输层之上, 双方均须支持Q-Block.
+type Layer struct {
+	base.BaseLayer
+	client client
+	server server
+}
+
+func NewLayer(generator func() uint16) *Layer {
+	return NewLayerWithParams(generator, base.DefaultParams())
+}
+
+func NewLayerWithParams(generator func() uint16, p base.Params) *Layer {
+	l := &Layer{BaseLayer: base.BaseLayer{Name: "qblock"}}
+	t := newTiming(p)
+	l.client.init(&l.BaseLayer, generator, t)
+	l.server.init(&l.BaseLayer, generator, t)
+	return l
+}
+
+// SetMaxBodySize 设置接收的请求负载的最大长度, 超过时以RequestEntityTooLarge响应, 为0表示不限制
+func (l *Layer) SetMaxBodySize(n uint32) {
+	l.server.maxBodySize = n
+}
+
+func (l *Layer) Update() {
+	l.client.Update()
+	l.server.Update()
+}
+
+func (l *Layer) Idle() bool {
+	return l.client.idle() && l.server.idle()
+}
+
+func (l *Layer) Cancel(token string) {
+	l.client.Cancel(token)
+}
+
+func (l *Layer) OnAckTimeout(m base.Message) {
+	l.client.Cancel(m.Token)
+	l.BaseLayer.OnAckTimeout(m)
+}
+
+func (l *Layer) Recv(m base.Message) error {
+	switch c := m.Code >> 5; {
+	case m.Code == 0 || base.IsSignal(m.Code):
+		return l.BaseLayer.Recv(m)
+	case c == 0:
+		return l.server.Recv(m)
+	case c >= 2 && c <= 5:
+		return l.client.Recv(m)
+	default:
+		return l.BaseLayer.Recv(m)
+	}
+}
+
+func (l *Layer) Send(m base.Message) error {
+	switch c := m.Code >> 5; {
+	case m.Code == 0 || base.IsSignal(m.Code):
+		return l.BaseLayer.Send(m)
+	case c == 0:
+		return l.client.Send(m)
+	case c >= 2 && c <= 5:
+		return l.server.Send(m)
+	default:
+		return l.BaseLayer.Send(m)
+	}
+}
+
+// blocks 已收到的块
+type blocks struct {
+	data    map[uint32][]byte
+	last    int64 // 最后一块的块号, 未收到时为-1
+	highest uint32
+	size    int
+}
+
+func newBlocks() blocks {
+	return blocks{data: make(map[uint32][]byte), last: -1}
+}
+
+// add 保存第num块, 返回是否为新的块
+func (b *blocks) add(num uint32, more bool, payload []byte) bool {
+	if !more {
+		b.last = int64(num)
+	}
+	if _, ok := b.data[num]; ok {
+		return false
+	}
+	b.data[num] = payload
+	b.size += len(payload)
+	if num > b.highest {
+		b.highest = num
+	}
+	return true
+}
+
+// complete 判断是否收齐了[start, end]范围内的块
+func (b *blocks) complete(start, end uint32) bool {
+	for n := start; n <= end; n++ {
+		if _, ok := b.data[n]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *blocks) done() bool {
+	return b.last >= 0 && b.complete(0, uint32(b.last))
+}
+
+// missing 返回已收到的最大块号之前缺失的块, 最多n个
+func (b *blocks) missing(n int) []uint32 {
+	var nums []uint32
+	for i := uint32(0); i < b.highest && len(nums) < n; i++ {
+		if _, ok := b.data[i]; !ok {
+			nums = append(nums, i)
+		}
+	}
+	return nums
+}
+
+func (b *blocks) bytes() []byte {
+	payload := make([]byte, 0, b.size)
+	for n := uint32(0); int64(n) <= b.last; n++ {
+		payload = append(payload, b.data[n]...)
+	}
+	return payload
+}
+
+// setEnd 返回num所在组的最后一块的块号
+func setEnd(num uint32) uint32 {
+	return num - num%maxPayloads + maxPayloads - 1
+}
+
+// blockCount 返回负载分块后的块数, 空负载为一块
+func blockCount(n int, size uint32) uint32 {
+	if n == 0 {
+		return 1
+	}
+	return uint32((n + int(size) - 1) / int(size))
+}
+
+// readBlock 返回负载的第num块
+func readBlock(payload []byte, num, size uint32) ([]byte, bool) {
+	start := int(num * size)
+	if start > len(payload) {
+		return nil, false
+	}
+	end := start + int(size)
+	if end > len(payload) {
+		end = len(payload)
+	}
+	return payload[start:end], end < len(payload)
+}
+
+func parseOption(m base.Message, id uint16) (base.BlockOption, bool) {
+	v, ok := m.GetOption(id).(uint32)
+	if !ok {
+		return base.BlockOption{}, false
+	}
+	return base.ParseBlockOption(v), true
+}
@@ -0,0 +1,219 @@
+package qblock
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+func TestMissing(t *testing.T) {
+	tests := []struct {
+		nums []uint32
+		data []byte
+	}{
+		{nums: nil, data: nil},
+		{nums: []uint32{0, 23}, data: []byte{0x00, 0x17}},
+		{nums: []uint32{24, 255}, data: []byte{0x18, 0x18, 0x18, 0xff}},
+		{nums: []uint32{256, 70000}, data: []byte{0x19, 0x01, 0x00, 0x1a, 0x00, 0x01, 0x11, 0x70}},
+	}
+	for i, tt := range tests {
+		if got, want := encodeMissing(tt.nums), tt.data; !bytes.Equal(got, want) {
+			t.Errorf("case%d: encode: %x != %x", i, got, want)
+		}
+		nums, err := decodeMissing(tt.data)
+		if err != nil {
+			t.Errorf("case%d: decode: %v", i, err)
+			continue
+		}
+		if got, want := nums, tt.nums; !reflect.DeepEqual(got, want) {
+			t.Errorf("case%d: decode: %v != %v", i, got, want)
+		}
+	}
+
+	for i, data := range [][]byte{{0x20}, {0x18}, {0x19, 0x01}, {0x1b, 0, 0, 0, 0, 0, 0, 0, 0}} {
+		if _, err := decodeMissing(data); err == nil {
+			t.Errorf("case%d: decode %x success", i, data)
+		}
+	}
+}
+
+// link 单向链路, 消息先进入队列, 由pump投递给对端, drop返回true的消息被丢弃
+type link struct {
+	queue []base.Message
+	drop  func(m base.Message) bool
+}
+
+func (l *link) Send(m base.Message) error {
+	if l.drop == nil || !l.drop(m) {
+		l.queue = append(l.queue, m)
+	}
+	return nil
+}
+
+func (l *link) pump(r base.Recver) {
+	for len(l.queue) > 0 {
+		m := l.queue[0]
+		l.queue = l.queue[1:]
+		r.Recv(m)
+	}
+}
+
+// endpoint 记录协议层上传的非空消息
+type endpoint struct {
+	messages []base.Message
+	timeouts int
+}
+
+func (e *endpoint) Recv(m base.Message) error {
+	if m.Code != 0 {
+		e.messages = append(e.messages, m)
+	}
+	return nil
+}
+
+func (e *endpoint) OnAckTimeout(m base.Message) {
+	e.timeouts++
+}
+
+// dropOnce 丢弃携带选项id且块号在nums中的消息, 每个块号只丢弃一次
+func dropOnce(id uint16, nums ...uint32) func(m base.Message) bool {
+	dropped := make(map[uint32]bool)
+	return func(m base.Message) bool {
+		opt, ok := parseOption(m, id)
+		if !ok {
+			return false
+		}
+		for _, n := range nums {
+			if opt.Num == n && !dropped[n] {
+				dropped[n] = true
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type pair struct {
+	client, server     *Layer
+	capp, sapp         endpoint
+	toServer, toClient link
+}
+
+func newPair() *pair {
+	p := base.DefaultParams()
+	p.AckTimeout = 2 * time.Millisecond
+	p.MaxBlockSize = 16
+	var seq uint16
+	gen := func() uint16 {
+		seq++
+		return seq
+	}
+
+	var c pair
+	c.client = NewLayerWithParams(gen, p)
+	c.client.SetRecver(&c.capp)
+	c.client.SetSender(&c.toServer)
+	c.server = NewLayerWithParams(gen, p)
+	c.server.SetRecver(&c.sapp)
+	c.server.SetSender(&c.toClient)
+	return &c
+}
+
+// run 投递消息并驱动定时任务, 直到done返回true
+func (p *pair) run(t *testing.T, done func() bool) {
+	for i := 0; i < 500 && !done(); i++ {
+		p.toServer.pump(p.server)
+		p.toClient.pump(p.client)
+		time.Sleep(time.Millisecond)
+		p.client.Update()
+		p.server.Update()
+	}
+	if !done() {
+		t.Fatalf("transfer not completed")
+	}
+}
+
+func TestLossyUpload(t *testing.T) {
+	p := newPair()
+	p.toServer.drop = dropOnce(base.QBlock1, 3, 9, 12, 24)
+
+	payload := bytes.Repeat([]byte("0123456789"), 40)
+	req := base.Message{Type: base.CON, Code: base.POST, MessageID: 100, Token: "upload", Payload: payload}
+	req.SetOption(base.URIPath, "data")
+	if err := p.client.Send(req); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	p.run(t, func() bool { return len(p.sapp.messages) > 0 })
+
+	m := p.sapp.messages[0]
+	if !bytes.Equal(m.Payload, payload) {
+		t.Errorf("payload: %q != %q", m.Payload, payload)
+	}
+	if m.GetOption(base.QBlock1) != nil || m.GetOption(base.URIPath) != "data" {
+		t.Errorf("options: %v", m.Options)
+	}
+
+	resp := base.Message{Type: base.NON, Code: base.Changed, MessageID: 200, Token: m.Token}
+	if err := p.server.Send(resp); err != nil {
+		t.Fatalf("send response: %v", err)
+	}
+	p.run(t, func() bool { return len(p.capp.messages) > 0 })
+	if got, want := p.capp.messages[0].Code, uint8(base.Changed); got != want {
+		t.Errorf("code: %v != %v", got, want)
+	}
+	if !p.client.Idle() || !p.server.Idle() {
+		t.Errorf("layers are not idle")
+	}
+}
+
+func TestLossyDownload(t *testing.T) {
+	p := newPair()
+	p.toClient.drop = dropOnce(base.QBlock2, 4, 10, 11, 24)
+
+	req := base.Message{Type: base.CON, Code: base.GET, MessageID: 100, Token: "download"}
+	if err := p.client.Send(req); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	p.run(t, func() bool { return len(p.sapp.messages) > 0 })
+	if m := p.sapp.messages[0]; m.GetOption(base.QBlock2) != nil {
+		t.Errorf("options: %v", m.Options)
+	}
+
+	payload := bytes.Repeat([]byte("abcdefghij"), 40)
+	resp := base.Message{Type: base.ACK, Code: base.Content, MessageID: 100, Token: req.Token, Payload: payload}
+	if err := p.server.Send(resp); err != nil {
+		t.Fatalf("send response: %v", err)
+	}
+	p.run(t, func() bool { return len(p.capp.messages) > 0 })
+
+	m := p.capp.messages[0]
+	if !bytes.Equal(m.Payload, payload) {
+		t.Errorf("payload: %q != %q", m.Payload, payload)
+	}
+	if m.Type != base.ACK || m.MessageID != 100 || m.GetOption(base.QBlock2) != nil {
+		t.Errorf("response: %v, options: %v", m, m.Options)
+	}
+	if got, want := len(p.capp.messages), 1; got != want {
+		t.Errorf("messages: %d != %d", got, want)
+	}
+	if !p.client.Idle() || !p.server.Idle() {
+		t.Errorf("layers are not idle")
+	}
+}
+
+func TestUploadTimeout(t *testing.T) {
+	p := newPair()
+	p.toServer.drop = func(base.Message) bool { return true }
+
+	req := base.Message{Type: base.NON, Code: base.PUT, MessageID: 100, Token: "timeout", Payload: make([]byte, 100)}
+	if err := p.client.Send(req); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	p.run(t, func() bool { return p.capp.timeouts > 0 })
+	if !p.client.Idle() {
+		t.Errorf("client is not idle")
+	}
+}
@@ -0,0 +1,54 @@
+package qblock
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errMissingFormat = errors.New("invalid missing blocks payload")
+
+// encodeMissing 将缺失的块号编码为CBOR无符号整数序列(RFC 8742)
+func encodeMissing(nums []uint32) []byte {
+	var b []byte
+	for _, n := range nums {
+		switch {
+		case n < 24:
+			b = append(b, byte(n))
+		case n <= 0xff:
+			b = append(b, 0x18, byte(n))
+		case n <= 0xffff:
+			b = append(b, 0x19, byte(n>>8), byte(n))
+		default:
+			b = append(b, 0x1a, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		}
+	}
+	return b
+}
+
+// decodeMissing 解码CBOR无符号整数序列表示的缺失块号
+func decodeMissing(b []byte) ([]uint32, error) {
+	var nums []uint32
+	for len(b) > 0 {
+		if b[0]>>5 != 0 {
+			return nil, errMissingFormat
+		}
+		info := b[0] & 0x1f
+		b = b[1:]
+		switch {
+		case info < 24:
+			nums = append(nums, uint32(info))
+		case info == 24 && len(b) >= 1:
+			nums = append(nums, uint32(b[0]))
+			b = b[1:]
+		case info == 25 && len(b) >= 2:
+			nums = append(nums, uint32(binary.BigEndian.Uint16(b)))
+			b = b[2:]
+		case info == 26 && len(b) >= 4:
+			nums = append(nums, binary.BigEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return nil, errMissingFormat
+		}
+	}
+	return nums, nil
+}
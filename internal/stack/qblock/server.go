@@ -0,0 +1,282 @@
+package qblock
+
+import (
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// assembly Q-Block1接收状态
+type assembly struct {
+	request base.Message // 最近收到的块
+	blocks  blocks
+	recv    time.Time
+	retries int
+}
+
+// transfer Q-Block2发送状态
+type transfer struct {
+	source    base.Message // 完整的响应
+	size      uint32
+	last      uint32 // 最后一块的块号
+	next      uint32 // 下一组的第一块, 大于last表示已全部发送
+	firstSent bool
+	start     time.Time
+	sent      time.Time
+}
+
+// qrequest 要求以Q-Block2响应的请求
+type qrequest struct {
+	start time.Time
+	size  uint32
+}
+
+type server struct {
+	base        *base.BaseLayer
+	generator   func() uint16
+	maxBodySize uint32 // 请求负载的最大长度, 为0表示不限制
+	timing
+	assemblies map[string]*assembly
+	transfers  map[string]*transfer
+	requests   map[string]qrequest
+}
+
+func (s *server) init(b *base.BaseLayer, generator func() uint16, t timing) {
+	s.base = b
+	s.generator = generator
+	s.timing = t
+	s.assemblies = make(map[string]*assembly)
+	s.transfers = make(map[string]*transfer)
+	s.requests = make(map[string]qrequest)
+}
+
+func (s *server) idle() bool {
+	if len(s.assemblies) > 0 {
+		return false
+	}
+	for _, t := range s.transfers {
+		if t.next <= t.last {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *server) Update() {
+	for token, a := range s.assemblies {
+		if time.Since(a.recv) > s.lifetime {
+			delete(s.assemblies, token)
+			continue
+		}
+		if time.Since(a.recv) < s.nonTimeout {
+			continue
+		}
+		nums := a.blocks.missing(int(s.blockSize / 5))
+		if len(nums) == 0 {
+			continue
+		}
+		if a.retries >= s.maxRetransmit {
+			delete(s.assemblies, token)
+			continue
+		}
+		// 以4.08告知对端缺失的块
+		a.retries++
+		a.recv = time.Now()
+		m := s.response(a.request, base.RequestEntityIncomplete, encodeMissing(nums))
+		m.SetOption(base.ContentFormat, uint32(MissingBlocksFormat))
+		if err := s.base.Send(m); err != nil {
+			s.base.Log(base.LevelWarn, "send missing blocks", "token", base.TokenString(token), "error", err)
+		}
+	}
+
+	for token, t := range s.transfers {
+		if t.next <= t.last {
+			// 未收到继续请求时, 等待NON_TIMEOUT后发送下一组
+			if time.Since(t.sent) >= s.nonTimeout {
+				if err := s.sendSet(t, t.next); err != nil {
+					s.base.Log(base.LevelWarn, "send blocks", "token", base.TokenString(token), "error", err)
+				}
+			}
+			continue
+		}
+		if time.Since(t.start) > s.lifetime {
+			delete(s.transfers, token)
+		}
+	}
+
+	for token, r := range s.requests {
+		if time.Since(r.start) > s.lifetime {
+			delete(s.requests, token)
+		}
+	}
+}
+
+func (s *server) Recv(m base.Message) error {
+	if opt, ok := parseOption(m, base.QBlock1); ok {
+		return s.recvBlock(m, opt)
+	}
+	if t, ok := s.transfers[m.Token]; ok && m.GetOption(base.QBlock2) != nil {
+		return s.resend(t, m)
+	}
+	s.record(&m)
+	return s.base.Recv(m)
+}
+
+func (s *server) recvBlock(m base.Message, opt base.BlockOption) error {
+	if size1, ok := m.GetOption(base.Size1).(uint32); ok && s.tooLarge(int(size1)) {
+		delete(s.assemblies, m.Token)
+		return s.replyTooLarge(m)
+	}
+
+	a, ok := s.assemblies[m.Token]
+	if !ok {
+		a = &assembly{blocks: newBlocks()}
+		s.assemblies[m.Token] = a
+	}
+	a.request = m
+	a.recv = time.Now()
+	a.retries = 0
+	if a.blocks.add(opt.Num, opt.More, m.Payload) && s.tooLarge(a.blocks.size) {
+		delete(s.assemblies, m.Token)
+		return s.replyTooLarge(m)
+	}
+
+	if a.blocks.done() {
+		delete(s.assemblies, m.Token)
+		m.Payload = a.blocks.bytes()
+		m.DelOption(base.QBlock1)
+		m.DelOption(base.Size1)
+		s.record(&m)
+		return s.base.Recv(m)
+	}
+
+	// 收齐一组后以2.31 Continue要求对端发送下一组
+	end := setEnd(opt.Num)
+	if (a.blocks.last < 0 || int64(end) < a.blocks.last) && a.blocks.complete(end+1-maxPayloads, end) {
+		return s.reply(m, base.Continue, base.Option{ID: base.QBlock1, Value: base.BlockOption{Num: end, More: true, Size: opt.Size}.Value()})
+	}
+	if m.Type == base.CON {
+		return s.base.Send(base.Message{Type: base.ACK, MessageID: m.MessageID})
+	}
+	return nil
+}
+
+// record 记录携带Q-Block2选项的请求, 并去掉该选项
+func (s *server) record(m *base.Message) {
+	opt, ok := parseOption(*m, base.QBlock2)
+	if !ok {
+		return
+	}
+	m.DelOption(base.QBlock2)
+	s.requests[m.Token] = qrequest{start: time.Now(), size: opt.Size}
+}
+
+// resend 按请求中的Q-Block2选项重发块, More为true的选项表示发送该块所在组中的其余块
+func (s *server) resend(t *transfer, m base.Message) error {
+	for _, v := range m.GetOptions(base.QBlock2) {
+		opt := base.ParseBlockOption(v.(uint32))
+		if opt.Num > t.last {
+			continue
+		}
+		var err error
+		if opt.More {
+			err = s.sendSet(t, opt.Num)
+		} else {
+			err = s.sendBlock(t, opt.Num)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if m.Type == base.CON {
+		return s.base.Send(base.Message{Type: base.ACK, MessageID: m.MessageID})
+	}
+	return nil
+}
+
+func (s *server) Send(m base.Message) error {
+	r, ok := s.requests[m.Token]
+	if !ok {
+		return s.base.Send(m)
+	}
+	delete(s.requests, m.Token)
+
+	size := s.blockSize
+	if r.size > 0 && r.size < size {
+		size = r.size
+	}
+	if len(m.Payload) <= int(size) {
+		return s.base.Send(m)
+	}
+	t := &transfer{
+		source: m,
+		size:   size,
+		last:   blockCount(len(m.Payload), size) - 1,
+		start:  time.Now(),
+	}
+	s.transfers[m.Token] = t
+	return s.sendSet(t, 0)
+}
+
+func (s *server) sendSet(t *transfer, start uint32) error {
+	end := setEnd(start)
+	if end > t.last {
+		end = t.last
+	}
+	t.sent = time.Now()
+	if end+1 > t.next {
+		t.next = end + 1
+	}
+	for num := start; num <= end; num++ {
+		if err := s.sendBlock(t, num); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBlock 发送第num块, 第0块首次发送时沿用原响应的消息类型及消息ID, 其余块以NON发送
+func (s *server) sendBlock(t *transfer, num uint32) error {
+	payload, more := readBlock(t.source.Payload, num, t.size)
+	m := t.source
+	m.Payload = payload
+	m.SetOption(base.QBlock2, base.BlockOption{Num: num, More: more, Size: t.size}.Value())
+	m.SetOption(base.Size2, uint32(len(t.source.Payload)))
+	if num != 0 || t.firstSent {
+		m.Type = base.NON
+		m.MessageID = s.generator()
+	}
+	if num == 0 {
+		t.firstSent = true
+	}
+	return s.base.Send(m)
+}
+
+func (s *server) tooLarge(n int) bool {
+	return s.maxBodySize > 0 && n > int(s.maxBodySize)
+}
+
+func (s *server) replyTooLarge(req base.Message) error {
+	return s.reply(req, base.RequestEntityTooLarge, base.Option{ID: base.Size1, Value: s.maxBodySize})
+}
+
+// reply 应答请求, CON请求以ACK捎带应答
+func (s *server) reply(req base.Message, code uint8, options ...base.Option) error {
+	m := s.response(req, code, nil)
+	m.Options = options
+	if req.Type == base.CON {
+		m.Type = base.ACK
+		m.MessageID = req.MessageID
+	}
+	return s.base.Send(m)
+}
+
+func (s *server) response(req base.Message, code uint8, payload []byte) base.Message {
+	return base.Message{
+		Type:      base.NON,
+		Code:      code,
+		MessageID: s.generator(),
+		Token:     req.Token,
+		Payload:   payload,
+	}
+}
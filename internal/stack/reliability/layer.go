@@ -35,13 +35,17 @@ type Layer struct {
 }
 
 func NewLayer() *Layer {
+	return NewLayerWithParams(base.DefaultParams())
+}
+
+func NewLayerWithParams(p base.Params) *Layer {
 	return &Layer{
 		BaseLayer:       base.BaseLayer{Name: "reliability"},
-		MaxRetransmit:   base.MAX_RETRANSMIT,
-		MaxTransmitSpan: base.MAX_TRANSMIT_SPAN,
-		MaxTransmitWait: base.MAX_TRANSMIT_WAIT,
-		AckTimeout:      base.ACK_TIMEOUT,
-		AckRandomFactor: base.ACK_RANDOM_FACTOR,
+		MaxRetransmit:   p.MaxRetransmit,
+		MaxTransmitSpan: p.MaxTransmitSpan(),
+		MaxTransmitWait: p.MaxTransmitWait(),
+		AckTimeout:      p.AckTimeout,
+		AckRandomFactor: p.AckRandomFactor,
 		states:          make(map[uint16]*state),
 	}
 }
@@ -72,7 +76,8 @@ func (l *Layer) Recv(m base.Message) error {
 	if m.Type != base.ACK && m.Type != base.RST {
 		return l.BaseLayer.Recv(m)
 	}
-	if l.delState(m) {
+	if l.delState(m) || m.Type == base.RST {
+		// RST也可能是对NON消息的拒绝, 需交由上层处理
 		return l.BaseLayer.Recv(m)
 	}
 	return nil
@@ -89,12 +94,25 @@ func (l *Layer) Send(m base.Message) error {
 	return l.send(s)
 }
 
+func (l *Layer) Idle() bool {
+	return len(l.states) == 0
+}
+
+func (l *Layer) Cancel(token string) {
+	for id, s := range l.states {
+		if s.Message.Token == token {
+			delete(l.states, id)
+		}
+	}
+}
+
 func (l *Layer) send(s *state) error {
 	s.LastRetransmit = time.Now()
 	if s.Retransmit == 0 {
 		s.Timeout = l.randAckTimeout()
 	} else {
 		s.Timeout *= 2
+		l.Count(base.CounterRetransmit)
 	}
 	s.Retransmit++
 	return l.BaseLayer.Send(s.Message)
@@ -102,6 +120,7 @@ func (l *Layer) send(s *state) error {
 
 func (l *Layer) doTimeout(s *state) {
 	delete(l.states, s.Message.MessageID)
+	l.Count(base.CounterAckTimeout)
 	l.BaseLayer.OnAckTimeout(s.Message)
 }
 
@@ -51,10 +51,12 @@ func TestRecvAck(t *testing.T) {
 func TestAckTimeout(t *testing.T) {
 	r := base.CountRecver{}
 	s := base.CountSender{}
+	c := base.Counters{}
 	l := NewLayer()
 	l.AckTimeout = 10 * time.Millisecond
 	l.BaseLayer.Recver = &r
 	l.BaseLayer.Sender = &s
+	l.SetCounters(&c)
 
 	m := base.Message{Type: base.CON, Code: base.GET, MessageID: 1}
 	if err := l.Send(m); err != nil {
@@ -67,4 +69,35 @@ func TestAckTimeout(t *testing.T) {
 	if got, want := s.Count, l.MaxRetransmit; got != want {
 		t.Errorf("Retransmit: %d != %d", got, want)
 	}
+	if got, want := c.Load(base.CounterRetransmit), uint64(l.MaxRetransmit-1); got != want {
+		t.Errorf("retransmit counter: %d != %d", got, want)
+	}
+	if got, want := c.Load(base.CounterAckTimeout), uint64(1); got != want {
+		t.Errorf("ack timeout counter: %d != %d", got, want)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	r := base.CountRecver{}
+	s := base.CountSender{}
+	l := NewLayer()
+	l.AckTimeout = 10 * time.Millisecond
+	l.BaseLayer.Recver = &r
+	l.BaseLayer.Sender = &s
+
+	m := base.Message{Type: base.CON, Code: base.GET, MessageID: 1, Token: "token"}
+	if err := l.Send(m); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	l.Cancel("token")
+	for i := 0; i < 5; i++ {
+		time.Sleep(2 * l.AckTimeout)
+		l.Update()
+	}
+	if got, want := s.Count, 1; got != want {
+		t.Errorf("Retransmit: %d != %d", got, want)
+	}
+	if got, want := r.Timeout, 0; got != want {
+		t.Errorf("Timeout: %d != %d", got, want)
+	}
 }
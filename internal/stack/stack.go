@@ -6,6 +6,7 @@ import (
 	"github.com/ironzhang/coap/internal/stack/blockwise/block2"
 	"github.com/ironzhang/coap/internal/stack/deduplication"
 	"github.com/ironzhang/coap/internal/stack/reliability"
+	"github.com/ironzhang/coap/internal/stack/stream"
 )
 
 // Stack coap协议栈
@@ -15,15 +16,26 @@ type Stack struct {
 	layers []base.Layer
 }
 
-func (s *Stack) Init(recver base.Recver, sender base.Sender, genMessageID func() uint16) *Stack {
-	s.recver, s.sender, s.layers = makeLayers(
-		recver,
-		sender,
-		deduplication.NewLayer(),
-		reliability.NewLayer(),
-		block1.NewLayer(genMessageID),
-		block2.NewLayer(genMessageID),
-	)
+// Init 初始化协议栈, top为位于块传输层之上的可选协议层, 如OSCORE.
+func (s *Stack) Init(recver base.Recver, sender base.Sender, genMessageID func() uint16, p base.Params, top ...base.Layer) *Stack {
+	layers := []base.Layer{
+		deduplication.NewLayerWithParams(p),
+		reliability.NewLayerWithParams(p),
+		block1.NewLayerWithParams(genMessageID, p),
+		block2.NewLayerWithParams(genMessageID, p),
+	}
+	s.recver, s.sender, s.layers = makeLayers(recver, sender, append(layers, top...)...)
+	return s
+}
+
+// InitStream 初始化可靠传输(RFC 8323)协议栈, 可靠传输不需要消息去重及重传.
+func (s *Stack) InitStream(recver base.Recver, sender base.Sender, genMessageID func() uint16, p base.Params, top ...base.Layer) *Stack {
+	layers := []base.Layer{
+		stream.NewLayer(genMessageID),
+		block1.NewLayerWithParams(genMessageID, p),
+		block2.NewLayerWithParams(genMessageID, p),
+	}
+	s.recver, s.sender, s.layers = makeLayers(recver, sender, append(layers, top...)...)
 	return s
 }
 
@@ -35,6 +47,64 @@ func (s *Stack) Send(m base.Message) error {
 	return s.sender.Send(m)
 }
 
+// Cancel 取消token对应的请求
+func (s *Stack) Cancel(token string) {
+	for _, l := range s.layers {
+		l.Cancel(token)
+	}
+}
+
+// Idle 判断协议栈是否没有进行中的消息交互, 如等待ACK的可靠消息及未完成的块传输
+func (s *Stack) Idle() bool {
+	for _, l := range s.layers {
+		if !l.Idle() {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLogger 设置各协议层使用的Logger
+func (s *Stack) SetLogger(l base.Logger) {
+	for _, layer := range s.layers {
+		layer.SetLogger(l)
+	}
+}
+
+// SetCounters 设置各协议层使用的统计计数
+func (s *Stack) SetCounters(c *base.Counters) {
+	for _, layer := range s.layers {
+		layer.SetCounters(c)
+	}
+}
+
+// SetMaxBodySize 设置接收的请求负载的最大长度, 为0表示不限制
+func (s *Stack) SetMaxBodySize(n uint32) {
+	for _, layer := range s.layers {
+		if l, ok := layer.(interface{ SetMaxBodySize(uint32) }); ok {
+			l.SetMaxBodySize(n)
+		}
+	}
+}
+
+// SetStreamBody 设置是否将收到的Block1请求的块逐个交给上层
+func (s *Stack) SetStreamBody(on bool) {
+	for _, layer := range s.layers {
+		if l, ok := layer.(interface{ SetStreamBody(bool) }); ok {
+			l.SetStreamBody(on)
+		}
+	}
+}
+
+// SetMaxBlockSize 设置发送负载时的最大块大小
+func (s *Stack) SetMaxBlockSize(n uint32) {
+	for _, layer := range s.layers {
+		if l, ok := layer.(interface{ SetMaxBlockSize(uint32) }); ok {
+			l.SetMaxBlockSize(n)
+		}
+	}
+}
+
 func (s *Stack) Update() {
 	for _, l := range s.layers {
 		l.Update()
@@ -0,0 +1,61 @@
+package stream
+
+import "github.com/ironzhang/coap/internal/stack/base"
+
+var _ base.Layer = &Layer{}
+
+// Layer 可靠传输(RFC 8323)适配层.
+//
+// 可靠传输的消息没有消息类型及消息ID, 该层为收到的请求分配消息ID,
+// 并按token将响应关联到请求的消息ID, 使上层的块传输逻辑得以复用.
+type Layer struct {
+	base.BaseLayer
+	generator func() uint16
+	requests  map[string]uint16
+}
+
+func NewLayer(generator func() uint16) *Layer {
+	return &Layer{
+		BaseLayer: base.BaseLayer{Name: "stream"},
+		generator: generator,
+		requests:  make(map[string]uint16),
+	}
+}
+
+func (l *Layer) Update() {
+}
+
+func (l *Layer) Cancel(token string) {
+	delete(l.requests, token)
+}
+
+func (l *Layer) Recv(m base.Message) error {
+	switch c := m.Code >> 5; {
+	case m.Code == 0:
+		// 空消息
+		return nil
+	case c == 0:
+		m.Type = base.CON
+		m.MessageID = l.generator()
+	case c >= 2 && c <= 5:
+		m.Type = base.ACK
+		if id, ok := l.requests[m.Token]; ok {
+			m.MessageID = id
+			if m.GetOption(base.Observe) == nil {
+				delete(l.requests, m.Token)
+			}
+		}
+	}
+	return l.BaseLayer.Recv(m)
+}
+
+func (l *Layer) Send(m base.Message) error {
+	switch c := m.Code >> 5; {
+	case m.Code == 0, m.Type == base.RST:
+		// 可靠传输不需要空ACK及RST
+		return nil
+	case c == 0:
+		l.requests[m.Token] = m.MessageID
+	}
+	return l.BaseLayer.Send(m)
+}
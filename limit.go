@@ -0,0 +1,33 @@
+package coap
+
+// defaultBusyMaxAge 超过并发上限时ServiceUnavailable响应的默认Max-Age(秒)
+const defaultBusyMaxAge = 1
+
+// semaphore 限制并发数的计数信号量, 为nil时不限制
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// tryAcquire 非阻塞地获取信号量, 达到上限时返回false
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
@@ -0,0 +1,92 @@
+package coap_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap"
+)
+
+func TestServerMaxSessionHandlers(t *testing.T) {
+	release := make(chan struct{})
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte(r.URL.Path))
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h, MaxSessionHandlers: 2, BusyMaxAge: 5}).Serve("coap", ln)
+
+	urlstr := "coap://" + ln.LocalAddr().String()
+	conn, err := coap.DefaultClient.Dial(urlstr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	send := func(path string) (*coap.Response, error) {
+		req, err := coap.NewRequest(true, coap.GET, urlstr+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		return conn.SendRequest(req)
+	}
+
+	// 慢请求不阻塞同一会话的其他请求
+	slowc := make(chan *coap.Response, 1)
+	go func() {
+		resp, err := send("/slow")
+		if err != nil {
+			t.Errorf("send slow request: %v", err)
+		}
+		slowc <- resp
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	tests := []struct {
+		path string
+		code coap.Code
+	}{
+		{path: "/a", code: coap.Content},
+		{path: "/b", code: coap.Content},
+	}
+	for i, tt := range tests {
+		resp, err := send(tt.path)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := resp.Status, tt.code; got != want {
+			t.Errorf("case%d: code: %v != %v", i, got, want)
+		}
+	}
+
+	// 第二个慢请求占满并发上限后, 新的请求被拒绝
+	go send("/slow")
+	time.Sleep(50 * time.Millisecond)
+	resp, err := send("/fast")
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if got, want := resp.Status, coap.ServiceUnavailable; got != want {
+		t.Errorf("code: %v != %v", got, want)
+	}
+	if got, want := resp.Options.Get(coap.MaxAge), uint32(5); got != want {
+		t.Errorf("max age: %v != %v", got, want)
+	}
+
+	close(release)
+	select {
+	case resp := <-slowc:
+		if resp != nil && string(resp.Payload) != "/slow" {
+			t.Errorf("payload: %q != %q", resp.Payload, "/slow")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("slow request not finished")
+	}
+}
@@ -0,0 +1,284 @@
+// Package linkformat 实现了CoRE Link Format(RFC 6690)的解析及序列化.
+package linkformat
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 常用链接属性
+const (
+	ResourceType  = "rt"
+	Interface     = "if"
+	ContentFormat = "ct"
+	Size          = "sz"
+	Title         = "title"
+	Observable    = "obs"
+)
+
+// Link 一个link-format链接, 如</sensors/temp>;rt="temperature-c";if="sensor";ct=0;obs
+type Link struct {
+	URI    string
+	Params map[string]string // 链接属性, 无值的属性(如obs)值为空字符串
+}
+
+// Get 返回属性name的值
+func (l Link) Get(name string) (string, bool) {
+	v, ok := l.Params[name]
+	return v, ok
+}
+
+// Set 设置属性name的值
+func (l *Link) Set(name, value string) {
+	if l.Params == nil {
+		l.Params = make(map[string]string)
+	}
+	l.Params[name] = value
+}
+
+// ResourceTypes 返回rt属性, 多个值以空格分隔
+func (l Link) ResourceTypes() []string {
+	return strings.Fields(l.Params[ResourceType])
+}
+
+// Interfaces 返回if属性, 多个值以空格分隔
+func (l Link) Interfaces() []string {
+	return strings.Fields(l.Params[Interface])
+}
+
+// ContentFormats 返回ct属性, 忽略无法解析的值
+func (l Link) ContentFormats() []uint32 {
+	var formats []uint32
+	for _, s := range strings.Fields(l.Params[ContentFormat]) {
+		if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+			formats = append(formats, uint32(n))
+		}
+	}
+	return formats
+}
+
+// MaxSize 返回sz属性
+func (l Link) MaxSize() (uint32, bool) {
+	n, err := strconv.ParseUint(l.Params[Size], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// Title 返回title属性
+func (l Link) Title() string {
+	return l.Params[Title]
+}
+
+// Observable 判断资源是否可被观察
+func (l Link) Observable() bool {
+	_, ok := l.Params[Observable]
+	return ok
+}
+
+// String 序列化链接, 属性按名称排序
+func (l Link) String() string {
+	var b strings.Builder
+	b.WriteString("<")
+	b.WriteString(l.URI)
+	b.WriteString(">")
+
+	names := make([]string, 0, len(l.Params))
+	for name := range l.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(";")
+		b.WriteString(name)
+		if v := l.Params[name]; v != "" {
+			b.WriteString("=")
+			if isDigits(v) {
+				b.WriteString(v)
+			} else {
+				b.WriteString(strconv.Quote(v))
+			}
+		}
+	}
+	return b.String()
+}
+
+// Format 序列化链接列表
+func Format(links []Link) string {
+	s := make([]string, len(links))
+	for i, l := range links {
+		s[i] = l.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// Parse 解析link-format文档
+func Parse(s string) ([]Link, error) {
+	p := parser{s: s}
+	var links []Link
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		l, err := p.parseLink()
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		if p.s[p.i] != ',' {
+			return nil, p.errorf("expect ','")
+		}
+		p.i++
+	}
+	return links, nil
+}
+
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t' || p.s[p.i] == '\r' || p.s[p.i] == '\n') {
+		p.i++
+	}
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("linkformat: offset %d: %s", p.i, fmt.Sprintf(format, a...))
+}
+
+func (p *parser) parseLink() (Link, error) {
+	var l Link
+	if p.s[p.i] != '<' {
+		return l, p.errorf("expect '<'")
+	}
+	end := strings.IndexByte(p.s[p.i:], '>')
+	if end < 0 {
+		return l, p.errorf("unterminated uri")
+	}
+	l.URI = p.s[p.i+1 : p.i+end]
+	p.i += end + 1
+
+	for {
+		p.skipSpace()
+		if p.eof() || p.s[p.i] != ';' {
+			return l, nil
+		}
+		p.i++
+		p.skipSpace()
+		name, value, err := p.parseParam()
+		if err != nil {
+			return l, err
+		}
+		if _, ok := l.Params[name]; !ok {
+			// 重复的属性以第一个为准
+			l.Set(name, value)
+		}
+	}
+}
+
+func (p *parser) parseParam() (string, string, error) {
+	start := p.i
+	for !p.eof() && isTokenChar(p.s[p.i]) {
+		p.i++
+	}
+	name := p.s[start:p.i]
+	if name == "" {
+		return "", "", p.errorf("empty parameter name")
+	}
+	p.skipSpace()
+	if p.eof() || p.s[p.i] != '=' {
+		return name, "", nil
+	}
+	p.i++
+	p.skipSpace()
+	if !p.eof() && p.s[p.i] == '"' {
+		value, err := p.parseQuoted()
+		return name, value, err
+	}
+	start = p.i
+	for !p.eof() && p.s[p.i] != ';' && p.s[p.i] != ',' {
+		p.i++
+	}
+	return name, strings.TrimSpace(p.s[start:p.i]), nil
+}
+
+func (p *parser) parseQuoted() (string, error) {
+	var b strings.Builder
+	for p.i++; !p.eof(); p.i++ {
+		switch c := p.s[p.i]; c {
+		case '"':
+			p.i++
+			return b.String(), nil
+		case '\\':
+			p.i++
+			if p.eof() {
+				return "", errUnterminated
+			}
+			b.WriteByte(p.s[p.i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errUnterminated
+}
+
+var errUnterminated = errors.New("linkformat: unterminated quoted string")
+
+func isTokenChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("!#$&+-.^_`|~*", c) >= 0
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Match 判断链接是否满足过滤条件(RFC 6690 4.1), 值以*结尾时进行前缀匹配.
+//
+// name为href时匹配链接的URI, 否则匹配属性值, 多值属性(如rt)匹配其中任意一个值.
+func (l Link) Match(name, value string) bool {
+	if name == "href" {
+		return matchValue(l.URI, value)
+	}
+	v, ok := l.Params[name]
+	if !ok {
+		return false
+	}
+	if matchValue(v, value) {
+		return true
+	}
+	for _, f := range strings.Fields(v) {
+		if matchValue(f, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValue(v, pattern string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(v, strings.TrimSuffix(pattern, "*"))
+	}
+	return v == pattern
+}
@@ -0,0 +1,122 @@
+package linkformat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s     string
+		links []Link
+	}{
+		{
+			s:     "",
+			links: nil,
+		},
+		{
+			s:     "</sensors>",
+			links: []Link{{URI: "/sensors"}},
+		},
+		{
+			s: `</sensors/temp>;rt="temperature-c";if="sensor";ct=0;obs, </sensors/light>;rt="light-lux core.s";title="a;b,c\"d"`,
+			links: []Link{
+				{
+					URI:    "/sensors/temp",
+					Params: map[string]string{"rt": "temperature-c", "if": "sensor", "ct": "0", "obs": ""},
+				},
+				{
+					URI:    "/sensors/light",
+					Params: map[string]string{"rt": "light-lux core.s", "title": `a;b,c"d`},
+				},
+			},
+		},
+	}
+	for i, tt := range tests {
+		links, err := Parse(tt.s)
+		if err != nil {
+			t.Fatalf("case%d: parse: %v", i, err)
+		}
+		if got, want := links, tt.links; !reflect.DeepEqual(got, want) {
+			t.Errorf("case%d: %v != %v", i, got, want)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []string{
+		"/sensors",
+		"</sensors",
+		"</a>;title=\"x",
+		"</a> </b>",
+		"</a>;=1",
+	}
+	for i, s := range tests {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("case%d: parse %q should fail", i, s)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	links := []Link{
+		{URI: "/sensors/temp", Params: map[string]string{"rt": "temperature-c", "ct": "0", "obs": ""}},
+		{URI: "/sensors/light", Params: map[string]string{"ct": "0 40", "title": `say "hi"`}},
+	}
+	s := Format(links)
+	want := `</sensors/temp>;ct=0;obs;rt="temperature-c",</sensors/light>;ct="0 40";title="say \"hi\""`
+	if s != want {
+		t.Errorf("%s != %s", s, want)
+	}
+	got, err := Parse(s)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(got, links) {
+		t.Errorf("%v != %v", got, links)
+	}
+}
+
+func TestLinkAccessors(t *testing.T) {
+	l := Link{URI: "/s", Params: map[string]string{"rt": "a b", "if": "sensor", "ct": "0 40 x", "sz": "128", "obs": "", "title": "T"}}
+	if got, want := l.ResourceTypes(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resource types: %v != %v", got, want)
+	}
+	if got, want := l.Interfaces(), []string{"sensor"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("interfaces: %v != %v", got, want)
+	}
+	if got, want := l.ContentFormats(), []uint32{0, 40}; !reflect.DeepEqual(got, want) {
+		t.Errorf("content formats: %v != %v", got, want)
+	}
+	if got, ok := l.MaxSize(); !ok || got != 128 {
+		t.Errorf("max size: %v, %v", got, ok)
+	}
+	if got, want := l.Observable(), true; got != want {
+		t.Errorf("observable: %v != %v", got, want)
+	}
+	if got, want := l.Title(), "T"; got != want {
+		t.Errorf("title: %v != %v", got, want)
+	}
+}
+
+func TestLinkMatch(t *testing.T) {
+	l := Link{URI: "/sensors/temp", Params: map[string]string{"rt": "temperature-c core.s"}}
+	tests := []struct {
+		name  string
+		value string
+		match bool
+	}{
+		{name: "href", value: "/sensors/temp", match: true},
+		{name: "href", value: "/sensors/*", match: true},
+		{name: "href", value: "/actuators/*", match: false},
+		{name: "rt", value: "core.s", match: true},
+		{name: "rt", value: "temp*", match: true},
+		{name: "rt", value: "light", match: false},
+		{name: "if", value: "sensor", match: false},
+	}
+	for i, tt := range tests {
+		if got, want := l.Match(tt.name, tt.value), tt.match; got != want {
+			t.Errorf("case%d: %s=%s: %v != %v", i, tt.name, tt.value, got, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package coap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"unicode/utf8"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// Logger 结构化日志接口, keyvals为交替出现的键值对, 如Log(LevelWarn, "send rst", "error", err)
+type Logger = base.Logger
+
+// LogLevel 日志级别
+type LogLevel = base.Level
+
+const (
+	LevelDebug = base.LevelDebug
+	LevelInfo  = base.LevelInfo
+	LevelWarn  = base.LevelWarn
+	LevelError = base.LevelError
+)
+
+// NopLogger 丢弃所有日志的Logger
+var NopLogger Logger = base.NopLogger
+
+// NewLogger 返回以key=value文本格式输出到l的Logger, 低于level的日志被丢弃.
+// l为nil时使用log包的标准Logger.
+func NewLogger(l *log.Logger, level LogLevel) Logger {
+	return &base.StdLogger{Logger: l, Level: level}
+}
+
+// TraceMode 消息跟踪模式, 跟踪的消息以Info级别输出到Logger
+type TraceMode int
+
+const (
+	TraceOff   TraceMode = iota // 不跟踪
+	TraceBrief                  // 跟踪协议栈之上收发的消息, 只输出类型、响应码、消息ID及token
+	TraceFull                   // 跟踪链路上收发的消息, 输出包括选项
+	TraceJSON                   // 跟踪链路上收发的消息, 以JSON格式输出, 包括选项及负载
+)
+
+var jsonStringer = base.MessageStringer{WritePayload: writeTracePayload}
+
+// writeTracePayload 输出跟踪的负载, 非UTF-8负载以十六进制输出
+func writeTracePayload(w io.Writer, payload []byte) {
+	if utf8.Valid(payload) {
+		w.Write(payload)
+	} else {
+		fmt.Fprint(w, hex.EncodeToString(payload))
+	}
+}
+
+// verboseTrace 返回已废弃的Verbose对应的跟踪模式
+func verboseTrace() TraceMode {
+	switch Verbose {
+	case 1:
+		return TraceBrief
+	case 2:
+		return TraceFull
+	default:
+		return TraceOff
+	}
+}
+
+// traceString 按跟踪模式返回消息的字符串表示
+func traceString(mode TraceMode, m base.Message) string {
+	switch mode {
+	case TraceFull:
+		var mser base.MessageStringer
+		return mser.MessageString(m)
+	case TraceJSON:
+		return jsonStringer.MessageJSON(m)
+	default:
+		return m.String()
+	}
+}
@@ -0,0 +1,147 @@
+package coap_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/ironzhang/coap"
+)
+
+type TestLogRecord struct {
+	level   coap.LogLevel
+	msg     string
+	keyvals map[string]string
+}
+
+type TestLogger struct {
+	mu      sync.Mutex
+	records []TestLogRecord
+}
+
+func (l *TestLogger) Log(level coap.LogLevel, msg string, keyvals ...interface{}) {
+	r := TestLogRecord{level: level, msg: msg, keyvals: make(map[string]string)}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		r.keyvals[fmt.Sprint(keyvals[i])] = fmt.Sprint(keyvals[i+1])
+	}
+	l.mu.Lock()
+	l.records = append(l.records, r)
+	l.mu.Unlock()
+}
+
+func (l *TestLogger) Records(msg string) []TestLogRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var records []TestLogRecord
+	for _, r := range l.records {
+		if r.msg == msg {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func TestLoggerTrace(t *testing.T) {
+	var slog, clog, connLog TestLogger
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.Write([]byte("pong"))
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h, Logger: &slog, Trace: coap.TraceJSON}).Serve("coap", ln)
+
+	urlstr := "coap://" + ln.LocalAddr().String() + "/ping"
+	client := &coap.Client{Logger: &clog, Trace: coap.TraceBrief}
+	conn, err := client.Dial(urlstr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	send := func() {
+		req, err := coap.NewRequest(true, coap.GET, urlstr, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if _, err = conn.SendRequest(req); err != nil {
+			t.Fatalf("send request: %v", err)
+		}
+	}
+	send()
+	conn.SetLogger(&connLog)
+	send()
+
+	tests := []struct {
+		logger *TestLogger
+		msg    string
+		count  int
+	}{
+		{logger: &slog, msg: "recv", count: 2},
+		{logger: &slog, msg: "send", count: 2},
+		{logger: &clog, msg: "send", count: 1},
+		{logger: &clog, msg: "recv", count: 1},
+		{logger: &connLog, msg: "send", count: 1},
+		{logger: &connLog, msg: "recv", count: 1},
+	}
+	for i, tt := range tests {
+		records := tt.logger.Records(tt.msg)
+		if got, want := len(records), tt.count; got != want {
+			t.Errorf("case%d: %s records: %d != %d", i, tt.msg, got, want)
+			continue
+		}
+		for _, r := range records {
+			if r.level != coap.LevelInfo || r.keyvals["peer"] == "" || r.keyvals["message"] == "" {
+				t.Errorf("case%d: unexpected record: %+v", i, r)
+			}
+		}
+	}
+
+	var m struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Payload string `json:"payload"`
+	}
+	records := slog.Records("send")
+	if err = json.Unmarshal([]byte(records[len(records)-1].keyvals["message"]), &m); err != nil {
+		t.Fatalf("json unmarshal: %v", err)
+	}
+	if m.Type != "Acknowledgement" || m.Code != "Content" || m.Payload != "pong" {
+		t.Errorf("unexpected json message: %+v", m)
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	coap.Verbose = 1
+	defer func() { coap.Verbose = 0 }()
+
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.Write([]byte("pong"))
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: h, Logger: coap.NopLogger}).Serve("coap", ln)
+
+	// 未设置Trace时, Verbose=1等同于TraceBrief
+	var clog TestLogger
+	client := &coap.Client{Logger: &clog}
+	req, err := coap.NewRequest(true, coap.GET, "coap://"+ln.LocalAddr().String()+"/ping", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if _, err = client.SendRequest(req); err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	for _, msg := range []string{"send", "recv"} {
+		if got, want := len(clog.Records(msg)), 1; got != want {
+			t.Errorf("%s records: %d != %d", msg, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package coap
+
+import (
+	"strings"
+	"sync"
+)
+
+// 更多的Content类型定义, 完整列表参见IANA CoAP Content-Formats注册表
+const (
+	AppJSONPatch     = uint32(51)    // application/json-patch+json
+	AppMergePatch    = uint32(52)    // application/merge-patch+json
+	AppCBOR          = uint32(60)    // application/cbor
+	AppCBORSeq       = uint32(63)    // application/cbor-seq
+	AppSenMLJSON     = uint32(110)   // application/senml+json
+	AppSenMLCBOR     = uint32(112)   // application/senml+cbor
+	AppMissingBlocks = uint32(272)   // application/missing-blocks+cbor-seq
+	AppOSCORE        = uint32(10001) // application/oscore
+)
+
+var mediaTypes = struct {
+	sync.RWMutex
+	names   map[uint32]string
+	formats map[string]uint32
+}{
+	names:   make(map[uint32]string),
+	formats: make(map[string]uint32),
+}
+
+func init() {
+	for cf, mt := range map[uint32]string{
+		TextPlain:        "text/plain; charset=utf-8",
+		16:               `application/cose; cose-type="cose-encrypt0"`,
+		17:               `application/cose; cose-type="cose-mac0"`,
+		18:               `application/cose; cose-type="cose-sign1"`,
+		AppLinkFormat:    "application/link-format",
+		AppXML:           "application/xml",
+		AppOctets:        "application/octet-stream",
+		AppExi:           "application/exi",
+		AppJSON:          "application/json",
+		AppJSONPatch:     "application/json-patch+json",
+		AppMergePatch:    "application/merge-patch+json",
+		AppCBOR:          "application/cbor",
+		61:               "application/cwt",
+		62:               "application/multipart-core",
+		AppCBORSeq:       "application/cbor-seq",
+		96:               `application/cose; cose-type="cose-encrypt"`,
+		97:               `application/cose; cose-type="cose-mac"`,
+		98:               `application/cose; cose-type="cose-sign"`,
+		101:              "application/cose-key",
+		102:              "application/cose-key-set",
+		AppSenMLJSON:     "application/senml+json",
+		111:              "application/sensml+json",
+		AppSenMLCBOR:     "application/senml+cbor",
+		113:              "application/sensml+cbor",
+		114:              "application/senml-exi",
+		115:              "application/sensml-exi",
+		256:              "application/coap-group+json",
+		AppMissingBlocks: "application/missing-blocks+cbor-seq",
+		310:              "application/senml+xml",
+		311:              "application/sensml+xml",
+		10000:            "application/vnd.ocf+cbor",
+		AppOSCORE:        "application/oscore",
+		11542:            "application/vnd.oma.lwm2m+tlv",
+		11543:            "application/vnd.oma.lwm2m+json",
+	} {
+		RegisterMediaType(cf, mt)
+	}
+}
+
+// RegisterMediaType 注册Content-Format编号对应的媒体类型, 重复注册时替换原有的媒体类型,
+// mediaType为空时注销该编号
+func RegisterMediaType(cf uint32, mediaType string) {
+	mediaTypes.Lock()
+	defer mediaTypes.Unlock()
+	if old, ok := mediaTypes.names[cf]; ok {
+		delete(mediaTypes.names, cf)
+		delete(mediaTypes.formats, normalizeMediaType(old))
+	}
+	if mediaType == "" {
+		return
+	}
+	mediaTypes.names[cf] = mediaType
+	mediaTypes.formats[normalizeMediaType(mediaType)] = cf
+}
+
+// MediaType 返回Content-Format编号对应的媒体类型, 未注册时返回空串
+func MediaType(cf uint32) string {
+	mediaTypes.RLock()
+	defer mediaTypes.RUnlock()
+	return mediaTypes.names[cf]
+}
+
+// ContentFormatOf 返回媒体类型对应的Content-Format编号, 忽略大小写及参数间的空白.
+// text/plain等同于text/plain; charset=utf-8.
+func ContentFormatOf(mediaType string) (uint32, bool) {
+	mt := normalizeMediaType(mediaType)
+	if mt == "text/plain" {
+		return TextPlain, true
+	}
+	mediaTypes.RLock()
+	defer mediaTypes.RUnlock()
+	cf, ok := mediaTypes.formats[mt]
+	return cf, ok
+}
+
+func normalizeMediaType(mt string) string {
+	return strings.ToLower(strings.Join(strings.Fields(mt), ""))
+}
+
+// isTextMediaType 判断媒体类型的负载是否为文本
+func isTextMediaType(mt string) bool {
+	if i := strings.IndexByte(mt, ';'); i >= 0 {
+		mt = mt[:i]
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case strings.HasSuffix(mt, "/json"), strings.HasSuffix(mt, "+json"):
+		return true
+	case strings.HasSuffix(mt, "/xml"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	return mt == "application/link-format"
+}
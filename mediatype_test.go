@@ -0,0 +1,77 @@
+package coap
+
+import "testing"
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		cf        uint32
+		mediaType string
+	}{
+		{cf: TextPlain, mediaType: "text/plain; charset=utf-8"},
+		{cf: AppLinkFormat, mediaType: "application/link-format"},
+		{cf: AppCBOR, mediaType: "application/cbor"},
+		{cf: AppSenMLJSON, mediaType: "application/senml+json"},
+		{cf: 18, mediaType: `application/cose; cose-type="cose-sign1"`},
+		{cf: AppOSCORE, mediaType: "application/oscore"},
+	}
+	for i, tt := range tests {
+		if got, want := MediaType(tt.cf), tt.mediaType; got != want {
+			t.Errorf("case%d: media type: %q != %q", i, got, want)
+		}
+		if cf, ok := ContentFormatOf(tt.mediaType); !ok || cf != tt.cf {
+			t.Errorf("case%d: content format: %d %t != %d", i, cf, ok, tt.cf)
+		}
+	}
+
+	lookups := []struct {
+		mediaType string
+		cf        uint32
+		ok        bool
+	}{
+		{mediaType: "text/plain", cf: TextPlain, ok: true},
+		{mediaType: "Text/Plain; charset=UTF-8", cf: TextPlain, ok: true},
+		{mediaType: `application/cose;cose-type="cose-sign1"`, cf: 18, ok: true},
+		{mediaType: "APPLICATION/JSON", cf: AppJSON, ok: true},
+		{mediaType: "text/html", cf: 0, ok: false},
+		{mediaType: "application/unknown", cf: 0, ok: false},
+	}
+	for i, tt := range lookups {
+		if cf, ok := ContentFormatOf(tt.mediaType); cf != tt.cf || ok != tt.ok {
+			t.Errorf("case%d: %q: %d %t != %d %t", i, tt.mediaType, cf, ok, tt.cf, tt.ok)
+		}
+	}
+
+	RegisterMediaType(65000, "application/x-test")
+	if cf, ok := ContentFormatOf("application/x-test"); !ok || cf != 65000 {
+		t.Errorf("registered: %d %t", cf, ok)
+	}
+	if got, want := MediaType(65000), "application/x-test"; got != want {
+		t.Errorf("registered: %q != %q", got, want)
+	}
+	RegisterMediaType(65000, "")
+	if _, ok := ContentFormatOf("application/x-test"); ok {
+		t.Errorf("unregistered: application/x-test found")
+	}
+}
+
+func TestIsTextMediaType(t *testing.T) {
+	tests := []struct {
+		cf   uint32
+		text bool
+	}{
+		{cf: TextPlain, text: true},
+		{cf: AppLinkFormat, text: true},
+		{cf: AppJSON, text: true},
+		{cf: AppSenMLJSON, text: true},
+		{cf: AppXML, text: true},
+		{cf: AppOctets, text: false},
+		{cf: AppCBOR, text: false},
+		{cf: AppOSCORE, text: false},
+		{cf: 65001, text: false},
+	}
+	for i, tt := range tests {
+		if got, want := isTextMediaType(MediaType(tt.cf)), tt.text; got != want {
+			t.Errorf("case%d: %d: %t != %t", i, tt.cf, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// Middleware 包装Handler的中间件
+type Middleware func(Handler) Handler
+
+// Chain 以中间件包装h, 第一个中间件位于最外层.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// bufferedWriter 缓存响应码、选项及负载, 由flush写入底层ResponseWriter
+type bufferedWriter struct {
+	mu      sync.Mutex
+	w       ResponseWriter
+	code    Code
+	codeSet bool
+	options Options
+	buffer  bytes.Buffer
+	body    io.Reader
+	size    int64
+	closed  bool // 关闭后Ack不再传递至底层ResponseWriter
+}
+
+func newBufferedWriter(w ResponseWriter) *bufferedWriter {
+	return &bufferedWriter{w: w, options: append(Options(nil), *w.Options()...)}
+}
+
+func (b *bufferedWriter) Ack(code Code) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.w.Ack(code)
+	}
+}
+
+func (b *bufferedWriter) SetConfirmable() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.w.SetConfirmable()
+	}
+}
+
+func (b *bufferedWriter) Options() *Options {
+	return &b.options
+}
+
+func (b *bufferedWriter) WriteCode(code Code) {
+	b.code, b.codeSet = code, true
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	return b.buffer.Write(p)
+}
+
+func (b *bufferedWriter) SetBody(body io.Reader, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buffer.Reset()
+	b.body, b.size = body, size
+	if b.closed {
+		b.closeBody()
+	}
+}
+
+// closeBody 关闭被丢弃的负载
+func (b *bufferedWriter) closeBody() {
+	if c, ok := b.body.(io.Closer); ok {
+		c.Close()
+	}
+	b.body = nil
+}
+
+// close 关闭bufferedWriter, 之后的输出不再写入底层ResponseWriter
+func (b *bufferedWriter) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.closeBody()
+	b.mu.Unlock()
+}
+
+// flush 将缓存的响应写入底层ResponseWriter
+func (b *bufferedWriter) flush() {
+	if b.codeSet {
+		b.w.WriteCode(b.code)
+	}
+	*b.w.Options() = b.options
+	if b.body != nil {
+		ServeBody(b.w, b.body, b.size)
+		return
+	}
+	b.w.Write(b.buffer.Bytes())
+}
+
+// Recovery 捕获Handler的panic并以InternalServerError响应, panic前已写入的响应被丢弃.
+// panic以Error级别输出到log包的标准Logger, 参见RecoveryLogger.
+func Recovery(next Handler) Handler {
+	return RecoveryLogger(nil)(next)
+}
+
+// RecoveryLogger 返回与Recovery相同的中间件, panic及调用栈以Error级别输出到l, l为nil时输出到log包的标准Logger.
+func RecoveryLogger(l Logger) Middleware {
+	if l == nil {
+		l = base.DefaultLogger
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			b := newBufferedWriter(w)
+			defer func() {
+				if err := recover(); err != nil {
+					buf := make([]byte, 64<<10)
+					buf = buf[:runtime.Stack(buf, false)]
+					l.Log(LevelError, "panic serving", "peer", r.RemoteAddr, "path", r.URL.Path, "error", err, "stack", string(buf))
+					b.close()
+					w.WriteCode(InternalServerError)
+					return
+				}
+				b.flush()
+			}()
+			next.ServeCOAP(b, r)
+		})
+	}
+}
+
+// statusWriter 记录响应码及负载长度
+type statusWriter struct {
+	ResponseWriter
+	code Code
+	size int
+}
+
+func (w *statusWriter) WriteCode(code Code) {
+	w.code = code
+	w.ResponseWriter.WriteCode(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+// SetBody 长度未知的负载不计入负载长度
+func (w *statusWriter) SetBody(body io.Reader, size int64) {
+	if size > 0 {
+		w.size = int(size)
+	}
+	ServeBody(w.ResponseWriter, body, size)
+}
+
+// AccessLog 返回记录访问日志的中间件, 以Info级别输出对端地址、方法、路径、响应码、负载长度及处理时长.
+// l为nil时输出到log包的标准Logger.
+func AccessLog(l Logger) Middleware {
+	if l == nil {
+		l = base.DefaultLogger
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, code: Content}
+			next.ServeCOAP(sw, r)
+			l.Log(LevelInfo, "access", "peer", r.RemoteAddr, "method", r.Method, "path", r.URL.Path,
+				"code", sw.code, "size", sw.size, "duration", time.Since(start))
+		})
+	}
+}
+
+// RequestCounter 按路径统计请求数
+type RequestCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// Middleware 返回统计请求数的中间件
+func (c *RequestCounter) Middleware(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		c.mu.Lock()
+		if c.counts == nil {
+			c.counts = make(map[string]uint64)
+		}
+		c.counts[r.URL.Path]++
+		c.mu.Unlock()
+		next.ServeCOAP(w, r)
+	})
+}
+
+// Count 返回路径的请求数
+func (c *RequestCounter) Count(path string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[path]
+}
+
+// Counts 返回各路径请求数的副本
+func (c *RequestCounter) Counts() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]uint64, len(c.counts))
+	for path, n := range c.counts {
+		counts[path] = n
+	}
+	return counts
+}
+
+// Timeout 返回限制Handler处理时长的中间件.
+//
+// Handler在d内未完成时, 请求的Context被取消, 先以空ACK确认可靠请求,
+// 再以ServiceUnavailable单独响应(可靠请求的响应为可靠消息), Handler之后的输出被丢弃.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			b := newBufferedWriter(w)
+			done := make(chan struct{})
+			panicc := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if err := recover(); err != nil {
+						panicc <- err
+					}
+				}()
+				next.ServeCOAP(b, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case err := <-panicc:
+				panic(err)
+			case <-done:
+				b.flush()
+			case <-ctx.Done():
+				b.close()
+				w.Ack(0)
+				if r.Confirmable {
+					w.SetConfirmable()
+				}
+				w.WriteCode(ServiceUnavailable)
+				fmt.Fprintf(w, "handler timeout after %s", d)
+			}
+		})
+	}
+}
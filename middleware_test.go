@@ -0,0 +1,190 @@
+package coap_test
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap"
+	"github.com/ironzhang/coap/coaptest"
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) coap.Middleware {
+		return func(next coap.Handler) coap.Handler {
+			return coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+				order = append(order, name)
+				next.ServeCOAP(w, r)
+			})
+		}
+	}
+	h := coap.Chain(coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		order = append(order, "handler")
+	}), mark("a"), mark("b"))
+
+	req, err := coap.NewRequest(true, coap.GET, "coap://localhost/a", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	h.ServeCOAP(coaptest.NewRecorder(), req)
+	if got, want := strings.Join(order, ","), "a,b,handler"; got != want {
+		t.Errorf("order: %q != %q", got, want)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	handler := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		switch r.URL.Path {
+		case "/panic":
+			w.WriteCode(coap.Created)
+			w.Write([]byte("partial"))
+			panic("boom")
+		case "/slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			w.Write([]byte("slow"))
+		default:
+			w.Options().Set(coap.ContentFormat, uint32(0))
+			w.WriteCode(coap.Changed)
+			w.Write([]byte("ok"))
+		}
+	})
+	var counter coap.RequestCounter
+	var logs, panics bytes.Buffer
+	h := coap.Chain(handler, coap.AccessLog(coap.NewLogger(log.New(&logs, "", 0), coap.LevelInfo)), coap.RecoveryLogger(coap.NewLogger(log.New(&panics, "", 0), coap.LevelInfo)), counter.Middleware, coap.Timeout(50*time.Millisecond))
+
+	tests := []struct {
+		path   string
+		code   coap.Code
+		body   string
+		format interface{}
+	}{
+		{path: "/ok", code: coap.Changed, body: "ok", format: uint32(0)},
+		{path: "/panic", code: coap.InternalServerError, body: ""},
+		{path: "/slow", code: coap.ServiceUnavailable, body: "handler timeout after 50ms"},
+		{path: "/ok", code: coap.Changed, body: "ok", format: uint32(0)},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, coap.GET, "coap://localhost"+tt.path, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		rec := coaptest.NewRecorder()
+		h.ServeCOAP(rec, req)
+		if got, want := rec.Code, tt.code; got != want {
+			t.Errorf("case%d: %s: code: %v != %v", i, tt.path, got, want)
+		}
+		if got, want := rec.Body.String(), tt.body; got != want {
+			t.Errorf("case%d: %s: body: %q != %q", i, tt.path, got, want)
+		}
+		if got, want := rec.Header.Get(coap.ContentFormat), tt.format; got != want {
+			t.Errorf("case%d: %s: content format: %v != %v", i, tt.path, got, want)
+		}
+	}
+
+	counts := map[string]uint64{"/ok": 2, "/panic": 1, "/slow": 1}
+	for path, want := range counts {
+		if got := counter.Count(path); got != want {
+			t.Errorf("count %s: %d != %d", path, got, want)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if got, want := len(lines), len(tests); got != want {
+		t.Fatalf("access log lines: %d != %d", got, want)
+	}
+	for i, tt := range tests {
+		if prefix := "level=info msg=access peer=<nil> method=GET path=" + tt.path + " code=" + tt.code.String() + " "; !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("case%d: access log: %q has no prefix %q", i, lines[i], prefix)
+		}
+	}
+	if prefix := "level=error msg=\"panic serving\" peer=<nil> path=/panic error=boom stack="; !strings.HasPrefix(panics.String(), prefix) {
+		t.Errorf("panic log: %q has no prefix %q", panics.String(), prefix)
+	}
+}
+
+func TestTimeoutSeparateResponse(t *testing.T) {
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		w.Ack(0)
+		<-r.Context().Done()
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: coap.Chain(h, coap.Timeout(50*time.Millisecond))}).Serve("coap", ln)
+
+	req, err := coap.NewRequest(true, coap.GET, "coap://"+ln.LocalAddr().String()+"/wait", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := coap.DefaultClient.SendRequest(req)
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if got, want := resp.Status, coap.ServiceUnavailable; got != want {
+		t.Errorf("code: %v != %v", got, want)
+	}
+}
+
+func TestTimeoutEmptyAck(t *testing.T) {
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		<-r.Context().Done()
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: coap.Chain(h, coap.Timeout(50*time.Millisecond))}).Serve("coap", ln)
+
+	conn, err := net.Dial("udp", ln.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	req := base.Message{Type: base.CON, Code: base.GET, MessageID: 1000, Token: "timeout"}
+	data, err := req.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if _, err = conn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// 先回复空ACK, 再以可靠消息单独响应
+	tests := []struct {
+		typ   uint8
+		code  uint8
+		token string
+	}{
+		{typ: base.ACK, code: 0, token: ""},
+		{typ: base.CON, code: uint8(coap.ServiceUnavailable), token: req.Token},
+	}
+	buf := make([]byte, 1500)
+	for i, tt := range tests {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("case%d: read: %v", i, err)
+		}
+		var m base.Message
+		if err = m.Unmarshal(buf[:n]); err != nil {
+			t.Fatalf("case%d: unmarshal: %v", i, err)
+		}
+		if m.Type != tt.typ || m.Code != tt.code || m.Token != tt.token {
+			t.Errorf("case%d: message: %v", i, m)
+		}
+		if i == 0 && m.MessageID != req.MessageID {
+			t.Errorf("case%d: message id: %d != %d", i, m.MessageID, req.MessageID)
+		}
+	}
+}
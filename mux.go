@@ -0,0 +1,246 @@
+package coap
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// Resource 路由中注册的资源, 用于构建资源发现
+type Resource struct {
+	Pattern string            // 路径模式
+	Methods []Code            // 注册的方法, 为空表示接受所有方法
+	Attrs   map[string]string // link-format属性, 如rt, if, ct
+}
+
+// route 一个路径模式及其处理方法
+type route struct {
+	pattern  string
+	segments []string
+	prefix   bool
+	handlers map[Code]Handler // 键为0表示接受所有方法
+	attrs    map[string]string
+}
+
+// match 匹配路径段, 返回路径参数
+func (r *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) < len(r.segments) || (!r.prefix && len(segments) != len(r.segments)) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, s := range r.segments {
+		if name, ok := paramName(s); ok {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// score 匹配优先级, 精确匹配优先于前缀匹配, 字面路径段越多优先级越高
+func (r *route) score() int {
+	n := 0
+	for _, s := range r.segments {
+		if _, ok := paramName(s); !ok {
+			n++
+		}
+	}
+	if r.prefix {
+		return len(r.segments)*2 + n
+	}
+	return 1<<16 + n
+}
+
+func (r *route) handler(method Code) (Handler, bool) {
+	if h, ok := r.handlers[method]; ok {
+		return h, true
+	}
+	h, ok := r.handlers[0]
+	return h, ok
+}
+
+// ServeMux COAP请求路由器, 按路径及方法将请求分发给注册的Handler.
+//
+// 路径模式支持以下形式:
+//	精确匹配: /sensors/temp
+//	前缀匹配: 以/结尾, 如/files/匹配/files及/files/a/b
+//	路径参数: {name}形式的路径段, 如/sensors/{id}/temp, 参数值由Request.PathParam获取
+//
+// 精确匹配优先于前缀匹配, 较长的前缀优先于较短的前缀, 字面路径段优先于路径参数.
+// 没有匹配的路径时响应NotFound, 路径匹配但方法未注册时响应MethodNotAllowed.
+// 未注册/.well-known/core时, ServeMux根据注册的资源自动响应资源发现请求.
+type ServeMux struct {
+	mu     sync.RWMutex
+	routes map[string]*route
+}
+
+// NewServeMux 构造ServeMux
+func NewServeMux() *ServeMux {
+	return &ServeMux{routes: make(map[string]*route)}
+}
+
+// Handle 注册处理路径pattern所有方法的Handler
+func (mux *ServeMux) Handle(pattern string, h Handler) {
+	mux.HandleMethod(0, pattern, h)
+}
+
+// HandleFunc 注册处理路径pattern所有方法的处理函数
+func (mux *ServeMux) HandleFunc(pattern string, f func(ResponseWriter, *Request)) {
+	mux.HandleMethod(0, pattern, HandlerFunc(f))
+}
+
+// HandleMethodFunc 注册处理路径pattern指定方法的处理函数
+func (mux *ServeMux) HandleMethodFunc(method Code, pattern string, f func(ResponseWriter, *Request)) {
+	mux.HandleMethod(method, pattern, HandlerFunc(f))
+}
+
+// HandleMethod 注册处理路径pattern指定方法的Handler, method为0表示所有方法.
+//
+// 重复注册同一路径的同一方法, 或method不是请求方法时将panic.
+func (mux *ServeMux) HandleMethod(method Code, pattern string, h Handler) {
+	if h == nil {
+		panic("coap: nil handler")
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		panic("coap: invalid pattern " + pattern)
+	}
+	if method != 0 && !base.IsMethod(uint8(method)) {
+		panic("coap: invalid method " + method.String())
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if mux.routes == nil {
+		mux.routes = make(map[string]*route)
+	}
+	r, ok := mux.routes[pattern]
+	if !ok {
+		r = newRoute(pattern)
+		mux.routes[pattern] = r
+	}
+	if _, ok = r.handlers[method]; ok {
+		panic("coap: multiple registrations for " + pattern)
+	}
+	r.handlers[method] = h
+}
+
+// SetAttrs 设置路径pattern的link-format属性, pattern需已注册
+func (mux *ServeMux) SetAttrs(pattern string, attrs map[string]string) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	r, ok := mux.routes[pattern]
+	if !ok {
+		panic("coap: pattern " + pattern + " not registered")
+	}
+	r.attrs = make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		r.attrs[k] = v
+	}
+}
+
+// Resources 返回注册的资源列表, 按路径模式排序
+func (mux *ServeMux) Resources() []Resource {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	resources := make([]Resource, 0, len(mux.routes))
+	for _, r := range mux.routes {
+		res := Resource{Pattern: r.pattern}
+		if _, ok := r.handlers[0]; !ok {
+			for m := range r.handlers {
+				res.Methods = append(res.Methods, m)
+			}
+			sort.Slice(res.Methods, func(i, j int) bool { return res.Methods[i] < res.Methods[j] })
+		}
+		if len(r.attrs) > 0 {
+			res.Attrs = make(map[string]string, len(r.attrs))
+			for k, v := range r.attrs {
+				res.Attrs[k] = v
+			}
+		}
+		resources = append(resources, res)
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Pattern < resources[j].Pattern })
+	return resources
+}
+
+// ServeCOAP 将请求分发给匹配的Handler
+func (mux *ServeMux) ServeCOAP(w ResponseWriter, r *Request) {
+	if cleanPath(r.URL.Path) == WellKnownCore && !mux.registered(WellKnownCore) {
+		mux.serveWellKnownCore(w, r)
+		return
+	}
+
+	h, params, code := mux.lookup(r.Method, r.URL.Path)
+	if h == nil {
+		w.WriteCode(code)
+		return
+	}
+	r.pathParams = params
+	h.ServeCOAP(w, r)
+}
+
+func (mux *ServeMux) registered(pattern string) bool {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	_, ok := mux.routes[pattern]
+	return ok
+}
+
+// lookup 查找处理请求的Handler, 未找到时返回应答的错误码
+func (mux *ServeMux) lookup(method Code, path string) (Handler, map[string]string, Code) {
+	segments := splitPath(path)
+
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	var best *route
+	var params map[string]string
+	for _, r := range mux.routes {
+		p, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+		if best == nil || r.score() > best.score() || (r.score() == best.score() && r.pattern < best.pattern) {
+			best, params = r, p
+		}
+	}
+	if best == nil {
+		return nil, nil, NotFound
+	}
+	h, ok := best.handler(method)
+	if !ok {
+		return nil, nil, MethodNotAllowed
+	}
+	return h, params, 0
+}
+
+func newRoute(pattern string) *route {
+	return &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		prefix:   strings.HasSuffix(pattern, "/"),
+		handlers: make(map[Code]Handler),
+	}
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
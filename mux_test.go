@@ -0,0 +1,104 @@
+package coap_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ironzhang/coap"
+	"github.com/ironzhang/coap/coaptest"
+)
+
+func NewTestMux() *coap.ServeMux {
+	reply := func(name string) func(coap.ResponseWriter, *coap.Request) {
+		return func(w coap.ResponseWriter, r *coap.Request) {
+			fmt.Fprintf(w, "%s id=%s", name, r.PathParam("id"))
+		}
+	}
+	mux := coap.NewServeMux()
+	mux.HandleFunc("/", reply("root"))
+	mux.HandleFunc("/files/", reply("files"))
+	mux.HandleFunc("/files/static/", reply("static"))
+	mux.HandleMethodFunc(coap.GET, "/sensors/{id}/temp", reply("get-temp"))
+	mux.HandleMethodFunc(coap.PUT, "/sensors/{id}/temp", reply("put-temp"))
+	mux.HandleMethodFunc(coap.GET, "/sensors/all/temp", reply("all-temp"))
+	mux.HandleMethodFunc(coap.GET, "/status", reply("status"))
+	mux.HandleMethodFunc(coap.FETCH, "/status", reply("fetch-status"))
+	mux.HandleMethodFunc(coap.IPATCH, "/sensors/{id}/temp", reply("ipatch-temp"))
+	return mux
+}
+
+func TestServeMux(t *testing.T) {
+	mux := NewTestMux()
+	tests := []struct {
+		method coap.Code
+		path   string
+		code   coap.Code
+		body   string
+	}{
+		{method: coap.GET, path: "/status", code: coap.Content, body: "status id="},
+		{method: coap.POST, path: "/status", code: coap.MethodNotAllowed, body: ""},
+		{method: coap.FETCH, path: "/status", code: coap.Content, body: "fetch-status id="},
+		{method: coap.IPATCH, path: "/sensors/9/temp", code: coap.Content, body: "ipatch-temp id=9"},
+		{method: coap.PATCH, path: "/sensors/9/temp", code: coap.MethodNotAllowed, body: ""},
+		{method: coap.GET, path: "/status/more", code: coap.Content, body: "root id="},
+		{method: coap.GET, path: "/sensors/7/temp", code: coap.Content, body: "get-temp id=7"},
+		{method: coap.PUT, path: "/sensors/8/temp", code: coap.Content, body: "put-temp id=8"},
+		{method: coap.DELETE, path: "/sensors/8/temp", code: coap.MethodNotAllowed, body: ""},
+		{method: coap.GET, path: "/sensors/all/temp", code: coap.Content, body: "all-temp id="},
+		{method: coap.GET, path: "/files", code: coap.Content, body: "files id="},
+		{method: coap.GET, path: "/files/a/b", code: coap.Content, body: "files id="},
+		{method: coap.GET, path: "/files/static/c", code: coap.Content, body: "static id="},
+		{method: coap.GET, path: "/", code: coap.Content, body: "root id="},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, tt.method, "coap://localhost"+tt.path, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		rec := coaptest.NewRecorder()
+		mux.ServeCOAP(rec, req)
+		if got, want := rec.Code, tt.code; got != want {
+			t.Errorf("case%d: %s %s: code: %v != %v", i, tt.method, tt.path, got, want)
+		}
+		if got, want := rec.Body.String(), tt.body; got != want {
+			t.Errorf("case%d: %s %s: body: %q != %q", i, tt.method, tt.path, got, want)
+		}
+	}
+}
+
+func TestServeMuxNotFound(t *testing.T) {
+	mux := coap.NewServeMux()
+	mux.HandleFunc("/a", func(w coap.ResponseWriter, r *coap.Request) {})
+
+	req, err := coap.NewRequest(true, coap.GET, "coap://localhost/b", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	rec := coaptest.NewRecorder()
+	mux.ServeCOAP(rec, req)
+	if got, want := rec.Code, coap.NotFound; got != want {
+		t.Errorf("code: %v != %v", got, want)
+	}
+}
+
+func TestServeMuxResources(t *testing.T) {
+	mux := NewTestMux()
+	mux.SetAttrs("/sensors/{id}/temp", map[string]string{"rt": "temperature", "if": "sensor"})
+
+	want := []coap.Resource{
+		{Pattern: "/"},
+		{Pattern: "/files/"},
+		{Pattern: "/files/static/"},
+		{Pattern: "/sensors/all/temp", Methods: []coap.Code{coap.GET}},
+		{
+			Pattern: "/sensors/{id}/temp",
+			Methods: []coap.Code{coap.GET, coap.PUT, coap.IPATCH},
+			Attrs:   map[string]string{"rt": "temperature", "if": "sensor"},
+		},
+		{Pattern: "/status", Methods: []coap.Code{coap.GET, coap.FETCH}},
+	}
+	if got := mux.Resources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resources: %v != %v", got, want)
+	}
+}
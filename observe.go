@@ -0,0 +1,267 @@
+package coap
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// confirmInterval 以NON发送通知时, 至少每隔该时间发送一次CON通知, 以便发现失效的观察者(RFC 7641 4.5)
+var confirmInterval = 24 * time.Hour
+
+// observation 服务端记录的观察关系
+type observation struct {
+	token     string
+	path      string
+	req       *Request
+	seq       uint32
+	messageID uint16
+	notified  bool
+	confirmed time.Time // 最近一次登记或发送CON通知的时间
+}
+
+// observations 观察者列表, 以token为键
+type observations struct {
+	mu sync.Mutex
+	m  map[string]*observation
+}
+
+// add 登记观察者, 返回注册响应使用的Observe序号
+func (p *observations) add(req *Request) uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.m == nil {
+		p.m = make(map[string]*observation)
+	}
+	token := string(req.Token)
+	o, ok := p.m[token]
+	if !ok {
+		o = &observation{token: token}
+		p.m[token] = o
+	}
+	o.path = cleanPath(req.URL.Path)
+	o.req = req
+	o.confirmed = time.Now()
+	return p.nextSeq(o)
+}
+
+// del 注销观察者, 返回观察者是否存在
+func (p *observations) del(token string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.m[token]
+	delete(p.m, token)
+	return ok
+}
+
+// delByMessageID 注销最近一次通知的消息ID为messageID的观察者, 返回其token
+func (p *observations) delByMessageID(messageID uint16) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for token, o := range p.m {
+		if o.notified && o.messageID == messageID {
+			delete(p.m, token)
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// next 为发往token的通知分配Observe序号, 并记录通知的消息ID
+func (p *observations) next(token string, messageID uint16) (uint32, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.m[token]
+	if !ok {
+		return 0, false
+	}
+	o.messageID = messageID
+	o.notified = true
+	return p.nextSeq(o), true
+}
+
+// confirmable 判断发往token的通知是否以CON发送, 距上次确认超过confirmInterval时即使要求NON也以CON发送
+func (p *observations) confirmable(token string, con bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.m[token]
+	if !ok {
+		return con
+	}
+	if con || time.Since(o.confirmed) >= confirmInterval {
+		o.confirmed = time.Now()
+		return true
+	}
+	return false
+}
+
+// find 返回观察资源path的观察关系的快照
+func (p *observations) find(path string) []observation {
+	path = cleanPath(path)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var res []observation
+	for _, o := range p.m {
+		if o.path == path {
+			res = append(res, *o)
+		}
+	}
+	return res
+}
+
+func (p *observations) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.m)
+}
+
+// nextSeq 返回下一个Observe序号, 序号为24位
+func (p *observations) nextSeq(o *observation) uint32 {
+	o.seq = (o.seq + 1) & 0xffffff
+	return o.seq
+}
+
+func cleanPath(path string) string {
+	return "/" + strings.Trim(path, "/")
+}
+
+func isSuccessCode(c Code) bool {
+	return c>>5 == 2
+}
+
+// updateObservation 根据请求及其响应登记或注销观察者, 登记成功时在响应中添加Observe选项
+func (s *session) updateObservation(req *Request, resp *response) {
+	if req.Method != GET {
+		return
+	}
+	v, ok := req.Options.Observe()
+	if !ok {
+		return
+	}
+	if v == 0 && isSuccessCode(resp.code) {
+		resp.options.Set(Observe, s.observations.add(req))
+	} else {
+		s.observations.del(string(req.Token))
+	}
+}
+
+// notify 重新调用Handler生成通知, 发送给资源path的所有观察者.
+// servingc由running协程关闭, 因此经由runningc转交, 会话已结束时返回ErrSessionClosed.
+func (s *session) notify(path string) error {
+	for _, o := range s.observations.find(path) {
+		token, req := o.token, o.req
+		serve := func() {
+			resp := &response{
+				session: s,
+				token:   token,
+				code:    Content,
+			}
+			s.handler.ServeCOAP(resp, req)
+			resp.readBody()
+			s.postNotification(resp)
+		}
+		if err := s.post(func() { s.servingc <- serve }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyPayload 以给定内容通知资源path的所有观察者
+func (s *session) notifyPayload(path string, status Code, options Options, payload []byte, confirmable bool) error {
+	for _, o := range s.observations.find(path) {
+		resp := &response{
+			session:     s,
+			confirmable: confirmable,
+			token:       o.token,
+			code:        status,
+			options:     options.clone(),
+		}
+		resp.buffer.Write(payload)
+		if err := s.postNotification(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) postNotification(r *response) error {
+	send := func() {
+		if err := s.sendNotification(r); err != nil {
+			s.logger().Log(LevelWarn, "send notification", "token", base.TokenString(r.token), "error", err)
+		}
+	}
+	return s.post(send)
+}
+
+func (s *session) sendNotification(r *response) error {
+	m := base.Message{
+		Type:      base.NON,
+		Code:      uint8(r.code),
+		MessageID: s.genMessageID(),
+		Token:     r.token,
+		Options:   r.options,
+		Payload:   r.buffer.Bytes(),
+	}
+	if s.stream {
+		m.Type = base.ACK
+	} else if s.observations.confirmable(r.token, r.confirmable) {
+		m.Type = base.CON
+	}
+	if isSuccessCode(r.code) {
+		seq, ok := s.observations.next(r.token, m.MessageID)
+		if !ok {
+			// 观察者已注销
+			return nil
+		}
+		m.SetOption(Observe, seq)
+	} else {
+		// 错误响应将结束观察关系
+		s.observations.del(r.token)
+	}
+	return s.sendMessage(m)
+}
+
+// notifications 等待观察者处理的通知队列, 同一token只保留最新的通知
+type notifications struct {
+	mu      sync.Mutex
+	tokens  []Token
+	pending map[Token]*Response
+	readyc  chan struct{}
+}
+
+// push 加入通知, 替换同一token尚未处理的通知时返回true
+func (q *notifications) push(r *Response) bool {
+	q.mu.Lock()
+	if q.pending == nil {
+		q.pending = make(map[Token]*Response)
+	}
+	_, replaced := q.pending[r.Token]
+	if !replaced {
+		q.tokens = append(q.tokens, r.Token)
+	}
+	q.pending[r.Token] = r
+	q.mu.Unlock()
+
+	select {
+	case q.readyc <- struct{}{}:
+	default:
+	}
+	return replaced
+}
+
+// pop 取出最早加入的通知
+func (q *notifications) pop() (*Response, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tokens) == 0 {
+		return nil, false
+	}
+	token := q.tokens[0]
+	q.tokens = q.tokens[1:]
+	r := q.pending[token]
+	delete(q.pending, token)
+	return r, true
+}
@@ -0,0 +1,328 @@
+package coap
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap/internal/gctable"
+)
+
+type TestObserveHandler struct {
+	count int64
+}
+
+func (h *TestObserveHandler) ServeCOAP(w ResponseWriter, r *Request) {
+	fmt.Fprintf(w, "%d", atomic.AddInt64(&h.count, 1))
+}
+
+type TestObserver chan *Response
+
+func (o TestObserver) ServeObserve(r *Response) {
+	o <- r
+}
+
+func ServeTestObserve(t *testing.T, h Handler) (*Server, string, func()) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	s := &Server{Handler: h}
+	go s.Serve("coap", ln)
+	return s, ln.LocalAddr().String(), func() { ln.Close() }
+}
+
+func ServerObservations(s *Server) int {
+	n := 0
+	s.sessions.Range(func(o gctable.Object) bool {
+		n += o.(*session).observations.len()
+		return true
+	})
+	return n
+}
+
+func WaitTestObservations(s *Server, n int) {
+	for i := 0; i < 100 && ServerObservations(s) != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func RecvTestObserve(t *testing.T, o TestObserver) *Response {
+	t.Helper()
+	select {
+	case r := <-o:
+		return r
+	case <-time.After(time.Second):
+		t.Fatalf("wait notification timeout")
+	}
+	return nil
+}
+
+func TestServerObserve(t *testing.T) {
+	s, addr, closer := ServeTestObserve(t, &TestObserveHandler{})
+	defer closer()
+
+	o := make(TestObserver, 8)
+	conn, err := DefaultClient.Dial("coap://"+addr, nil, o)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, err := NewRequest(true, GET, "coap://"+addr+"/temp", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Options.Set(Observe, 0)
+	resp, err := conn.sess.postRequestAndWaitResponse(req)
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if !resp.Options.Contain(Observe) {
+		t.Fatalf("registration response has no observe option")
+	}
+	RecvTestObserve(t, o)
+	if got, want := ServerObservations(s), 1; got != want {
+		t.Fatalf("observations: %d != %d", got, want)
+	}
+
+	last := resp.Options.Get(Observe).(uint32)
+	for i := 2; i <= 3; i++ {
+		s.Notify("/temp")
+		r := RecvTestObserve(t, o)
+		if got, want := string(r.Payload), fmt.Sprint(i); got != want {
+			t.Errorf("notification%d: payload: %q != %q", i, got, want)
+		}
+		seq := r.Options.Get(Observe).(uint32)
+		if seq <= last {
+			t.Errorf("notification%d: observe sequence %d <= %d", i, seq, last)
+		}
+		last = seq
+	}
+
+	// 错误响应不携带Observe选项, 将结束观察关系
+	s.NotifyPayload("temp", NotFound, nil, []byte("gone"), false)
+	WaitTestObservations(s, 0)
+	if got, want := ServerObservations(s), 0; got != want {
+		t.Errorf("observations after error: %d != %d", got, want)
+	}
+}
+
+func TestServerObserveReset(t *testing.T) {
+	s, addr, closer := ServeTestObserve(t, &TestObserveHandler{})
+	defer closer()
+
+	// 没有Observer的链接会以RST拒绝通知
+	conn, err := DefaultClient.Dial("coap://"+addr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, err := NewRequest(true, GET, "coap://"+addr+"/temp", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Options.Set(Observe, 0)
+	if _, err = conn.sess.postRequestAndWaitResponse(req); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if got, want := ServerObservations(s), 1; got != want {
+		t.Fatalf("observations: %d != %d", got, want)
+	}
+
+	s.Notify("/temp")
+	WaitTestObservations(s, 0)
+	if got, want := ServerObservations(s), 0; got != want {
+		t.Errorf("observations after reset: %d != %d", got, want)
+	}
+}
+
+func TestNotifyAfterClose(t *testing.T) {
+	s, addr, closer := ServeTestObserve(t, &TestObserveHandler{})
+	defer closer()
+
+	conn, err := DefaultClient.Dial("coap://"+addr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	req, err := NewRequest(true, GET, "coap://"+addr+"/temp", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Options.Set(Observe, 0)
+	if _, err = conn.sess.postRequestAndWaitResponse(req); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	conn.sess.observations.add(req)
+	conn.Close()
+
+	// 会话结束后通知不再发送, 也不会阻塞或panic
+	for i := 0; i < 20; i++ {
+		if err = conn.Notify("/temp"); err != ErrSessionClosed {
+			t.Fatalf("case%d: conn notify: %v != %v", i, err, ErrSessionClosed)
+		}
+		if err = conn.NotifyPayload("/temp", Content, nil, []byte("1"), false); err != ErrSessionClosed {
+			t.Fatalf("case%d: conn notify payload: %v != %v", i, err, ErrSessionClosed)
+		}
+	}
+
+	s.sessions.Range(func(o gctable.Object) bool {
+		o.(*session).Close()
+		return true
+	})
+	for i := 0; i < 20; i++ {
+		s.Notify("/temp")
+		s.NotifyPayload("/temp", Content, nil, []byte("1"), false)
+	}
+}
+
+type blockingObserver struct {
+	release chan struct{}
+	o       TestObserver
+}
+
+func (b *blockingObserver) ServeObserve(r *Response) {
+	<-b.release
+	b.o <- r
+}
+
+func TestSlowObserver(t *testing.T) {
+	s, addr, closer := ServeTestObserve(t, &TestObserveHandler{})
+	defer closer()
+
+	o := &blockingObserver{release: make(chan struct{}), o: make(TestObserver, 32)}
+	conn, err := DefaultClient.Dial("coap://"+addr, nil, o)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, err := NewRequest(true, GET, "coap://"+addr+"/temp", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Options.Set(Observe, 0)
+	if _, err = conn.sess.postRequestAndWaitResponse(req); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	const n = 20
+	for i := 1; i <= n; i++ {
+		s.NotifyPayload("/temp", Content, nil, []byte(fmt.Sprint(i)), false)
+	}
+
+	// 观察者阻塞时, 会话上的其他请求仍能完成
+	req, err = NewRequest(true, GET, "coap://"+addr+"/other", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.sess.postRequestAndWaitResponse(req)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("request blocked by slow observer")
+	}
+
+	// 丢弃积压的旧通知, 最后收到最新的状态
+	close(o.release)
+	for {
+		r := RecvTestObserve(t, o.o)
+		if string(r.Payload) == fmt.Sprint(n) {
+			break
+		}
+	}
+}
+
+func TestSlowObserverMerge(t *testing.T) {
+	s, addr, closer := ServeTestObserve(t, &TestObserveHandler{})
+	defer closer()
+
+	o := &blockingObserver{release: make(chan struct{}), o: make(TestObserver, 32)}
+	conn, err := DefaultClient.Dial("coap://"+addr, nil, o)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, path := range []string{"/a", "/b"} {
+		req, err := NewRequest(true, GET, "coap://"+addr+path, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Options.Set(Observe, 0)
+		if _, err = conn.sess.postRequestAndWaitResponse(req); err != nil {
+			t.Fatalf("register %s: %v", path, err)
+		}
+	}
+	WaitTestObservations(s, 2)
+
+	// 一个资源的大量通知不会挤掉另一个观察关系唯一的通知
+	const n = 20
+	s.NotifyPayload("/b", Content, nil, []byte("b"), false)
+	time.Sleep(10 * time.Millisecond)
+	for i := 1; i <= n; i++ {
+		s.NotifyPayload("/a", Content, nil, []byte(fmt.Sprint("a", i)), false)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	close(o.release)
+	got := make(map[string]bool)
+	for !got["b"] || !got[fmt.Sprint("a", n)] {
+		r := RecvTestObserve(t, o.o)
+		got[string(r.Payload)] = true
+	}
+	if len(got) > 4 {
+		t.Errorf("notifications are not merged: %d received", len(got))
+	}
+}
+
+func TestObserveConfirmInterval(t *testing.T) {
+	s, addr, closer := ServeTestObserve(t, &TestObserveHandler{})
+	defer closer()
+
+	o := make(TestObserver, 8)
+	conn, err := DefaultClient.Dial("coap://"+addr, nil, o)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, err := NewRequest(true, GET, "coap://"+addr+"/temp", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Options.Set(Observe, 0)
+	if _, err = conn.sess.postRequestAndWaitResponse(req); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	RecvTestObserve(t, o)
+
+	// 距上次确认超过confirmInterval时, NON通知以CON发送
+	defer func(d time.Duration) { confirmInterval = d }(confirmInterval)
+	confirmInterval = 100 * time.Millisecond
+	tests := []struct {
+		wait time.Duration
+		con  uint64
+	}{
+		{wait: 0, con: 0},
+		{wait: 150 * time.Millisecond, con: 1},
+		{wait: 0, con: 1},
+	}
+	for i, tt := range tests {
+		time.Sleep(tt.wait)
+		s.NotifyPayload("/temp", Content, nil, []byte("1"), false)
+		RecvTestObserve(t, o)
+		if got, want := s.Stats().MessagesOut.Types["CON"], tt.con; got != want {
+			t.Errorf("case%d: con notifications: %d != %d", i, got, want)
+		}
+	}
+}
@@ -85,11 +85,17 @@ func (p *Options) Write(w io.Writer) error {
 	})
 
 	for _, o := range *p {
-		s, ok := o.Value.(string)
-		if ok {
-			s = headerNewlineToSpace.Replace(s)
-			fmt.Fprintf(w, "%s: %s\r\n", base.OptionName(o.ID), s)
-		} else {
+		switch v := o.Value.(type) {
+		case string:
+			fmt.Fprintf(w, "%s: %s\r\n", base.OptionName(o.ID), headerNewlineToSpace.Replace(v))
+		case uint32:
+			// Content-Format及Accept附带注册的媒体类型
+			if mt := MediaType(v); mt != "" && (o.ID == ContentFormat || o.ID == Accept) {
+				fmt.Fprintf(w, "%s: %d (%s)\r\n", base.OptionName(o.ID), v, mt)
+			} else {
+				fmt.Fprintf(w, "%s: %d\r\n", base.OptionName(o.ID), v)
+			}
+		default:
 			fmt.Fprintf(w, "%s: %v\r\n", base.OptionName(o.ID), o.Value)
 		}
 	}
@@ -144,3 +150,81 @@ func (p *Options) GetQuery() string {
 	querys := p.GetStrings(URIQuery)
 	return strings.Join(querys, "&")
 }
+
+// BlockOption Block1/Block2选项的值
+type BlockOption = base.BlockOption
+
+func (p *Options) getUint(id uint16) (uint32, bool) {
+	v, ok := p.Get(id).(uint32)
+	return v, ok
+}
+
+// ContentFormat 获取Content-Format选项.
+func (p *Options) ContentFormat() (uint32, bool) {
+	return p.getUint(ContentFormat)
+}
+
+// Accept 获取Accept选项.
+func (p *Options) Accept() (uint32, bool) {
+	return p.getUint(Accept)
+}
+
+// MaxAge 获取Max-Age选项, 单位为秒. 不包含该选项时应视为60秒.
+func (p *Options) MaxAge() (uint32, bool) {
+	return p.getUint(MaxAge)
+}
+
+// ETags 获取所有ETag选项.
+func (p *Options) ETags() [][]byte {
+	values := p.GetValues(ETag)
+	etags := make([][]byte, 0, len(values))
+	for _, v := range values {
+		if b, ok := v.([]byte); ok {
+			etags = append(etags, b)
+		}
+	}
+	return etags
+}
+
+// Observe 获取Observe选项.
+func (p *Options) Observe() (uint32, bool) {
+	return p.getUint(Observe)
+}
+
+// Block1 获取Block1选项.
+func (p *Options) Block1() (BlockOption, bool) {
+	return p.getBlock(Block1)
+}
+
+// Block2 获取Block2选项.
+func (p *Options) Block2() (BlockOption, bool) {
+	return p.getBlock(Block2)
+}
+
+func (p *Options) getBlock(id uint16) (BlockOption, bool) {
+	v, ok := p.getUint(id)
+	if !ok {
+		return BlockOption{}, false
+	}
+	return base.ParseBlockOption(v), true
+}
+
+// URIPort 获取Uri-Port选项.
+func (p *Options) URIPort() (uint32, bool) {
+	return p.getUint(URIPort)
+}
+
+// Size1 获取Size1选项.
+func (p *Options) Size1() (uint32, bool) {
+	return p.getUint(Size1)
+}
+
+// Size2 获取Size2选项.
+func (p *Options) Size2() (uint32, bool) {
+	return p.getUint(Size2)
+}
+
+// LocationPath 获取LocationPath.
+func (p *Options) LocationPath() string {
+	return strings.Join(p.GetStrings(LocationPath), "/")
+}
@@ -237,6 +237,66 @@ func TestOptionsWrite(t *testing.T) {
 	}
 }
 
+func TestOptionsWriteMediaType(t *testing.T) {
+	options := Options{
+		{ID: Accept, Value: AppCBOR},
+		{ID: ContentFormat, Value: AppJSON},
+		{ID: MaxAge, Value: uint32(30)},
+		{ID: ContentFormat, Value: uint32(65000)},
+	}
+	s := "Content-Format: 50 (application/json)\r\nContent-Format: 65000\r\nMax-Age: 30\r\nAccept: 60 (application/cbor)\r\n"
+
+	var b bytes.Buffer
+	options.Write(&b)
+	if got, want := b.String(), s; got != want {
+		t.Errorf("%q != %q", got, want)
+	}
+}
+
+func TestOptionsAccessors(t *testing.T) {
+	options := Options{
+		{ID: ETag, Value: []byte("a")},
+		{ID: Observe, Value: uint32(3)},
+		{ID: LocationPath, Value: "a"},
+		{ID: LocationPath, Value: "b"},
+		{ID: ContentFormat, Value: AppSenMLCBOR},
+		{ID: MaxAge, Value: uint32(0)},
+		{ID: ETag, Value: []byte("b")},
+		{ID: Block2, Value: BlockOption{Num: 2, More: true, Size: 64}.Value()},
+		{ID: Size1, Value: uint32(1024)},
+	}
+	tests := []struct {
+		get   func() (uint32, bool)
+		value uint32
+		ok    bool
+	}{
+		{get: options.ContentFormat, value: AppSenMLCBOR, ok: true},
+		{get: options.Accept, value: 0, ok: false},
+		{get: options.MaxAge, value: 0, ok: true},
+		{get: options.Observe, value: 3, ok: true},
+		{get: options.Size1, value: 1024, ok: true},
+		{get: options.Size2, value: 0, ok: false},
+	}
+	for i, tt := range tests {
+		if value, ok := tt.get(); value != tt.value || ok != tt.ok {
+			t.Errorf("case%d: %v %t != %v %t", i, value, ok, tt.value, tt.ok)
+		}
+	}
+
+	if got, want := options.ETags(), [][]byte{[]byte("a"), []byte("b")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("etags: %q != %q", got, want)
+	}
+	if got, want := options.LocationPath(), "a/b"; got != want {
+		t.Errorf("location path: %q != %q", got, want)
+	}
+	if opt, ok := options.Block1(); ok {
+		t.Errorf("block1: %v", opt)
+	}
+	if got, ok := options.Block2(); !ok || got != (BlockOption{Num: 2, More: true, Size: 64}) {
+		t.Errorf("block2: %v %t", got, ok)
+	}
+}
+
 func TestOptionsSetStrings(t *testing.T) {
 	want := Options{
 		{ID: 0, Value: "a"},
@@ -0,0 +1,71 @@
+package coap
+
+import (
+	"bytes"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+	"github.com/ironzhang/coap/internal/stack/oscore"
+)
+
+// OSCORE 选项编号(RFC 8613)
+const OSCORE = base.OSCORE
+
+// OSCOREContext OSCORE(RFC 8613)安全上下文, 用于端到端保护请求及响应, 经过代理时代理只能看到外部选项.
+//
+// 发送序号及重放窗口仅保存在内存中, 同一组参数的上下文不能在重启后继续使用.
+type OSCOREContext struct {
+	ctx *oscore.Context
+}
+
+// NewOSCOREContext 由预共享的Master Secret、Master Salt及双方ID派生安全上下文,
+// 使用HKDF-SHA256及AES-CCM-16-64-128. masterSalt及idContext可以为nil.
+func NewOSCOREContext(masterSecret, masterSalt, senderID, recipientID, idContext []byte) (*OSCOREContext, error) {
+	ctx, err := oscore.NewContext(masterSecret, masterSalt, senderID, recipientID, idContext)
+	if err != nil {
+		return nil, err
+	}
+	return &OSCOREContext{ctx: ctx}, nil
+}
+
+// SenderID 返回发送者ID
+func (c *OSCOREContext) SenderID() []byte {
+	return c.ctx.SenderID
+}
+
+// RecipientID 返回接收者ID
+func (c *OSCOREContext) RecipientID() []byte {
+	return c.ctx.RecipientID
+}
+
+// IDContext 返回ID Context
+func (c *OSCOREContext) IDContext() []byte {
+	return c.ctx.IDContext
+}
+
+func (c *OSCOREContext) context() *oscore.Context {
+	if c == nil {
+		return nil
+	}
+	return c.ctx
+}
+
+// oscoreLayer 创建OSCORE协议层, 未配置OSCORE时返回nil.
+//
+// client用于保护发出的请求; contexts不为空时只接受受保护的请求, 按kid及kid context查找接收者上下文.
+func oscoreLayer(client *OSCOREContext, contexts []*OSCOREContext, generator func() uint16) base.Layer {
+	if client == nil && len(contexts) == 0 {
+		return nil
+	}
+	var lookup oscore.Lookup
+	if len(contexts) > 0 {
+		lookup = func(kid, kidContext []byte) *oscore.Context {
+			for _, c := range contexts {
+				if bytes.Equal(c.ctx.RecipientID, kid) && bytes.Equal(c.ctx.IDContext, kidContext) {
+					return c.ctx
+				}
+			}
+			return nil
+		}
+	}
+	return oscore.NewLayer(client.context(), lookup, generator)
+}
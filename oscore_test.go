@@ -0,0 +1,86 @@
+package coap_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/ironzhang/coap"
+)
+
+func NewTestOSCOREContexts(t *testing.T) (client, server *coap.OSCOREContext) {
+	secret := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	salt := []byte{0x9e, 0x7c, 0xa9, 0x22, 0x23, 0x78, 0x63, 0x40}
+	client, err := coap.NewOSCOREContext(secret, salt, []byte{}, []byte{0x01}, nil)
+	if err != nil {
+		t.Fatalf("new client context: %v", err)
+	}
+	server, err = coap.NewOSCOREContext(secret, salt, []byte{0x01}, []byte{}, nil)
+	if err != nil {
+		t.Fatalf("new server context: %v", err)
+	}
+	return client, server
+}
+
+func TestOSCORE(t *testing.T) {
+	cctx, sctx := NewTestOSCOREContexts(t)
+	h := func(w coap.ResponseWriter, r *coap.Request) {
+		w.Write([]byte(r.URL.Path + ":"))
+		w.Write(r.Payload)
+	}
+	s := &coap.Server{Handler: coap.HandlerFunc(h), OSCORE: []*coap.OSCOREContext{sctx}}
+
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go s.Serve("coap", ln)
+	tln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer tln.Close()
+	go s.ServeTCP(tln)
+
+	client := &coap.Client{OSCORE: cctx}
+	tests := []struct {
+		urlstr      string
+		confirmable bool
+		payload     []byte
+	}{
+		{urlstr: "coap://" + ln.LocalAddr().String() + "/secret", confirmable: true, payload: []byte("hello")},
+		{urlstr: "coap://" + ln.LocalAddr().String() + "/secret", confirmable: false, payload: []byte("hello")},
+		{urlstr: "coap://" + ln.LocalAddr().String() + "/large", confirmable: true, payload: bytes.Repeat([]byte("0123456789"), 300)},
+		{urlstr: "coap+tcp://" + tln.Addr().String() + "/secret", confirmable: true, payload: []byte("hello")},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(tt.confirmable, coap.POST, tt.urlstr, tt.payload)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := resp.Status, coap.Content; got != want {
+			t.Errorf("case%d: status: %v != %v", i, got, want)
+		}
+		if got, want := string(resp.Payload), req.URL.Path+":"+string(tt.payload); got != want {
+			t.Errorf("case%d: payload: %q != %q", i, got, want)
+		}
+	}
+
+	// 未受保护的请求被拒绝
+	req, err := coap.NewRequest(true, coap.GET, "coap://"+ln.LocalAddr().String()+"/secret", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := (&coap.Client{}).SendRequest(req)
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if got, want := resp.Status, coap.Unauthorized; got != want {
+		t.Errorf("status: %v != %v", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package coap
+
+import (
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// TransmissionParams COAP传输参数(RFC 7252 4.8), 为零的字段使用协议默认值.
+//
+// 重传、去重、块传输及响应等待的超时均由这些参数按RFC 7252 4.8.2推导,
+// 例如低功耗网络可调大AckTimeout, 局域网测试可调小AckTimeout及MaxLatency.
+type TransmissionParams struct {
+	AckTimeout      time.Duration // ACK_TIMEOUT, 默认2s
+	AckRandomFactor float64       // ACK_RANDOM_FACTOR, 默认1.5, 小于1时使用默认值
+	MaxRetransmit   int           // MAX_RETRANSMIT, 默认4
+	MaxLatency      time.Duration // MAX_LATENCY, 默认100s
+	ProcessingDelay time.Duration // PROCESSING_DELAY, 默认2s
+	MaxBlockSize    uint32        // 块传输的最大块大小, 须为16至1024之间2的幂, 默认1024
+}
+
+// base 填充默认值后转换为协议栈参数, p可以为nil
+func (p *TransmissionParams) base() base.Params {
+	bp := base.DefaultParams()
+	if p == nil {
+		return bp
+	}
+	if p.AckTimeout > 0 {
+		bp.AckTimeout = p.AckTimeout
+	}
+	if p.AckRandomFactor >= 1 {
+		bp.AckRandomFactor = p.AckRandomFactor
+	}
+	if p.MaxRetransmit > 0 {
+		bp.MaxRetransmit = p.MaxRetransmit
+	}
+	if p.MaxLatency > 0 {
+		bp.MaxLatency = p.MaxLatency
+	}
+	if p.ProcessingDelay > 0 {
+		bp.ProcessingDelay = p.ProcessingDelay
+	}
+	if isValidBlockSize(p.MaxBlockSize) {
+		bp.MaxBlockSize = p.MaxBlockSize
+	}
+	return bp
+}
+
+func isValidBlockSize(size uint32) bool {
+	return size >= 16 && size <= base.MAX_BLOCKSIZE && size&(size-1) == 0
+}
+
+// MaxTransmitSpan 返回MAX_TRANSMIT_SPAN
+func (p *TransmissionParams) MaxTransmitSpan() time.Duration {
+	return p.base().MaxTransmitSpan()
+}
+
+// MaxTransmitWait 返回MAX_TRANSMIT_WAIT
+func (p *TransmissionParams) MaxTransmitWait() time.Duration {
+	return p.base().MaxTransmitWait()
+}
+
+// MaxRTT 返回MAX_RTT
+func (p *TransmissionParams) MaxRTT() time.Duration {
+	return p.base().MaxRTT()
+}
+
+// ExchangeLifetime 返回EXCHANGE_LIFETIME
+func (p *TransmissionParams) ExchangeLifetime() time.Duration {
+	return p.base().ExchangeLifetime()
+}
+
+// NonLifetime 返回NON_LIFETIME
+func (p *TransmissionParams) NonLifetime() time.Duration {
+	return p.base().NonLifetime()
+}
@@ -0,0 +1,119 @@
+package coap
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTransmissionParams(t *testing.T) {
+	tests := []struct {
+		params          *TransmissionParams
+		maxTransmitSpan time.Duration
+		maxTransmitWait time.Duration
+		maxRTT          time.Duration
+		exchangeLife    time.Duration
+		nonLife         time.Duration
+	}{
+		{
+			params:          nil,
+			maxTransmitSpan: 45 * time.Second,
+			maxTransmitWait: 93 * time.Second,
+			maxRTT:          202 * time.Second,
+			exchangeLife:    247 * time.Second,
+			nonLife:         145 * time.Second,
+		},
+		{
+			params:          &TransmissionParams{},
+			maxTransmitSpan: 45 * time.Second,
+			maxTransmitWait: 93 * time.Second,
+			maxRTT:          202 * time.Second,
+			exchangeLife:    247 * time.Second,
+			nonLife:         145 * time.Second,
+		},
+		{
+			params:          &TransmissionParams{AckTimeout: 10 * time.Second, MaxRetransmit: 2, MaxLatency: 60 * time.Second},
+			maxTransmitSpan: 45 * time.Second,
+			maxTransmitWait: 105 * time.Second,
+			maxRTT:          122 * time.Second,
+			exchangeLife:    167 * time.Second,
+			nonLife:         105 * time.Second,
+		},
+		{
+			params:          &TransmissionParams{AckTimeout: 100 * time.Millisecond, AckRandomFactor: 1, MaxRetransmit: 3, MaxLatency: time.Second, ProcessingDelay: 100 * time.Millisecond},
+			maxTransmitSpan: 700 * time.Millisecond,
+			maxTransmitWait: 1500 * time.Millisecond,
+			maxRTT:          2100 * time.Millisecond,
+			exchangeLife:    2800 * time.Millisecond,
+			nonLife:         1700 * time.Millisecond,
+		},
+	}
+	for i, tt := range tests {
+		if got, want := tt.params.MaxTransmitSpan(), tt.maxTransmitSpan; got != want {
+			t.Errorf("case%d: MaxTransmitSpan: %v != %v", i, got, want)
+		}
+		if got, want := tt.params.MaxTransmitWait(), tt.maxTransmitWait; got != want {
+			t.Errorf("case%d: MaxTransmitWait: %v != %v", i, got, want)
+		}
+		if got, want := tt.params.MaxRTT(), tt.maxRTT; got != want {
+			t.Errorf("case%d: MaxRTT: %v != %v", i, got, want)
+		}
+		if got, want := tt.params.ExchangeLifetime(), tt.exchangeLife; got != want {
+			t.Errorf("case%d: ExchangeLifetime: %v != %v", i, got, want)
+		}
+		if got, want := tt.params.NonLifetime(), tt.nonLife; got != want {
+			t.Errorf("case%d: NonLifetime: %v != %v", i, got, want)
+		}
+	}
+}
+
+func TestTransmissionParamsMaxBlockSize(t *testing.T) {
+	tests := []struct {
+		size uint32
+		want uint32
+	}{
+		{size: 0, want: 1024},
+		{size: 16, want: 16},
+		{size: 256, want: 256},
+		{size: 100, want: 1024},
+		{size: 2048, want: 1024},
+	}
+	for i, tt := range tests {
+		p := &TransmissionParams{MaxBlockSize: tt.size}
+		if got := p.base().MaxBlockSize; got != tt.want {
+			t.Errorf("case%d: MaxBlockSize: %v != %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestClientTransmissionParams(t *testing.T) {
+	// 不响应任何请求的对端
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+
+	// CON请求按EXCHANGE_LIFETIME, NON请求按NON_LIFETIME超时
+	params := &TransmissionParams{AckTimeout: 20 * time.Millisecond, MaxRetransmit: 2, MaxLatency: 100 * time.Millisecond}
+	c := &Client{TransmissionParams: params}
+	conn, err := c.Dial("coap://"+ln.LocalAddr().String(), nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	for i, confirmable := range []bool{true, false} {
+		req, err := NewRequest(confirmable, GET, "coap://"+ln.LocalAddr().String()+"/silent", nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		start := time.Now()
+		if _, err = conn.SendRequest(req); err != ErrTimeout {
+			t.Errorf("case%d: send request: %v != %v", i, err, ErrTimeout)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("case%d: send request returns after %v", i, d)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package coap
+
+import (
+	"net/url"
+	"time"
+)
+
+// DefaultIdleTimeout Client.IdleTimeout未设置时, 池中空闲链接的超时时间
+const DefaultIdleTimeout = 90 * time.Second
+
+// pooledConn 链接池中的链接
+type pooledConn struct {
+	key    string
+	conn   *Conn
+	active int         // 使用中的请求数
+	used   time.Time   // 最后一次释放的时间
+	timer  *time.Timer // 空闲超时定时器
+}
+
+// endpointKey 以scheme及host区分端点
+func endpointKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+// getConn 从链接池中获取到u所在端点的链接, 不存在时建立新的链接.
+//
+// 端点数达到MaxEndpoints且没有可淘汰的空闲链接时, 返回的链接不放入链接池, 使用后即关闭.
+func (c *Client) getConn(u *url.URL) (*pooledConn, error) {
+	key := endpointKey(u)
+
+	c.mu.Lock()
+	if pc, ok := c.conns[key]; ok {
+		if !pc.conn.isClosed() {
+			pc.active++
+			if pc.timer != nil {
+				pc.timer.Stop()
+				pc.timer = nil
+			}
+			c.mu.Unlock()
+			return pc, nil
+		}
+		delete(c.conns, key)
+	}
+	c.mu.Unlock()
+
+	conn, err := c.dialConn(u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledConn{key: key, conn: conn, active: 1}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.conns[key]; ok && !old.conn.isClosed() {
+		// 并发建立了到同一端点的链接, 使用已入池的链接
+		conn.Close()
+		old.active++
+		if old.timer != nil {
+			old.timer.Stop()
+			old.timer = nil
+		}
+		return old, nil
+	}
+	if c.MaxEndpoints > 0 && len(c.conns) >= c.MaxEndpoints && !c.evictLocked() {
+		pc.key = ""
+		return pc, nil
+	}
+	if c.conns == nil {
+		c.conns = make(map[string]*pooledConn)
+	}
+	c.conns[key] = pc
+	return pc, nil
+}
+
+// putConn 归还链接, 未入池的链接直接关闭
+func (c *Client) putConn(pc *pooledConn) {
+	if pc.key == "" {
+		pc.conn.Close()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pc.active--
+	pc.used = time.Now()
+	if pc.active > 0 || c.conns[pc.key] != pc {
+		return
+	}
+	pc.timer = time.AfterFunc(c.idleTimeout(), func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conns[pc.key] == pc && pc.active == 0 && time.Since(pc.used) >= c.idleTimeout() {
+			delete(c.conns, pc.key)
+			pc.conn.Close()
+		}
+	})
+}
+
+// evictLocked 关闭最久未使用的空闲链接, 没有空闲链接时返回false
+func (c *Client) evictLocked() bool {
+	var lru *pooledConn
+	for _, pc := range c.conns {
+		if pc.active > 0 {
+			continue
+		}
+		if lru == nil || pc.used.Before(lru.used) {
+			lru = pc
+		}
+	}
+	if lru == nil {
+		return false
+	}
+	c.closeLocked(lru)
+	return true
+}
+
+func (c *Client) closeLocked(pc *pooledConn) {
+	if pc.timer != nil {
+		pc.timer.Stop()
+	}
+	delete(c.conns, pc.key)
+	pc.conn.Close()
+}
+
+// CloseIdleConnections 关闭链接池中所有空闲的链接, 使用中的链接不受影响.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pc := range c.conns {
+		if pc.active == 0 {
+			c.closeLocked(pc)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package coap
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func ServeTestPool(t *testing.T) (string, func()) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	h := func(w ResponseWriter, r *Request) { w.Write(r.Payload) }
+	s := &Server{Handler: HandlerFunc(h)}
+	go s.Serve("coap", ln)
+	return ln.LocalAddr().String(), func() { ln.Close() }
+}
+
+func PoolConns(c *Client) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+func SendTestPoolRequest(t *testing.T, c *Client, addr string) *Conn {
+	t.Helper()
+	req, err := NewRequest(true, POST, "coap://"+addr+"/pool", []byte("hello"))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if _, err = c.SendRequest(req); err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pc, ok := c.conns["coap://"+addr]; ok {
+		return pc.conn
+	}
+	return nil
+}
+
+func TestClientPoolReuse(t *testing.T) {
+	addr, closer := ServeTestPool(t)
+	defer closer()
+
+	c := &Client{}
+	defer c.CloseIdleConnections()
+	first := SendTestPoolRequest(t, c, addr)
+	second := SendTestPoolRequest(t, c, addr)
+	if first == nil || first != second {
+		t.Errorf("conn not reused: %p != %p", first, second)
+	}
+	if got, want := PoolConns(c), 1; got != want {
+		t.Errorf("conns: %d != %d", got, want)
+	}
+
+	c.CloseIdleConnections()
+	if got, want := PoolConns(c), 0; got != want {
+		t.Errorf("conns after close idle: %d != %d", got, want)
+	}
+	if !first.isClosed() {
+		t.Errorf("idle conn not closed")
+	}
+}
+
+func TestClientPoolIdleTimeout(t *testing.T) {
+	addr, closer := ServeTestPool(t)
+	defer closer()
+
+	c := &Client{IdleTimeout: 50 * time.Millisecond}
+	conn := SendTestPoolRequest(t, c, addr)
+	time.Sleep(200 * time.Millisecond)
+	if got, want := PoolConns(c), 0; got != want {
+		t.Errorf("conns: %d != %d", got, want)
+	}
+	if !conn.isClosed() {
+		t.Errorf("idle conn not closed")
+	}
+}
+
+func TestClientPoolMaxEndpoints(t *testing.T) {
+	addr1, closer1 := ServeTestPool(t)
+	defer closer1()
+	addr2, closer2 := ServeTestPool(t)
+	defer closer2()
+
+	c := &Client{MaxEndpoints: 1}
+	defer c.CloseIdleConnections()
+	conn1 := SendTestPoolRequest(t, c, addr1)
+	SendTestPoolRequest(t, c, addr2)
+	if got, want := PoolConns(c), 1; got != want {
+		t.Errorf("conns: %d != %d", got, want)
+	}
+	if !conn1.isClosed() {
+		t.Errorf("least recently used conn not evicted")
+	}
+}
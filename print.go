@@ -1,6 +1,7 @@
 package coap
 
 import (
+	"encoding/hex"
 	"fmt"
 	"io"
 )
@@ -9,7 +10,7 @@ func PrintRequest(w io.Writer, r *Request, body bool) {
 	fmt.Fprintf(w, "CON[%t] %s %s\n", r.Confirmable, r.Method, r.URL.String())
 	r.Options.Write(w)
 	if body {
-		fmt.Fprintf(w, "\n%s\n", r.Payload)
+		printPayload(w, r.Options, r.Payload)
 	}
 }
 
@@ -17,6 +18,15 @@ func PrintResponse(w io.Writer, r *Response, body bool) {
 	fmt.Fprintf(w, "ACK[%t] %s\n", r.Ack, r.Status)
 	r.Options.Write(w)
 	if body {
-		fmt.Fprintf(w, "\n%s\n", r.Payload)
+		printPayload(w, r.Options, r.Payload)
 	}
 }
+
+// printPayload 输出负载, Content-Format为非文本的媒体类型时以十六进制输出
+func printPayload(w io.Writer, options Options, payload []byte) {
+	if cf, ok := options.ContentFormat(); ok && !isTextMediaType(MediaType(cf)) {
+		fmt.Fprintf(w, "\n%s", hex.Dump(payload))
+		return
+	}
+	fmt.Fprintf(w, "\n%s\n", payload)
+}
@@ -0,0 +1,253 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Proxy COAP正向代理(RFC 7252 5.7), 将带有Proxy-Uri或Proxy-Scheme选项的请求转发至目标服务器.
+//
+// GET及FETCH请求的响应保存在代理的共享缓存中, 按Max-Age判断新鲜度, 过期后以ETag向目标服务器重新验证.
+// 不支持的scheme以ProxyingNotSupported响应, 目标服务器无响应时以GatewayTimeout响应.
+// 代理不转发Observe, 观察请求按普通请求处理.
+type Proxy struct {
+	// Client 转发请求使用的Client, 为nil时使用DefaultClient
+	Client *Client
+
+	// Next 处理不含代理选项的请求, 为nil时以NotFound响应
+	Next Handler
+
+	// Cache 代理的共享缓存, 为nil时使用默认容量的LRUCache, 设为NoCache时不缓存
+	Cache Cache
+
+	cacheOnce sync.Once
+	cache     cache
+}
+
+// proxySchemes 代理支持的目标scheme
+var proxySchemes = map[string]bool{
+	"coap":      true,
+	"coaps":     true,
+	"coap+tcp":  true,
+	"coaps+tcp": true,
+	"coap+ws":   true,
+	"coaps+ws":  true,
+}
+
+// proxyOptions 转发时不复制的选项, 由目标url重新生成或由协议栈处理
+var proxyOptions = map[uint16]bool{
+	URIHost:     true,
+	URIPort:     true,
+	URIPath:     true,
+	URIQuery:    true,
+	ProxyURI:    true,
+	ProxyScheme: true,
+	Observe:     true,
+	Block1:      true,
+	Block2:      true,
+	Size1:       true,
+	Size2:       true,
+	ETag:        true,
+}
+
+func (p *Proxy) getCache() cache {
+	p.cacheOnce.Do(func() {
+		p.cache = cache{p.Cache}
+		if p.Cache == nil {
+			p.cache = cache{NewLRUCache(DefaultCacheEntries, DefaultCacheBytes)}
+		}
+	})
+	return p.cache
+}
+
+func (p *Proxy) client() *Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return DefaultClient
+}
+
+func (p *Proxy) ServeCOAP(w ResponseWriter, r *Request) {
+	target, err := ProxyTarget(r)
+	if err != nil {
+		w.WriteCode(BadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	if target == nil {
+		if p.Next != nil {
+			p.Next.ServeCOAP(w, r)
+			return
+		}
+		w.WriteCode(NotFound)
+		return
+	}
+	if !proxySchemes[target.Scheme] {
+		w.WriteCode(ProxyingNotSupported)
+		return
+	}
+
+	out, err := NewRequest(r.Confirmable, r.Method, target.String(), r.Payload)
+	if err != nil {
+		w.WriteCode(BadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	if u, ok := r.Body.(*blockUpload); ok {
+		// 流式接收的负载逐块转发
+		out.Body = u
+	}
+	for _, o := range r.Options {
+		if !proxyOptions[o.ID] {
+			out.Options.Add(o.ID, o.Value)
+		}
+	}
+	out = out.WithContext(r.Context())
+	etags := r.Options.GetValues(ETag)
+
+	c := p.getCache()
+	if !isSafeMethod(r.Method) {
+		resp, err := p.client().SendRequest(out)
+		if err != nil {
+			writeProxyError(w, err)
+			return
+		}
+		c.invalidate(out, resp)
+		writeProxyResponse(w, resp, etags)
+		return
+	}
+
+	cached, ok := c.lookup(out)
+	if ok && cached.Fresh() {
+		resp := cached.Response.clone()
+		resp.Options.Set(MaxAge, cached.maxAge())
+		writeProxyResponse(w, resp, etags)
+		return
+	}
+
+	// 以缓存响应的ETag重新验证
+	valid := out
+	if ok {
+		valid = validationRequest(out, cached.Response)
+	}
+	resp, err := p.client().SendRequest(valid)
+	if err != nil {
+		writeProxyError(w, err)
+		return
+	}
+	if ok && resp.Status == Valid {
+		if merged, ok := c.revalidate(out, resp); ok {
+			writeProxyResponse(w, merged, etags)
+			return
+		}
+		// 缓存响应已被淘汰或ETag不一致, 不能以Valid回复未携带该ETag的客户端, 不带缓存的ETag重新请求
+		if resp, err = p.client().SendRequest(out); err != nil {
+			writeProxyError(w, err)
+			return
+		}
+	}
+	c.Add(out, resp)
+	writeProxyResponse(w, resp, etags)
+}
+
+// ProxyTarget 由请求的Proxy-Uri选项, 或Proxy-Scheme选项及Uri-*选项确定代理的目标url, 不含代理选项时返回nil.
+func ProxyTarget(r *Request) (*url.URL, error) {
+	if uri, ok := r.Options.Get(ProxyURI).(string); ok {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		if !u.IsAbs() || u.Host == "" {
+			return nil, errors.New("coap: Proxy-Uri is not an absolute uri")
+		}
+		return u, nil
+	}
+	scheme, ok := r.Options.Get(ProxyScheme).(string)
+	if !ok {
+		return nil, nil
+	}
+	host, ok := r.Options.Get(URIHost).(string)
+	if !ok {
+		return nil, errors.New("coap: Proxy-Scheme without Uri-Host")
+	}
+	u := &url.URL{Scheme: scheme, Host: host, Path: "/" + r.Options.GetPath(), RawQuery: r.Options.GetQuery()}
+	if port, ok := r.Options.URIPort(); ok {
+		u.Host = fmt.Sprintf("%s:%d", host, port)
+	}
+	return u, nil
+}
+
+func writeProxyError(w ResponseWriter, err error) {
+	if err == ErrTimeout {
+		w.WriteCode(GatewayTimeout)
+	} else {
+		w.WriteCode(BadGateway)
+	}
+	fmt.Fprint(w, err)
+}
+
+// writeProxyResponse 输出目标服务器的响应, 响应的ETag与请求的ETag之一相同时以Valid响应
+func writeProxyResponse(w ResponseWriter, resp *Response, etags []interface{}) {
+	if resp.Status == Content {
+		if etag := resp.Options.Get(ETag); etag != nil && containsETag(etags, etag) {
+			w.WriteCode(Valid)
+			w.Options().Set(ETag, etag)
+			if age := resp.Options.Get(MaxAge); age != nil {
+				w.Options().Set(MaxAge, age)
+			}
+			return
+		}
+	}
+	w.WriteCode(resp.Status)
+	for _, o := range resp.Options {
+		if o.ID != Block1 && o.ID != Block2 && o.ID != Size1 && o.ID != Size2 {
+			w.Options().Add(o.ID, o.Value)
+		}
+	}
+	w.Write(resp.Payload)
+}
+
+func containsETag(etags []interface{}, etag interface{}) bool {
+	for _, e := range etags {
+		if bytes.Equal(etagBytes(e), etagBytes(etag)) {
+			return true
+		}
+	}
+	return false
+}
+
+func etagBytes(v interface{}) []byte {
+	switch tv := v.(type) {
+	case []byte:
+		return tv
+	case string:
+		return []byte(tv)
+	}
+	return nil
+}
+
+// ProxyURL 返回总是使用代理fixed的Client.Proxy函数
+func ProxyURL(fixed *url.URL) func(*Request) (*url.URL, error) {
+	return func(*Request) (*url.URL, error) {
+		return fixed, nil
+	}
+}
+
+// proxyRequest 将请求改写为发往代理的请求, 目标url以Proxy-Uri选项携带
+func proxyRequest(req *Request) *Request {
+	r := new(Request)
+	*r = *req
+	r.Options = make(Options, 0, len(req.Options)+1)
+	for _, o := range req.Options {
+		switch o.ID {
+		case URIHost, URIPort, URIPath, URIQuery, ProxyURI, ProxyScheme:
+		default:
+			r.Options = append(r.Options, o)
+		}
+	}
+	r.Options.Set(ProxyURI, req.URL.String())
+	return r
+}
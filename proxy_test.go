@@ -0,0 +1,213 @@
+package coap_test
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap"
+)
+
+func ServeTestProxy(t *testing.T) (origin, proxy string, hits, validated *int32, closer func()) {
+	hits, validated = new(int32), new(int32)
+	etag := []byte("v1")
+	h := func(w coap.ResponseWriter, r *coap.Request) {
+		atomic.AddInt32(hits, 1)
+		w.Options().Set(coap.ETag, etag)
+		w.Options().Set(coap.MaxAge, uint32(1))
+		if v, ok := r.Options.Get(coap.ETag).([]byte); ok && bytes.Equal(v, etag) {
+			atomic.AddInt32(validated, 1)
+			w.WriteCode(coap.Valid)
+			return
+		}
+		w.Write([]byte("hello " + r.URL.Path))
+	}
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	go (&coap.Server{Handler: coap.HandlerFunc(h)}).Serve("coap", ln)
+
+	pln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	go (&coap.Server{Handler: &coap.Proxy{}}).Serve("coap", pln)
+
+	return ln.LocalAddr().String(), pln.LocalAddr().String(), hits, validated, func() {
+		ln.Close()
+		pln.Close()
+	}
+}
+
+func TestProxy(t *testing.T) {
+	origin, proxy, hits, validated, closer := ServeTestProxy(t)
+	defer closer()
+
+	client := &coap.Client{Proxy: coap.ProxyURL(&url.URL{Scheme: "coap", Host: proxy})}
+	tests := []struct {
+		sleep     time.Duration
+		hits      int32
+		validated int32
+	}{
+		{hits: 1, validated: 0},
+		{hits: 1, validated: 0},
+		{sleep: 1100 * time.Millisecond, hits: 2, validated: 1},
+		{hits: 2, validated: 1},
+	}
+	for i, tt := range tests {
+		time.Sleep(tt.sleep)
+		req, err := coap.NewRequest(true, coap.GET, "coap://"+origin+"/temp", nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := resp.Status, coap.Content; got != want {
+			t.Errorf("case%d: status: %v != %v", i, got, want)
+		}
+		if got, want := string(resp.Payload), "hello /temp"; got != want {
+			t.Errorf("case%d: payload: %q != %q", i, got, want)
+		}
+		if got, want := atomic.LoadInt32(hits), tt.hits; got != want {
+			t.Errorf("case%d: origin hits: %d != %d", i, got, want)
+		}
+		if got, want := atomic.LoadInt32(validated), tt.validated; got != want {
+			t.Errorf("case%d: origin validated: %d != %d", i, got, want)
+		}
+	}
+}
+
+func TestProxyOptions(t *testing.T) {
+	origin, proxy, _, _, closer := ServeTestProxy(t)
+	defer closer()
+
+	host, port, _ := net.SplitHostPort(origin)
+	n, _ := strconv.ParseUint(port, 10, 16)
+	tests := []struct {
+		options coap.Options
+		status  coap.Code
+		payload string
+	}{
+		{
+			options: coap.Options{{ID: coap.ProxyURI, Value: "coap://" + origin + "/a"}},
+			status:  coap.Content,
+			payload: "hello /a",
+		},
+		{
+			options: coap.Options{{ID: coap.ProxyScheme, Value: "coap"}, {ID: coap.URIHost, Value: host}, {ID: coap.URIPort, Value: uint32(n)}, {ID: coap.URIPath, Value: "b"}},
+			status:  coap.Content,
+			payload: "hello /b",
+		},
+		{
+			options: coap.Options{{ID: coap.ProxyURI, Value: "http://" + origin + "/a"}},
+			status:  coap.ProxyingNotSupported,
+		},
+		{
+			options: coap.Options{{ID: coap.ProxyURI, Value: "/a"}},
+			status:  coap.BadRequest,
+		},
+		{
+			options: nil,
+			status:  coap.NotFound,
+		},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, coap.GET, "coap://"+proxy, nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		for _, o := range tt.options {
+			req.Options.Set(o.ID, o.Value)
+		}
+		resp, err := coap.DefaultClient.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := resp.Status, tt.status; got != want {
+			t.Errorf("case%d: status: %v != %v", i, got, want)
+		}
+		if tt.payload != "" && string(resp.Payload) != tt.payload {
+			t.Errorf("case%d: payload: %q != %q", i, resp.Payload, tt.payload)
+		}
+	}
+}
+
+// evictingCache 返回过期的缓存响应后即将其淘汰, 模拟重新验证期间缓存响应被淘汰
+type evictingCache struct {
+	*coap.LRUCache
+}
+
+func (c evictingCache) Get(key string) (*coap.CacheEntry, bool) {
+	e, ok := c.LRUCache.Get(key)
+	if ok && !e.Fresh() {
+		c.LRUCache.Remove(key)
+	}
+	return e, ok
+}
+
+func TestProxyRevalidateEvicted(t *testing.T) {
+	var hits, validated int32
+	etag := []byte("v1")
+	h := func(w coap.ResponseWriter, r *coap.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Options().Set(coap.ETag, etag)
+		w.Options().Set(coap.MaxAge, uint32(1))
+		if etags := r.Options.ETags(); len(etags) > 0 && bytes.Equal(etags[0], etag) {
+			atomic.AddInt32(&validated, 1)
+			w.WriteCode(coap.Valid)
+			return
+		}
+		w.Write([]byte("hello"))
+	}
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	go (&coap.Server{Handler: coap.HandlerFunc(h)}).Serve("coap", ln)
+
+	pln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer pln.Close()
+	go (&coap.Server{Handler: &coap.Proxy{Cache: evictingCache{coap.NewLRUCache(0, 0)}}}).Serve("coap", pln)
+
+	client := &coap.Client{Cache: coap.NoCache, Proxy: coap.ProxyURL(&url.URL{Scheme: "coap", Host: pln.LocalAddr().String()})}
+	tests := []struct {
+		sleep     time.Duration
+		hits      int32
+		validated int32
+	}{
+		{hits: 1, validated: 0},
+		// 以Valid确认后缓存响应已被淘汰, 代理重新请求完整的表示
+		{sleep: 1100 * time.Millisecond, hits: 3, validated: 1},
+	}
+	for i, tt := range tests {
+		time.Sleep(tt.sleep)
+		req, err := coap.NewRequest(true, coap.GET, "coap://"+ln.LocalAddr().String()+"/temp", nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if resp.Status != coap.Content || string(resp.Payload) != "hello" {
+			t.Errorf("case%d: response: %v %q != %v %q", i, resp.Status, resp.Payload, coap.Content, "hello")
+		}
+		if got, want := atomic.LoadInt32(&hits), tt.hits; got != want {
+			t.Errorf("case%d: origin hits: %d != %d", i, got, want)
+		}
+		if got, want := atomic.LoadInt32(&validated), tt.validated; got != want {
+			t.Errorf("case%d: origin validated: %d != %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package coap
+
+import "github.com/ironzhang/coap/internal/stack/base"
+
+// Q-Block选项编号(RFC 9177)
+const (
+	QBlock1 = base.QBlock1
+	QBlock2 = base.QBlock2
+)
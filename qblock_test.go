@@ -0,0 +1,73 @@
+package coap_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/ironzhang/coap"
+)
+
+func TestQBlock(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 300)
+	h := coap.HandlerFunc(func(w coap.ResponseWriter, r *coap.Request) {
+		switch r.URL.Path {
+		case "/upload":
+			if b, err := ioutil.ReadAll(r.Body); err != nil || r.Options.Get(coap.QBlock1) != nil || !bytes.Equal(b, data) {
+				w.WriteCode(coap.BadRequest)
+				return
+			}
+			w.WriteCode(coap.Changed)
+		case "/download":
+			w.Write(data)
+		}
+	})
+
+	tests := []struct {
+		qblock  bool // 服务端是否支持Q-Block
+		confirm bool
+	}{
+		{qblock: true, confirm: true},
+		{qblock: true, confirm: false},
+		{qblock: false, confirm: true},
+	}
+	for i, tt := range tests {
+		ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("case%d: listen packet: %v", i, err)
+		}
+		defer ln.Close()
+		go (&coap.Server{Handler: h, QBlock: tt.qblock}).Serve("coap", ln)
+
+		client := &coap.Client{QBlock: true}
+		urlstr := "coap://" + ln.LocalAddr().String()
+
+		req, err := coap.NewRequest(tt.confirm, coap.POST, urlstr+"/upload", data)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: upload: %v", i, err)
+		}
+		if got, want := resp.Status, coap.Changed; got != want {
+			t.Errorf("case%d: upload: status: %v != %v", i, got, want)
+		}
+
+		req, err = coap.NewRequest(tt.confirm, coap.GET, urlstr+"/download", nil)
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err = client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: download: %v", i, err)
+		}
+		if !bytes.Equal(resp.Payload, data) {
+			t.Errorf("case%d: download: payload length: %d != %d", i, len(resp.Payload), len(data))
+		}
+		if resp.Options.Get(coap.QBlock2) != nil {
+			t.Errorf("case%d: download: options: %v", i, resp.Options)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package coap
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net"
 	"net/url"
 	"strconv"
@@ -29,14 +31,58 @@ type Request struct {
 	// 消息负载
 	Payload []byte
 
+	// 请求负载的来源, 消息发送端使用, 不为nil时替代Payload.
+	// 超过块大小时以Block1块传输按需逐块读取, 长度已知时以Size1选项告知对端, 发送后若实现了io.Closer则被关闭.
+	// 消息接收端的Body总不为nil, 以Block1块传输收到的负载不再组装, 而由Body按块到达的顺序读取,
+	// 此时Payload为nil, 每块被读完后才确认对端以发送下一块. Handler返回后不应再读取Body.
+	Body io.Reader
+
 	// 远端地址, 消息接收端使用, 发送段不应该使用该字段
 	RemoteAddr net.Addr
 
-	// 请求超时时间, 消息发送端使用
+	// DTLS对端身份, 消息接收端使用, 非DTLS链接为nil
+	DTLS *DTLSState
+
+	// 请求超时时间, 消息发送端使用. 默认为传输参数的NON_LIFETIME, CON请求不短于EXCHANGE_LIFETIME
 	Timeout time.Duration
 
+	// 块传输的首选块大小(即SZX), 须为16至1024之间2的幂, 消息发送端使用.
+	// 为0时使用TransmissionParams.MaxBlockSize, 否则负载超过该大小时以Block1分块发送,
+	// GET请求以Block2选项告知服务端按该大小分块响应, 对端要求更小的块时改用对端的大小.
+	BlockSize uint32
+
 	// 若设置该字段，发送请求时使用Request中的Token字段，否则消息的token自动生成
 	useToken bool
+
+	// ServeMux匹配的路径参数
+	pathParams map[string]string
+
+	// 请求上下文, 发送端用于取消请求, 接收端在会话关闭时被取消
+	ctx context.Context
+}
+
+// Context 返回请求的上下文, 默认为context.Background().
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext 返回上下文为ctx的请求浅拷贝.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("coap: nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
+
+// PathParam 返回ServeMux路径模式中{name}对应的路径参数, 不存在时返回空字符串.
+func (r *Request) PathParam(name string) string {
+	return r.pathParams[name]
 }
 
 // NewRequest 构造COAP请求.
@@ -45,7 +91,9 @@ func NewRequest(confirmable bool, method Code, urlstr string, payload []byte) (*
 	if err != nil {
 		return nil, err
 	}
-	if u.Scheme != "coap" && u.Scheme != "coaps" {
+	switch u.Scheme {
+	case "coap", "coaps", "coap+tcp", "coaps+tcp", "coap+ws", "coaps+ws":
+	default:
 		return nil, errors.New("invalid scheme")
 	}
 	if u.Fragment != "" {
@@ -61,11 +109,7 @@ func NewRequest(confirmable bool, method Code, urlstr string, payload []byte) (*
 		options.Set(URIHost, host)
 	}
 	if port == 0 {
-		if u.Scheme == "coaps" {
-			u.Host += ":5684"
-		} else {
-			u.Host += ":5683"
-		}
+		u.Host += ":" + defaultPort(u.Scheme)
 	} else {
 		options.Set(URIPort, port)
 	}
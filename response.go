@@ -22,3 +22,12 @@ type Response struct {
 	// 远程地址，主动上报的响应(即由Observe接口处理的Response)，该字段才有意义
 	RemoteAddr net.Addr
 }
+
+// clone 返回响应的副本, 选项被复制, 负载与原响应共享
+func (r *Response) clone() *Response {
+	c := *r
+	if r.Options != nil {
+		c.Options = r.Options.clone()
+	}
+	return &c
+}
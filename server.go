@@ -1,12 +1,18 @@
 package coap
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
-	"log"
+	"io"
 	"net"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ironzhang/coap/internal/gctable"
+	"github.com/ironzhang/coap/internal/stack/base"
+	"github.com/pion/dtls/v2"
 )
 
 var ErrSessionNotFound = errors.New("session not found")
@@ -26,7 +32,78 @@ type Server struct {
 	ReadBytes  int      // 读缓冲大小
 	WriteBytes int      // 写缓冲大小
 
+	TransmissionParams *TransmissionParams // 传输参数, 为nil时使用协议默认值
+
+	// OSCORE 与各客户端的OSCORE安全上下文, 按kid及kid context匹配接收者.
+	// 不为空时只接受受OSCORE保护的请求, 其余请求以Unauthorized拒绝.
+	OSCORE []*OSCOREContext
+
+	// MaxHandlers 所有会话并发执行的Handler总数上限, 为0时不限制.
+	// MaxSessionHandlers 每个会话并发执行的Handler数上限.
+	// 两者均为0时, 每个会话按请求到达顺序依次执行Handler;
+	// 否则每个请求在独立的协程中执行, 超过上限的请求以ServiceUnavailable拒绝.
+	MaxHandlers        int
+	MaxSessionHandlers int
+
+	// BusyMaxAge 超过并发上限时ServiceUnavailable响应的Max-Age, 即建议的重试间隔(秒), 为0时为1
+	BusyMaxAge uint32
+
+	// MaxRequestBodySize 请求负载的最大长度, 超过时以RequestEntityTooLarge响应并以Size1选项告知该上限, 为0时不限制.
+	// 块传输的最大块大小由TransmissionParams.MaxBlockSize限定, 对端使用更大的块时被要求改用该大小.
+	MaxRequestBodySize uint32
+
+	// QBlock 在UDP及DTLS上支持Q-Block1/Q-Block2(RFC 9177)块传输, 为false时以BadOption拒绝Q-Block请求
+	QBlock bool
+
+	// Cache 缓存向客户端发出的请求的响应, 可由多个Client、Server共享;
+	// 为nil时每个会话使用默认容量的LRUCache, 设为NoCache时不缓存
+	Cache Cache
+
+	// CheckOrigin 检查WebSocket升级请求的Origin头部, 返回false时以Forbidden拒绝升级;
+	// 为nil时只接受不带Origin头部或与Host同源的请求, 允许跨源的浏览器客户端时须设置
+	CheckOrigin func(r *http.Request) bool
+
+	Logger Logger    // 日志接口, 为nil时以Info级别输出到log包的标准Logger
+	Trace  TraceMode // 消息跟踪模式
+
 	sessions gctable.Table
+
+	handlersOnce sync.Once
+	handlers     semaphore
+	stats        stats
+
+	mu         sync.Mutex
+	listeners  map[io.Closer]bool // 值表示是否可在Shutdown开始时立即关闭
+	inShutdown int32
+}
+
+func (s *Server) sessionConfig() sessionConfig {
+	s.handlersOnce.Do(func() { s.handlers = newSemaphore(s.MaxHandlers) })
+	return sessionConfig{
+		params:          s.TransmissionParams,
+		oscores:         s.OSCORE,
+		handlers:        s.handlers,
+		sessionHandlers: s.MaxSessionHandlers,
+		busyMaxAge:      s.BusyMaxAge,
+		maxBodySize:     s.MaxRequestBodySize,
+		qblock:          s.QBlock,
+		cache:           s.Cache,
+		logger:          s.Logger,
+		trace:           s.Trace,
+		stats:           &s.stats,
+	}
+}
+
+// Stats 返回Server的统计快照
+func (s *Server) Stats() Stats {
+	return s.stats.snapshot(s.sessions.Len())
+}
+
+func (s *Server) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return base.DefaultLogger
 }
 
 func (s *Server) listenUDP(address string) (net.PacketConn, error) {
@@ -62,15 +139,25 @@ func (s *Server) Serve(scheme string, l net.PacketConn) error {
 	if scheme == "" {
 		scheme = "coap"
 	}
-	if scheme != "coap" && scheme != "coaps" {
+	if scheme == "coaps" {
+		return errors.New("coaps scheme requires DTLS, use ServeDTLS")
+	}
+	if scheme != "coap" {
 		return errors.New("invalid scheme")
 	}
+	if !s.trackListener(l, false) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
 
 	buf := make([]byte, 1500)
 	for {
 		n, addr, err := l.ReadFrom(buf)
 		if err != nil {
-			log.Printf("listener(%s) read from: %v", l.LocalAddr(), err)
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			s.logger().Log(LevelError, "listener read from", "listener", l.LocalAddr(), "error", err)
 			if e, ok := err.(net.Error); ok {
 				if e.Temporary() || e.Timeout() {
 					time.Sleep(5 * time.Millisecond)
@@ -85,19 +172,153 @@ func (s *Server) Serve(scheme string, l net.PacketConn) error {
 	}
 }
 
+// ListenAndServeDTLS 在指定地址端口监听并提供基于DTLS的COAPS服务.
+func (s *Server) ListenAndServeDTLS(address string, config *dtls.Config) error {
+	ln, err := listenDTLS(address, config)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	return s.ServeDTLS(ln)
+}
+
+// ServeDTLS 提供COAPS服务, l必须是由dtls.Listen创建的监听器.
+func (s *Server) ServeDTLS(l net.Listener) error {
+	// DTLS链接复用监听器的UDP套接字, 需在会话结束后关闭
+	if !s.trackListener(l, false) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			s.logger().Log(LevelError, "listener accept", "listener", l.Addr(), "error", err)
+			if e, ok := err.(net.Error); ok {
+				if e.Temporary() || e.Timeout() {
+					time.Sleep(5 * time.Millisecond)
+					continue
+				}
+			}
+			return err
+		}
+		go s.serveDTLSConn(conn)
+	}
+}
+
+func (s *Server) serveDTLSConn(conn net.Conn) {
+	defer func() {
+		if sess, ok := s.getSession(conn.RemoteAddr()); ok {
+			s.sessions.Remove(sess.Key())
+			sess.Close()
+		}
+		conn.Close()
+	}()
+
+	state := newDTLSState(conn)
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				s.logger().Log(LevelWarn, "dtls conn read", "peer", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf)
+		s.addDTLSSession(conn, state).recvData(data)
+	}
+}
+
+// ListenAndServeTCP 在指定地址端口监听并提供基于TCP的COAP服务(coap+tcp).
+func (s *Server) ListenAndServeTCP(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	return s.ServeTCP(ln)
+}
+
+// ListenAndServeTLS 在指定地址端口监听并提供基于TLS的COAP服务(coaps+tcp).
+func (s *Server) ListenAndServeTLS(address string, config *tls.Config) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	return s.ServeTLS(ln, config)
+}
+
+// ServeTCP 在TCP监听器上提供COAP服务(coap+tcp).
+func (s *Server) ServeTCP(l net.Listener) error {
+	return s.serveStream("coap+tcp", l)
+}
+
+// ServeTLS 在TCP监听器上提供基于TLS的COAP服务(coaps+tcp).
+func (s *Server) ServeTLS(l net.Listener, config *tls.Config) error {
+	if config == nil {
+		return errors.New("coap: nil tls.Config")
+	}
+	return s.serveStream("coaps+tcp", tls.NewListener(l, config))
+}
+
+func (s *Server) serveStream(scheme string, l net.Listener) error {
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			s.logger().Log(LevelError, "listener accept", "listener", l.Addr(), "error", err)
+			if e, ok := err.(net.Error); ok {
+				if e.Temporary() || e.Timeout() {
+					time.Sleep(5 * time.Millisecond)
+					continue
+				}
+			}
+			return err
+		}
+		go s.serveStreamConn(scheme, newStreamConn(conn))
+	}
+}
+
+func (s *Server) serveStreamConn(scheme string, conn *streamConn) {
+	sess := s.addStreamSession(scheme, conn)
+	defer func() {
+		s.sessions.Remove(sess.Key())
+		sess.Close()
+		conn.Close()
+	}()
+
+	if err := conn.serve(sess); err != nil {
+		sess.logger().Log(LevelWarn, "conn serve", "error", err)
+	}
+}
+
 // SendRequest 发送COAP请求.
 func (s *Server) SendRequest(req *Request) (*Response, error) {
-	addr, err := net.ResolveUDPAddr("udp", req.URL.Host)
+	sess, err := s.findSession(req)
 	if err != nil {
 		return nil, err
 	}
-	sess, ok := s.getSession(addr)
-	if !ok {
-		return nil, ErrSessionNotFound
-	}
 	return sess.postRequestWithCache(req)
 }
 
+// Do 向已建立会话的客户端发送COAP请求, ctx取消时停止重传并返回ctx.Err()
+func (s *Server) Do(ctx context.Context, req *Request) (*Response, error) {
+	return s.SendRequest(req.WithContext(ctx))
+}
+
 // Observe 订阅.
 //
 // token长度不能大于8个字节, 且需要保证token永不重复.
@@ -127,8 +348,42 @@ func (s *Server) CancelObserve(urlstr string, accept uint32) (*Response, error)
 	return s.postRequestAndWaitResponse(req)
 }
 
+// Notify 通知资源path的所有观察者, 通知内容由Handler重新处理登记时的请求生成.
+//
+// 观察者由携带Observe=0选项的GET请求登记, 收到RST或可靠通知超时后注销. 已结束的会话被忽略.
+func (s *Server) Notify(path string) {
+	s.sessions.Range(func(o gctable.Object) bool {
+		o.(*session).notify(path)
+		return true
+	})
+}
+
+// NotifyPayload 以给定的响应码, 选项及负载通知资源path的所有观察者.
+// confirmable为false时, 距上次确认超过24小时的观察者仍以CON通知, 以便注销失效的观察者(RFC 7641 4.5).
+func (s *Server) NotifyPayload(path string, status Code, options Options, payload []byte, confirmable bool) {
+	s.sessions.Range(func(o gctable.Object) bool {
+		o.(*session).notifyPayload(path, status, options, payload, confirmable)
+		return true
+	})
+}
+
 func (s *Server) postRequestAndWaitResponse(req *Request) (*Response, error) {
-	addr, err := net.ResolveUDPAddr("udp", req.URL.Host)
+	sess, err := s.findSession(req)
+	if err != nil {
+		return nil, err
+	}
+	return sess.postRequestAndWaitResponse(req)
+}
+
+// findSession 查找请求目的地址对应的会话
+func (s *Server) findSession(req *Request) (*session, error) {
+	var addr net.Addr
+	var err error
+	if isStreamScheme(req.URL.Scheme) {
+		addr, err = net.ResolveTCPAddr("tcp", req.URL.Host)
+	} else {
+		addr, err = net.ResolveUDPAddr("udp", req.URL.Host)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -136,18 +391,39 @@ func (s *Server) postRequestAndWaitResponse(req *Request) (*Response, error) {
 	if !ok {
 		return nil, ErrSessionNotFound
 	}
-	return sess.postRequestAndWaitResponse(req)
+	return sess, nil
 }
 
 func (s *Server) addSession(scheme string, conn net.PacketConn, addr net.Addr) *session {
-	obj := s.sessions.Add(addr.String(), func() gctable.Object {
-		return newSession(&serverConn{conn: conn, addr: addr}, s.Handler, s.Observer, conn.LocalAddr(), addr, scheme)
+	obj := s.sessions.Add(sessionKey(addr), func() gctable.Object {
+		sess := newSession(&serverConn{conn: conn, addr: addr}, s.Handler, s.Observer, conn.LocalAddr(), addr, scheme, s.sessionConfig())
+		sess.server = s
+		return sess
+	})
+	return obj.(*session)
+}
+
+func (s *Server) addDTLSSession(conn net.Conn, state *DTLSState) *session {
+	obj := s.sessions.Add(sessionKey(conn.RemoteAddr()), func() gctable.Object {
+		sess := newSession(conn, s.Handler, s.Observer, conn.LocalAddr(), conn.RemoteAddr(), "coaps", s.sessionConfig())
+		sess.dtls = state
+		sess.server = s
+		return sess
+	})
+	return obj.(*session)
+}
+
+func (s *Server) addStreamSession(scheme string, conn *streamConn) *session {
+	obj := s.sessions.Add(sessionKey(conn.RemoteAddr()), func() gctable.Object {
+		sess := newSession(conn, s.Handler, s.Observer, conn.LocalAddr(), conn.RemoteAddr(), scheme, s.sessionConfig())
+		sess.server = s
+		return sess
 	})
 	return obj.(*session)
 }
 
 func (s *Server) getSession(addr net.Addr) (*session, bool) {
-	if obj, ok := s.sessions.Get(addr.String()); ok {
+	if obj, ok := s.sessions.Get(sessionKey(addr)); ok {
 		return obj.(*session), true
 	}
 	return nil, false
@@ -2,31 +2,44 @@ package coap
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	mrand "math/rand"
 	"net"
 	"net/url"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ironzhang/coap/internal/stack"
 	"github.com/ironzhang/coap/internal/stack/base"
+	"github.com/ironzhang/coap/internal/stack/qblock"
 )
 
 var (
-	Verbose     = 1
+	// Verbose 为1或2时, 未设置Trace的Client及Server分别以TraceBrief或TraceFull跟踪消息.
+	//
+	// Deprecated: 以Client.Trace及Server.Trace设置消息跟踪模式.
+	Verbose = 0
+
+	// EnableCache 为false时, 未设置Cache的Client及Server不缓存响应.
+	//
+	// Deprecated: 以Client.Cache及Server.Cache配置缓存, 设为NoCache时不缓存.
 	EnableCache = true
 )
 
 var (
 	ErrReset   = errors.New("wait response reset by peer")
 	ErrTimeout = errors.New("wait response timeout")
+
+	ErrSessionClosed = errors.New("session closed")
+
+	ErrPingNotSupported = errors.New("ping requires a reliable transport")
 	//ErrAckTimeout = errors.New("wait ack timeout")
 )
 
@@ -41,7 +54,7 @@ func (f HandlerFunc) ServeCOAP(w ResponseWriter, r *Request) {
 	f(w, r)
 }
 
-// Observer 观察者接口
+// Observer 观察者接口, 在会话独立的协程中依次调用, 处理过慢时同一观察关系只保留最新的通知
 type Observer interface {
 	ServeObserve(*Response)
 }
@@ -75,10 +88,15 @@ type response struct {
 	buffer      bytes.Buffer
 	acked       bool
 	needAck     bool
+	body        io.Reader
+	bodySize    int64
+	upload      *blockUpload // 流式接收的请求负载
 }
 
+// Ack 只回复一次空ACK, 重复调用被忽略.
+// 流式接收请求负载时也被忽略, 响应总是附带在块的ACK中
 func (r *response) Ack(code Code) {
-	if r.needAck {
+	if r.needAck && !r.acked && r.upload == nil {
 		r.acked = true
 		m := base.Message{
 			Type:      base.ACK,
@@ -105,6 +123,26 @@ func (r *response) Write(p []byte) (int, error) {
 	return r.buffer.Write(p)
 }
 
+func (r *response) SetBody(body io.Reader, size int64) {
+	r.buffer.Reset()
+	r.body = body
+	r.bodySize = size
+}
+
+// readBody 将SetBody设置的负载全部读入缓冲, 用于不支持块传输的通知
+func (r *response) readBody() {
+	if r.body == nil {
+		return
+	}
+	if c, ok := r.body.(io.Closer); ok {
+		defer c.Close()
+	}
+	if _, err := r.buffer.ReadFrom(r.body); err != nil {
+		r.session.logger().Log(LevelWarn, "read body", "token", base.TokenString(r.token), "error", err)
+	}
+	r.body = nil
+}
+
 type session struct {
 	writer     io.Writer
 	handler    Handler
@@ -114,32 +152,108 @@ type session struct {
 	scheme     string
 	host       string
 	port       uint32
+	dtls       *DTLSState
+	stream     bool
+	qblock     bool
+	streamBody bool    // 是否流式接收Block1请求负载
+	server     *Server // 服务端会话所属的Server, 客户端会话为nil
+	inflight   int32   // 处理中的请求数
+	params     base.Params
+	blockSizeV uint32 // 发送负载的最大块大小, 可靠传输时受对端的最大消息长度限制
+
+	concurrent      bool      // 是否在独立协程中并发执行Handler
+	handlers        semaphore // 所属Server的Handler并发数限制
+	sessionHandlers semaphore // 本会话的Handler并发数限制
+	busyMaxAge      uint32
+
+	trace   TraceMode
+	loggerv atomic.Value // 值类型为loggerValue
+	stats   *stats
 
 	lastRecvMutex sync.RWMutex
 	lastRecvTime  time.Time
 	cache         cache
+	observations  observations
+	notifications notifications
+	streams       blockStreams
 
-	donec    chan struct{}
-	servingc chan func()
-	runningc chan func()
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	donec     chan struct{}
+	servingc  chan func()
+	runningc  chan func()
 
 	// 以下字段只能在running协程中访问
 	seq         uint16
 	stack       stack.Stack
 	respWaiters map[string]*responseWaiter
+	uploads     map[string]*blockUpload
+}
+
+// sessionConfig 会话配置, 由Client或Server提供
+type sessionConfig struct {
+	params  *TransmissionParams
+	oscore  *OSCOREContext   // 保护发出的请求
+	oscores []*OSCOREContext // 解除保护收到的请求
+
+	handlers        semaphore // 所有会话共享的Handler并发数限制
+	sessionHandlers int       // 每个会话的Handler并发数上限
+	busyMaxAge      uint32
+	maxBodySize     uint32 // 请求负载的最大长度
+	qblock          bool   // 是否启用Q-Block块传输
+	cache           Cache  // 为nil时每个会话使用默认容量的LRUCache
+
+	logger Logger // 为nil时以Info级别输出到log包的标准Logger
+	trace  TraceMode
+	stats  *stats
+}
+
+// loggerValue 保存在atomic.Value中的Logger
+type loggerValue struct {
+	Logger
+}
+
+// sessionLogger 返回附加了对端地址的Logger
+func (s *session) sessionLogger(l Logger) Logger {
+	return base.With(l, "scheme", s.scheme, "peer", s.remoteAddr.String())
+}
+
+func (s *session) logger() Logger {
+	return s.loggerv.Load().(loggerValue).Logger
+}
+
+// setLogger 设置会话及协议栈使用的Logger
+func (s *session) setLogger(l Logger) {
+	l = s.sessionLogger(l)
+	s.loggerv.Store(loggerValue{l})
+	select {
+	case s.runningc <- func() { s.stack.SetLogger(l) }:
+	case <-s.donec:
+	}
 }
 
-func newSession(w io.Writer, h Handler, o Observer, la, ra net.Addr, scheme string) *session {
-	return new(session).init(w, h, o, la, ra, scheme)
+func newSession(w io.Writer, h Handler, o Observer, la, ra net.Addr, scheme string, cfg sessionConfig) *session {
+	return new(session).init(w, h, o, la, ra, scheme, cfg)
 }
 
-func (s *session) init(w io.Writer, h Handler, o Observer, la, ra net.Addr, scheme string) *session {
+func (s *session) init(w io.Writer, h Handler, o Observer, la, ra net.Addr, scheme string, cfg sessionConfig) *session {
 	s.writer = w
 	s.handler = h
 	s.observer = o
 	s.localAddr = la
 	s.remoteAddr = ra
 	s.scheme = scheme
+	s.stream = isStreamScheme(scheme)
+	s.params = cfg.params.base()
+	s.blockSizeV = s.params.MaxBlockSize
+	s.concurrent = cfg.handlers != nil || cfg.sessionHandlers > 0
+	s.handlers = cfg.handlers
+	s.sessionHandlers = newSemaphore(cfg.sessionHandlers)
+	s.busyMaxAge = cfg.busyMaxAge
+	if s.busyMaxAge == 0 {
+		s.busyMaxAge = defaultBusyMaxAge
+	}
 	host, port, err := net.SplitHostPort(la.String())
 	if err == nil {
 		s.host = host
@@ -148,16 +262,49 @@ func (s *session) init(w io.Writer, h Handler, o Observer, la, ra net.Addr, sche
 		}
 	}
 
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 	s.donec = make(chan struct{})
 	s.servingc = make(chan func(), 8)
 	s.runningc = make(chan func(), 8)
+	s.notifications.readyc = make(chan struct{}, 1)
 
 	s.seq = uint16(mrand.Uint32() % math.MaxUint16)
-	s.stack.Init(s, s, s.genMessageID)
+	var top []base.Layer
+	if cfg.qblock && !s.stream {
+		s.qblock = true
+		top = append(top, qblock.NewLayerWithParams(s.genMessageID, s.params))
+	}
+	// OSCORE须收齐外层块传输的全部负载才能解除保护, 此时不流式接收请求负载
+	s.streamBody = true
+	if l := oscoreLayer(cfg.oscore, cfg.oscores, s.genMessageID); l != nil {
+		top = append(top, l)
+		s.streamBody = false
+	}
+	if s.stream {
+		s.stack.InitStream(s, s, s.genMessageID, s.params, top...)
+	} else {
+		s.stack.Init(s, s, s.genMessageID, s.params, top...)
+	}
 	s.respWaiters = make(map[string]*responseWaiter)
+	s.uploads = make(map[string]*blockUpload)
+	s.cache = cache{cfg.cache}
+	if cfg.cache == nil && EnableCache {
+		s.cache = cache{NewLRUCache(DefaultCacheEntries, DefaultCacheBytes)}
+	}
+	s.trace = cfg.trace
+	if s.trace == TraceOff {
+		s.trace = verboseTrace()
+	}
+	s.loggerv.Store(loggerValue{s.sessionLogger(cfg.logger)})
+	s.stack.SetLogger(s.logger())
+	s.stats = cfg.stats
+	s.stack.SetCounters(s.stats.stackCounters())
+	s.stack.SetMaxBodySize(cfg.maxBodySize)
+	s.stack.SetStreamBody(s.streamBody)
 
-	go s.serving() // 调用上层回调接口协程
-	go s.running() // 主逻辑协程
+	go s.serving()   // 调用上层回调接口协程
+	go s.observing() // 调用上层观察者接口协程
+	go s.running()   // 主逻辑协程
 
 	return s
 }
@@ -168,13 +315,32 @@ func (s *session) serving() {
 	}
 }
 
+func (s *session) observing() {
+	for {
+		select {
+		case <-s.donec:
+			return
+		case <-s.notifications.readyc:
+		}
+		for {
+			resp, ok := s.notifications.pop()
+			if !ok {
+				break
+			}
+			s.observer.ServeObserve(resp)
+		}
+	}
+}
+
 func (s *session) running() {
-	t := time.NewTicker(base.ACK_TIMEOUT / 2)
+	t := time.NewTicker(s.params.AckTimeout / 2)
 	defer t.Stop()
 	for {
 		select {
 		case <-s.donec:
 			close(s.servingc)
+			s.streams.closeAll()
+			s.closeUploads()
 			return
 		case f := <-s.runningc:
 			f()
@@ -186,6 +352,8 @@ func (s *session) running() {
 
 func (s *session) update() {
 	s.stack.Update()
+	s.streams.update(s.params.ExchangeLifetime())
+	s.updateUploads(s.params.ExchangeLifetime())
 	for k, w := range s.respWaiters {
 		if w.Timeout() {
 			delete(s.respWaiters, k)
@@ -195,57 +363,110 @@ func (s *session) update() {
 }
 
 func (s *session) Key() string {
-	return s.remoteAddr.String()
+	return sessionKey(s.remoteAddr)
+}
+
+// sessionKey 以网络类型及地址区分会话, 同一地址的UDP及TCP会话互不影响
+func sessionKey(addr net.Addr) string {
+	return addr.Network() + "://" + addr.String()
 }
 
+// CanGC 长时间未收到消息且没有观察者的会话可被回收, 失效的观察者由定期的CON通知超时注销
 func (s *session) CanGC() bool {
-	return s.lastRecvTimeExpired()
+	return s.observations.len() == 0 && s.lastRecvTimeExpired()
 }
 
+// ExecuteGC 回收会话, 并关闭会话独占的链接以结束其读取协程
 func (s *session) ExecuteGC() {
-	s.Close()
+	s.closeConn()
 }
 
 func (s *session) Close() error {
-	close(s.donec)
+	s.closeOnce.Do(func() {
+		s.cancel()
+		close(s.donec)
+	})
 	return nil
 }
 
+// idle 判断会话是否没有处理中的请求及进行中的消息交互, 已关闭的会话总是空闲的
+func (s *session) idle() bool {
+	if atomic.LoadInt32(&s.inflight) > 0 {
+		return false
+	}
+	c := make(chan bool, 1)
+	select {
+	case s.runningc <- func() { c <- s.stack.Idle() && atomic.LoadInt32(&s.inflight) == 0 }:
+	case <-s.donec:
+		return true
+	}
+	select {
+	case v := <-c:
+		return v
+	case <-s.donec:
+		return true
+	}
+}
+
+// closeConn 关闭会话及会话独占的链接, 如DTLS及可靠传输链接
+func (s *session) closeConn() {
+	s.Close()
+	if c, ok := s.writer.(io.Closer); ok {
+		c.Close()
+	}
+}
+
 func (s *session) OnAckTimeout(m base.Message) {
 	if len(m.Token) > 0 {
 		s.finishResponseWait(m, ErrTimeout)
+		if s.observations.del(m.Token) {
+			s.stack.Cancel(m.Token)
+		}
 	}
 }
 
+// recvData 接收数据报, 会话已结束时丢弃, 不阻塞读取协程
 func (s *session) recvData(data []byte) {
 	s.lastRecvTimeUpdate()
-	s.runningc <- func() {
+	s.post(func() {
 		var m base.Message
 		err := m.Unmarshal(data)
 		if err != nil {
-			log.Printf("message unmarshal: %v", err)
+			s.logger().Log(LevelWarn, "message unmarshal", "error", err)
 			handleError(s, m, err)
 			return
 		}
 		s.recvMessage(m)
-	}
+	})
+}
+
+// recvStreamMessage 接收可靠传输链接上已解码的消息
+func (s *session) recvStreamMessage(m base.Message, err error) {
+	s.lastRecvTimeUpdate()
+	s.post(func() {
+		if err != nil {
+			s.logger().Log(LevelWarn, "message unmarshal", "error", err)
+			handleError(s, m, err)
+			return
+		}
+		s.recvMessage(m)
+	})
 }
 
 func (s *session) recvMessage(m base.Message) {
-	if Verbose == 2 {
-		var mser base.MessageStringer
-		log.Printf("recv: %s", mser.MessageString(m))
-		//log.Printf("recv: %s\n", m.String())
+	s.stats.recv(m)
+	if s.trace >= TraceFull {
+		s.logger().Log(LevelInfo, "recv", "message", traceString(s.trace, m))
 	}
 
 	if err := s.stack.Recv(m); err != nil {
-		log.Printf("stack recv: %v", err)
+		s.logger().Log(LevelWarn, "stack recv", "error", err)
 	}
 }
 
 func (s *session) Recv(m base.Message) error {
-	if Verbose == 1 {
-		log.Printf("recv: %s\n", m.String())
+	if s.trace == TraceBrief {
+		s.logger().Log(LevelInfo, "recv", "message", m.String())
 	}
 
 	switch m.Type {
@@ -282,25 +503,49 @@ func (s *session) handleMSG(m base.Message) {
 
 func (s *session) handleRequest(m base.Message) {
 	if s.handler == nil {
-		log.Printf("handler is nil")
+		s.logger().Log(LevelWarn, "handler is nil, send rst", "token", base.TokenString(m.Token))
 		if err := s.sendRST(m.MessageID); err != nil {
-			log.Printf("send rst: %v", err)
+			s.logger().Log(LevelWarn, "send rst", "error", err)
 		}
 		return
 	}
 
+	// 未启用Q-Block时, 按不认识的关键选项拒绝Q-Block请求
+	if !s.qblock && (m.GetOption(base.QBlock1) != nil || m.GetOption(base.QBlock2) != nil) {
+		badOptionsErrorHandler.handle(s, m, nil)
+		return
+	}
+
 	// 将选项编码成URL
 	url, err := s.parseURLFromOptions(m.Options)
 	if err != nil {
-		log.Printf("parse url from options: %v", err)
+		s.logger().Log(LevelWarn, "parse url from options", "token", base.TokenString(m.Token), "error", err)
 		if err := s.sendRST(m.MessageID); err != nil {
-			log.Printf("send rst: %v", err)
+			s.logger().Log(LevelWarn, "send rst", "error", err)
 		}
 		return
 	}
 
-	// 由serving协程调用上层handler处理请求
-	s.servingc <- func() {
+	// 未定义的请求方法以MethodNotAllowed响应(RFC 7252 5.8)
+	if !base.IsMethod(m.Code) {
+		s.rejectRequest(m, MethodNotAllowed)
+		return
+	}
+
+	// 流式接收的Block1请求, 后续块交给进行中的请求
+	if opt, ok := base.ParseBlock1Option(m); ok && s.streamBody && m.Type == base.CON && opt.Num > 0 {
+		s.recvUpload(m, opt)
+		return
+	}
+
+	// 服务关闭中, 拒绝新的请求
+	if s.server != nil && s.server.shuttingDown() {
+		s.rejectRequest(m, ServiceUnavailable)
+		return
+	}
+
+	upload := s.newUpload(m)
+	serve := func() {
 		req := &Request{
 			Confirmable: m.Type == base.CON,
 			Method:      Code(m.Code),
@@ -309,6 +554,8 @@ func (s *session) handleRequest(m base.Message) {
 			Token:       Token(m.Token),
 			Payload:     m.Payload,
 			RemoteAddr:  s.remoteAddr,
+			DTLS:        s.dtls,
+			ctx:         s.ctx,
 		}
 		resp := &response{
 			session:     s,
@@ -318,9 +565,86 @@ func (s *session) handleRequest(m base.Message) {
 			code:        Content,
 			needAck:     req.Confirmable,
 		}
-		s.handler.ServeCOAP(resp, req)
+		if upload != nil {
+			req.Payload = nil
+			req.Body = upload
+			resp.upload = upload
+		} else {
+			req.Body = bytes.NewReader(m.Payload)
+		}
+		if !s.serveBlock(req, resp) {
+			start := time.Now()
+			s.handler.ServeCOAP(resp, req)
+			s.stats.handled(start)
+			s.updateObservation(req, resp)
+			s.writeBody(req, resp)
+		}
 		s.postResponse(resp)
 	}
+
+	if !s.concurrent {
+		// 由serving协程调用上层handler处理请求
+		atomic.AddInt32(&s.inflight, 1)
+		if len(s.uploads) == 0 {
+			s.servingc <- serve
+		} else {
+			// serving协程可能正等待running协程交付的块, 队列已满时拒绝请求以免相互等待
+			select {
+			case s.servingc <- serve:
+			default:
+				atomic.AddInt32(&s.inflight, -1)
+				s.rejectBusy(m)
+				return
+			}
+		}
+		s.startUpload(upload, m)
+		return
+	}
+
+	// 超过并发上限, 拒绝请求而不阻塞running协程
+	if !s.acquireHandler() {
+		s.rejectBusy(m)
+		return
+	}
+	atomic.AddInt32(&s.inflight, 1)
+	s.startUpload(upload, m)
+	go func() {
+		defer s.releaseHandler()
+		serve()
+	}()
+}
+
+// rejectBusy 以ServiceUnavailable拒绝请求, 由Max-Age告知对端重试前等待的时间
+func (s *session) rejectBusy(m base.Message) {
+	resp := &response{
+		session:     s,
+		confirmable: m.Type == base.CON,
+		messageID:   m.MessageID,
+		token:       m.Token,
+		code:        ServiceUnavailable,
+		options:     Options{{ID: MaxAge, Value: s.busyMaxAge}},
+		needAck:     m.Type == base.CON,
+	}
+	if err := s.sendResponse(resp); err != nil {
+		s.logger().Log(LevelWarn, "send response", "token", base.TokenString(resp.token), "error", err)
+	}
+}
+
+// acquireHandler 获取执行Handler的许可, 超过会话或Server的并发上限时返回false
+func (s *session) acquireHandler() bool {
+	if !s.sessionHandlers.tryAcquire() {
+		return false
+	}
+	if !s.handlers.tryAcquire() {
+		s.sessionHandlers.release()
+		return false
+	}
+	return true
+}
+
+func (s *session) releaseHandler() {
+	s.handlers.release()
+	s.sessionHandlers.release()
 }
 
 func (s *session) handleResponse(m base.Message) {
@@ -331,42 +655,48 @@ func (s *session) handleResponse(m base.Message) {
 	options := Options(m.Options)
 	if options.Contain(Observe) {
 		if s.observer == nil {
-			log.Printf("observer is nil")
+			s.logger().Log(LevelWarn, "observer is nil, send rst", "token", base.TokenString(m.Token))
 			if err := s.sendRST(m.MessageID); err != nil {
-				log.Printf("send rst: %v", err)
+				s.logger().Log(LevelWarn, "send rst", "error", err)
 			}
+			s.stack.Cancel(m.Token)
 			return
 		}
 
-		// 由serving协程调用上层观察者接口处理订阅响应
-		s.servingc <- func() {
-			resp := &Response{
-				Ack:        m.Type == base.ACK,
-				Status:     Code(m.Code),
-				Options:    m.Options,
-				Token:      Token(m.Token),
-				Payload:    m.Payload,
-				RemoteAddr: s.remoteAddr,
-			}
-			s.observer.ServeObserve(resp)
-		}
+		s.postObserve(m)
 	}
 
 	// 回复ACK
 	if m.Type == base.CON {
 		if err := s.sendACK(m.MessageID); err != nil {
-			log.Printf("send ack: %v", err)
+			s.logger().Log(LevelWarn, "send ack", "error", err)
 		}
 	}
 }
 
+// postObserve 交由observing协程调用上层观察者接口处理订阅响应, 不阻塞running协程.
+// 观察者处理过慢时, 同一观察关系尚未处理的通知被合并, 只保留最新的状态(RFC 7641 3.3.1)
+func (s *session) postObserve(m base.Message) {
+	resp := &Response{
+		Ack:        m.Type == base.ACK,
+		Status:     Code(m.Code),
+		Options:    m.Options,
+		Token:      Token(m.Token),
+		Payload:    m.Payload,
+		RemoteAddr: s.remoteAddr,
+	}
+	if s.notifications.push(resp) {
+		s.logger().Log(LevelDebug, "observer busy, merge notification", "token", base.TokenString(m.Token))
+	}
+}
+
 func (s *session) handleReservedCode(m base.Message) {
-	log.Printf("reserved code: %s", base.CodeName(m.Code))
+	s.logger().Log(LevelWarn, "reserved code", "code", base.CodeName(m.Code))
 
 	// 回复RST
 	if m.Type == base.CON {
 		if err := s.sendRST(m.MessageID); err != nil {
-			log.Printf("send rst: %v", err)
+			s.logger().Log(LevelWarn, "send rst", "error", err)
 		}
 	}
 }
@@ -381,27 +711,22 @@ func (s *session) handleACK(m base.Message) {
 		options := Options(m.Options)
 		if options.Contain(Observe) {
 			if s.observer == nil {
-				log.Printf("observer is nil")
+				s.logger().Log(LevelWarn, "observer is nil", "token", base.TokenString(m.Token))
 				return
 			}
 
-			// 由serving协程调用上层观察者接口处理订阅响应
-			s.servingc <- func() {
-				resp := &Response{
-					Ack:        m.Type == base.ACK,
-					Status:     Code(m.Code),
-					Options:    m.Options,
-					Token:      Token(m.Token),
-					Payload:    m.Payload,
-					RemoteAddr: s.remoteAddr,
-				}
-				s.observer.ServeObserve(resp)
-			}
+			s.postObserve(m)
 		}
 	}
 }
 
 func (s *session) handleRST(m base.Message) {
+	// 观察者拒绝通知, 注销观察者
+	if token, ok := s.observations.delByMessageID(m.MessageID); ok {
+		s.stack.Cancel(token)
+		return
+	}
+
 	for k, w := range s.respWaiters {
 		if w.messageID == m.MessageID {
 			delete(s.respWaiters, k)
@@ -412,12 +737,11 @@ func (s *session) handleRST(m base.Message) {
 }
 
 func (s *session) Send(m base.Message) error {
-	if Verbose == 2 {
-		var mser base.MessageStringer
-		log.Printf("send: %s", mser.MessageString(m))
-		//log.Printf("send: %s\n", m.String())
+	s.stats.send(m)
+	if s.trace >= TraceFull {
+		s.logger().Log(LevelInfo, "send", "message", traceString(s.trace, m))
 	}
-	data, err := m.Marshal()
+	data, err := s.marshal(m)
 	if err != nil {
 		return err
 	}
@@ -425,6 +749,17 @@ func (s *session) Send(m base.Message) error {
 	return err
 }
 
+func (s *session) marshal(m base.Message) ([]byte, error) {
+	switch {
+	case isWebSocketScheme(s.scheme):
+		return m.MarshalWS()
+	case s.stream:
+		return m.MarshalTCP()
+	default:
+		return m.Marshal()
+	}
+}
+
 func randomInt64(min, max int64) int64 {
 	n := max - min
 	if n <= 0 {
@@ -444,7 +779,7 @@ func randomDuration() time.Duration {
 func (s *session) postMessage(m base.Message) {
 	fn := func() {
 		if err := s.sendMessage(m); err != nil {
-			log.Printf("send message: %v", err)
+			s.logger().Log(LevelWarn, "send message", "message", m, "error", err)
 		}
 	}
 
@@ -456,16 +791,17 @@ func (s *session) postMessage(m base.Message) {
 }
 
 func (s *session) sendMessage(m base.Message) error {
-	if Verbose == 1 {
-		log.Printf("send: %s\n", m.String())
+	if s.trace == TraceBrief {
+		s.logger().Log(LevelInfo, "send", "message", m.String())
 	}
 	return s.stack.Send(m)
 }
 
 func (s *session) postResponse(r *response) {
 	fn := func() {
+		defer atomic.AddInt32(&s.inflight, -1)
 		if err := s.sendResponse(r); err != nil {
-			log.Printf("send response: %v", err)
+			s.logger().Log(LevelWarn, "send response", "token", base.TokenString(r.token), "error", err)
 		}
 	}
 
@@ -477,6 +813,22 @@ func (s *session) postResponse(r *response) {
 }
 
 func (s *session) sendResponse(r *response) error {
+	if r.upload != nil && !s.finishUpload(r) {
+		return nil
+	}
+	if s.stream {
+		// 可靠传输没有单独响应, 响应总是关联到请求的消息ID
+		m := base.Message{
+			Type:      base.ACK,
+			Code:      uint8(r.code),
+			MessageID: r.messageID,
+			Token:     r.token,
+			Options:   r.options,
+			Payload:   r.buffer.Bytes(),
+		}
+		return s.sendMessage(m)
+	}
+
 	if !r.needAck {
 		// 非可靠请求的响应
 		m := base.Message{
@@ -525,37 +877,137 @@ func (s *session) sendResponse(r *response) error {
 	return nil
 }
 
+// rejectRequest 不经Handler以code响应请求
+func (s *session) rejectRequest(m base.Message, code Code) {
+	resp := &response{
+		session:     s,
+		confirmable: m.Type == base.CON,
+		messageID:   m.MessageID,
+		token:       m.Token,
+		code:        code,
+		needAck:     m.Type == base.CON,
+	}
+	if err := s.sendResponse(resp); err != nil {
+		s.logger().Log(LevelWarn, "send response", "token", base.TokenString(resp.token), "error", err)
+	}
+}
+
 func (s *session) postRequestWithCache(req *Request) (*Response, error) {
-	if !EnableCache {
-		return s.postRequestAndWaitResponse(req)
+	if req.Body != nil || s.cache.Cache == nil {
+		return s.postRequest(req)
 	}
-	if resp, ok := s.cache.Get(req); ok {
+	if !isSafeMethod(req.Method) {
+		resp, err := s.postRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		s.cache.invalidate(req, resp)
 		return resp, nil
 	}
-	resp, err := s.postRequestAndWaitResponse(req)
+
+	cached, ok := s.cache.lookup(req)
+	s.stats.cache(ok && cached.Fresh())
+	if ok && cached.Fresh() {
+		// 缓存可由多个会话共享, 返回副本以免调用者修改缓存的响应
+		return cached.Response.clone(), nil
+	}
+
+	// 以缓存响应的ETag重新验证已过期的响应, 2.03 Valid时沿用缓存的负载
+	out := req
+	if ok {
+		out = validationRequest(req, cached.Response)
+	}
+	resp, err := s.postRequest(out)
 	if err != nil {
 		return nil, err
 	}
+	if ok && resp.Status == Valid {
+		if merged, ok := s.cache.revalidate(req, resp); ok {
+			return merged, nil
+		}
+		if resp, err = s.postRequest(req); err != nil {
+			return nil, err
+		}
+	}
 	s.cache.Add(req, resp)
 	return resp, nil
 }
 
-func (s *session) postRequestAndWaitResponse(r *Request) (*Response, error) {
-	w := newResponseWaiter()
+// responseTimeout 返回等待响应的超时时间, 默认为传输参数的NON_LIFETIME, CON请求不短于EXCHANGE_LIFETIME
+func (s *session) responseTimeout(r *Request) time.Duration {
+	timeout := s.params.NonLifetime()
 	if r.Timeout > 0 {
-		w.timeout = r.Timeout
+		timeout = r.Timeout
 	}
-	if r.Confirmable && w.timeout < base.EXCHANGE_LIFETIME {
-		w.timeout = base.EXCHANGE_LIFETIME
+	if lifetime := s.params.ExchangeLifetime(); r.Confirmable && timeout < lifetime {
+		timeout = lifetime
 	}
-	s.runningc <- func() {
+	return timeout
+}
+
+func (s *session) postRequestAndWaitResponse(r *Request) (*Response, error) {
+	w := newResponseWaiter(s.responseTimeout(r))
+	send := func() {
 		if err := s.sendRequestWithResponseWaiter(r, w); err != nil {
-			log.Printf("send request with response waiter: %v", err)
+			s.logger().Log(LevelWarn, "send request", "token", base.TokenString(w.token), "error", err)
 		}
 	}
+	ctx := r.Context()
+	select {
+	case s.runningc <- send:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.donec:
+		return nil, ErrSessionClosed
+	}
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		// 请求先于cancel发送, cancel执行后w必然已完成
+		s.cancelRequest(w, ctx.Err())
+		select {
+		case <-w.done:
+		case <-s.donec:
+			return nil, ErrSessionClosed
+		}
+	case <-s.donec:
+		return nil, ErrSessionClosed
+	}
 	return w.Wait()
 }
 
+// post 将f交由running协程执行, 会话已结束时返回ErrSessionClosed.
+// runningc带有缓冲, 因此先检查donec, 避免会话结束后仍有f被接收.
+func (s *session) post(f func()) error {
+	select {
+	case <-s.donec:
+		return ErrSessionClosed
+	default:
+	}
+	select {
+	case s.runningc <- f:
+		return nil
+	case <-s.donec:
+		return ErrSessionClosed
+	}
+}
+
+// cancelRequest 取消请求的响应等待, 并停止请求的重传及块传输
+func (s *session) cancelRequest(w *responseWaiter, err error) {
+	cancel := func() {
+		if s.respWaiters[w.token] == w {
+			delete(s.respWaiters, w.token)
+			s.stack.Cancel(w.token)
+			w.Done(base.Message{}, err)
+		}
+	}
+	select {
+	case s.runningc <- cancel:
+	case <-s.donec:
+	}
+}
+
 func (s *session) sendRequestWithResponseWaiter(r *Request, w *responseWaiter) (err error) {
 	defer func() {
 		if err != nil {
@@ -578,6 +1030,7 @@ func (s *session) sendRequestWithResponseWaiter(r *Request, w *responseWaiter) (
 
 	// 设置响应等待
 	w.messageID = m.MessageID
+	w.token = m.Token
 	s.respWaiters[m.Token] = w
 
 	return nil
@@ -591,7 +1044,7 @@ func (s *session) makeRequestMessage(r *Request) base.Message {
 		Options:   r.Options,
 		Payload:   r.Payload,
 	}
-	if r.Confirmable {
+	if r.Confirmable || s.stream {
 		m.Type = base.CON
 	}
 	if r.useToken {
@@ -677,7 +1130,7 @@ func (s *session) parseURLFromOptions(options Options) (*url.URL, error) {
 	if !ok {
 		host = s.host
 	}
-	port, ok := options.Get(URIPort).(uint32)
+	port, ok := options.URIPort()
 	if !ok {
 		port = s.port
 	}
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ironzhang/coap/internal/gctable"
 	"github.com/ironzhang/coap/internal/stack/base"
 )
 
@@ -121,7 +122,7 @@ func NewTestSession(w io.Writer, h Handler) *session {
 	if err != nil {
 		panic(err)
 	}
-	return newSession(w, h, nil, la, ra, "coap")
+	return newSession(w, h, nil, la, ra, "coap", sessionConfig{})
 }
 
 func SessionRecvData(t *testing.T, s *session, n int) {
@@ -322,3 +323,114 @@ func TestSessionPostRequestAndWaitAck(t *testing.T) {
 	s.recvData(data)
 	wg.Wait()
 }
+
+func TestUnknownMethod(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	h := HandlerFunc(func(w ResponseWriter, r *Request) { w.Write(r.Payload) })
+	go (&Server{Handler: h}).Serve("coap", ln)
+
+	tests := []struct {
+		method Code
+		status Code
+	}{
+		{method: FETCH, status: Content},
+		{method: PATCH, status: Content},
+		{method: IPATCH, status: Content},
+		{method: 0<<5 | 8, status: MethodNotAllowed},
+		{method: 0<<5 | 31, status: MethodNotAllowed},
+	}
+	client := &Client{Cache: NoCache}
+	for i, tt := range tests {
+		req, err := NewRequest(true, tt.method, "coap://"+ln.LocalAddr().String()+"/test", []byte("x"))
+		if err != nil {
+			t.Fatalf("case%d: new request: %v", i, err)
+		}
+		resp, err := client.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: send request: %v", i, err)
+		}
+		if got, want := resp.Status, tt.status; got != want {
+			t.Errorf("case%d: %s: status: %v != %v", i, tt.method, got, want)
+		}
+	}
+}
+
+func TestSessionCanGC(t *testing.T) {
+	req, err := NewRequest(true, GET, "coap://localhost/temp", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	tests := []struct {
+		lastRecv    time.Duration
+		observation bool
+		gc          bool
+	}{
+		{lastRecv: time.Minute, observation: false, gc: false},
+		{lastRecv: 2 * time.Hour, observation: false, gc: true},
+		{lastRecv: time.Minute, observation: true, gc: false},
+		{lastRecv: 2 * time.Hour, observation: true, gc: false},
+	}
+	for i, tt := range tests {
+		s := &session{lastRecvTime: time.Now().Add(-tt.lastRecv)}
+		if tt.observation {
+			s.observations.add(req)
+		}
+		if got, want := s.CanGC(), tt.gc; got != want {
+			t.Errorf("case%d: can gc: %v != %v", i, got, want)
+		}
+	}
+}
+
+func TestStreamSessionGC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	s := &Server{Handler: TestEchoHandler{}}
+	go s.ServeTCP(ln)
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = base.ReadTCPFrame(conn, 0); err != nil {
+		t.Fatalf("read csm: %v", err)
+	}
+
+	var sess *session
+	s.sessions.Range(func(o gctable.Object) bool {
+		sess = o.(*session)
+		return false
+	})
+	if sess == nil {
+		t.Fatalf("session not found")
+	}
+
+	// 回收会话后服务端关闭链接, 读取协程不会因会话结束而阻塞
+	sess.ExecuteGC()
+	for i := 0; i < 20; i++ {
+		m := base.Message{Code: base.GET, Token: fmt.Sprint(i)}
+		data, err := m.MarshalTCP()
+		if err != nil {
+			t.Fatalf("case%d: marshal: %v", i, err)
+		}
+		if _, err = conn.Write(data); err != nil {
+			break
+		}
+	}
+	for {
+		if _, err = base.ReadTCPFrame(conn, 0); err != nil {
+			break
+		}
+	}
+	if err != io.EOF {
+		t.Errorf("read after gc: %v != %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,105 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/ironzhang/coap/internal/gctable"
+)
+
+// ErrServerClosed Server关闭后, Serve系列方法返回该错误
+var ErrServerClosed = errors.New("coap: Server closed")
+
+// shutdownPollInterval Shutdown检查会话是否空闲的间隔
+const shutdownPollInterval = 20 * time.Millisecond
+
+// Shutdown 优雅关闭Server.
+//
+// Shutdown首先停止接受新的链接及请求(新的请求以ServiceUnavailable拒绝), 然后等待处理中的请求完成,
+// 包括单独响应的确认及未完成的块传输, 最后关闭所有监听器及会话.
+// ctx在等待完成前结束时, Shutdown强制关闭所有会话并返回ctx.Err().
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+	s.closeListeners(true)
+
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	for !s.idle() {
+		select {
+		case <-ctx.Done():
+			s.closeAll()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	s.closeAll()
+	return nil
+}
+
+// Close 立即关闭所有监听器及会话, 不等待处理中的请求.
+func (s *Server) Close() error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+	s.closeAll()
+	return nil
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// trackListener 登记监听器, Server关闭后返回false.
+//
+// stream为true表示监听器可在Shutdown开始时立即关闭, 否则需在所有会话结束后关闭.
+func (s *Server) trackListener(l io.Closer, stream bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[io.Closer]bool)
+	}
+	s.listeners[l] = stream
+	return true
+}
+
+func (s *Server) untrackListener(l io.Closer) {
+	s.mu.Lock()
+	delete(s.listeners, l)
+	s.mu.Unlock()
+}
+
+// closeListeners 关闭监听器, onlyStream为true时只关闭可立即关闭的监听器
+func (s *Server) closeListeners(onlyStream bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for l, stream := range s.listeners {
+		if onlyStream && !stream {
+			continue
+		}
+		l.Close()
+		delete(s.listeners, l)
+	}
+}
+
+func (s *Server) idle() bool {
+	idle := true
+	s.sessions.Range(func(o gctable.Object) bool {
+		idle = o.(*session).idle()
+		return idle
+	})
+	return idle
+}
+
+func (s *Server) closeAll() {
+	s.closeListeners(false)
+	s.sessions.Range(func(o gctable.Object) bool {
+		sess := o.(*session)
+		s.sessions.Remove(sess.Key())
+		sess.closeConn()
+		return true
+	})
+}
@@ -0,0 +1,140 @@
+package coap_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap"
+)
+
+type TestSlowHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h TestSlowHandler) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
+	h.started <- struct{}{}
+	<-h.release
+	w.Write([]byte("done"))
+}
+
+func ServeTestShutdown(t *testing.T, h coap.Handler) (*coap.Server, string, chan error) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	s := &coap.Server{Handler: h}
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve("coap", ln) }()
+	return s, ln.LocalAddr().String(), errc
+}
+
+func SendTestRequest(addr string) (*coap.Response, error) {
+	req, err := coap.NewRequest(true, coap.GET, "coap://"+addr+"/slow", nil)
+	if err != nil {
+		return nil, err
+	}
+	return coap.DefaultClient.SendRequest(req)
+}
+
+func TestServerShutdown(t *testing.T) {
+	h := TestSlowHandler{started: make(chan struct{}, 1), release: make(chan struct{})}
+	s, addr, errc := ServeTestShutdown(t, h)
+
+	type result struct {
+		resp *coap.Response
+		err  error
+	}
+	inflight := make(chan result, 1)
+	go func() {
+		resp, err := SendTestRequest(addr)
+		inflight <- result{resp, err}
+	}()
+	<-h.started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// 关闭中的Server拒绝新的请求
+	resp, err := SendTestRequest(addr)
+	if err != nil {
+		t.Fatalf("send request during shutdown: %v", err)
+	}
+	if got, want := resp.Status, coap.ServiceUnavailable; got != want {
+		t.Errorf("status during shutdown: %v != %v", got, want)
+	}
+
+	// 处理中的请求正常完成
+	close(h.release)
+	r := <-inflight
+	if r.err != nil {
+		t.Fatalf("in-flight request: %v", r.err)
+	}
+	if got, want := string(r.resp.Payload), "done"; got != want {
+		t.Errorf("in-flight payload: %q != %q", got, want)
+	}
+
+	if err = <-shutdown; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if got, want := <-errc, coap.ErrServerClosed; got != want {
+		t.Errorf("serve: %v != %v", got, want)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	h := TestSlowHandler{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(h.release)
+	s, addr, errc := ServeTestShutdown(t, h)
+
+	go SendTestRequest(addr)
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if got, want := s.Shutdown(ctx), context.DeadlineExceeded; got != want {
+		t.Errorf("shutdown: %v != %v", got, want)
+	}
+	if got, want := <-errc, coap.ErrServerClosed; got != want {
+		t.Errorf("serve: %v != %v", got, want)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &coap.Server{Handler: TestCOAPHandler{}}
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeTCP(ln) }()
+
+	conn, err := coap.DefaultClient.Dial("coap+tcp://"+ln.Addr().String(), nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	req, err := coap.NewRequest(true, coap.GET, "coap+tcp://"+ln.Addr().String()+"/echo", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if _, err = conn.SendRequest(req); err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	if got, want := <-errc, coap.ErrServerClosed; got != want {
+		t.Errorf("serve: %v != %v", got, want)
+	}
+	if _, err = conn.SendRequest(req); err == nil {
+		t.Errorf("send request after server closed should fail")
+	}
+	if got, want := s.ListenAndServeTCP("127.0.0.1:0"), coap.ErrServerClosed; got != want {
+		t.Errorf("listen and serve after close: %v != %v", got, want)
+	}
+}
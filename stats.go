@@ -0,0 +1,257 @@
+package coap
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// latencyBounds Handler处理时长直方图的桶上界
+var latencyBounds = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Stats Server或Client的统计快照
+type Stats struct {
+	Sessions        int          // 存活的会话数, Client为链接池中的链接数
+	MessagesIn      MessageStats // 收到的消息
+	MessagesOut     MessageStats // 发出的消息, 包括重传
+	Retransmits     uint64       // CON消息重传次数
+	AckTimeouts     uint64       // 等待ACK超时次数
+	Duplicates      uint64       // 收到的重复CON及NON消息数
+	ResetsSent      uint64       // 发出的RST消息数
+	Block1Transfers uint64       // 发送或接收的Block1块传输数
+	Block2Transfers uint64       // 发送或接收的Block2块传输数
+	CacheHits       uint64       // 由缓存响应的请求数
+	CacheMisses     uint64       // 未命中缓存的请求数
+	HandlerLatency  Histogram    // Handler处理请求的时长
+}
+
+// MessageStats 按类型及代码统计的消息数
+type MessageStats struct {
+	Types map[string]uint64 // 键为CON、NON、ACK或RST
+	Codes map[string]uint64 // 键为c.dd格式的代码, 如0.01、2.05
+}
+
+// Histogram 时长直方图
+type Histogram struct {
+	Bounds []time.Duration // 各桶的上界
+	Counts []uint64        // 各桶的计数(非累计), 最后一个桶记录超过最大上界的样本
+	Count  uint64          // 样本数
+	Sum    time.Duration   // 样本总时长
+}
+
+var messageTypes = [...]string{base.CON: "CON", base.NON: "NON", base.ACK: "ACK", base.RST: "RST"}
+
+// messageCounters 按类型及代码统计消息数
+type messageCounters struct {
+	types [len(messageTypes)]uint64
+	codes [256]uint64
+}
+
+func (c *messageCounters) add(m base.Message) {
+	if int(m.Type) < len(c.types) {
+		atomic.AddUint64(&c.types[m.Type], 1)
+	}
+	atomic.AddUint64(&c.codes[m.Code], 1)
+}
+
+func (c *messageCounters) snapshot() MessageStats {
+	s := MessageStats{Types: make(map[string]uint64), Codes: make(map[string]uint64)}
+	for t, name := range messageTypes {
+		if n := atomic.LoadUint64(&c.types[t]); n > 0 {
+			s.Types[name] = n
+		}
+	}
+	for code := range c.codes {
+		if n := atomic.LoadUint64(&c.codes[code]); n > 0 {
+			s.Codes[fmt.Sprintf("%d.%02d", code>>5, code&0x1f)] = n
+		}
+	}
+	return s
+}
+
+// histogram 并发安全的时长直方图
+type histogram struct {
+	counts [len(latencyBounds) + 1]uint64
+	count  uint64
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBounds), func(i int) bool { return d <= latencyBounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: append([]time.Duration(nil), latencyBounds[:]...),
+		Counts: make([]uint64, len(h.counts)),
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return s
+}
+
+// stats 统计计数, 由Server或Client的所有会话共享, 方法均可在nil上调用
+type stats struct {
+	counters    base.Counters
+	in, out     messageCounters
+	cacheHits   uint64
+	cacheMisses uint64
+	latency     histogram
+}
+
+func (s *stats) recv(m base.Message) {
+	if s != nil {
+		s.in.add(m)
+	}
+}
+
+func (s *stats) send(m base.Message) {
+	if s != nil {
+		s.out.add(m)
+	}
+}
+
+func (s *stats) cache(hit bool) {
+	if s == nil {
+		return
+	}
+	if hit {
+		atomic.AddUint64(&s.cacheHits, 1)
+	} else {
+		atomic.AddUint64(&s.cacheMisses, 1)
+	}
+}
+
+func (s *stats) handled(start time.Time) {
+	if s != nil {
+		s.latency.observe(time.Since(start))
+	}
+}
+
+func (s *stats) stackCounters() *base.Counters {
+	if s == nil {
+		return nil
+	}
+	return &s.counters
+}
+
+func (s *stats) snapshot(sessions int) Stats {
+	return Stats{
+		Sessions:        sessions,
+		MessagesIn:      s.in.snapshot(),
+		MessagesOut:     s.out.snapshot(),
+		Retransmits:     s.counters.Load(base.CounterRetransmit),
+		AckTimeouts:     s.counters.Load(base.CounterAckTimeout),
+		Duplicates:      s.counters.Load(base.CounterDuplicate),
+		ResetsSent:      atomic.LoadUint64(&s.out.types[base.RST]),
+		Block1Transfers: s.counters.Load(base.CounterBlock1Transfer),
+		Block2Transfers: s.counters.Load(base.CounterBlock2Transfer),
+		CacheHits:       atomic.LoadUint64(&s.cacheHits),
+		CacheMisses:     atomic.LoadUint64(&s.cacheMisses),
+		HandlerLatency:  s.latency.snapshot(),
+	}
+}
+
+// WritePrometheus 以Prometheus文本格式输出统计, 指标名以namespace为前缀, 如coap_messages_total.
+func (s *Stats) WritePrometheus(w io.Writer, namespace string) error {
+	bw := bufio.NewWriter(w)
+	name := func(n string) string {
+		if namespace == "" {
+			return n
+		}
+		return namespace + "_" + n
+	}
+
+	metric := name("messages_total")
+	fmt.Fprintf(bw, "# HELP %s Number of CoAP messages by direction and type.\n# TYPE %s counter\n", metric, metric)
+	writeLabeled(bw, metric, "type", "in", s.MessagesIn.Types)
+	writeLabeled(bw, metric, "type", "out", s.MessagesOut.Types)
+
+	metric = name("message_codes_total")
+	fmt.Fprintf(bw, "# HELP %s Number of CoAP messages by direction and code.\n# TYPE %s counter\n", metric, metric)
+	writeLabeled(bw, metric, "code", "in", s.MessagesIn.Codes)
+	writeLabeled(bw, metric, "code", "out", s.MessagesOut.Codes)
+
+	counters := []struct {
+		name  string
+		help  string
+		kind  string
+		value uint64
+	}{
+		{"sessions", "Number of live sessions.", "gauge", uint64(s.Sessions)},
+		{"retransmits_total", "Number of retransmitted confirmable messages.", "counter", s.Retransmits},
+		{"ack_timeouts_total", "Number of confirmable messages that were not acknowledged.", "counter", s.AckTimeouts},
+		{"duplicates_total", "Number of duplicate messages received.", "counter", s.Duplicates},
+		{"resets_sent_total", "Number of reset messages sent.", "counter", s.ResetsSent},
+		{"block1_transfers_total", "Number of Block1 transfers sent or received.", "counter", s.Block1Transfers},
+		{"block2_transfers_total", "Number of Block2 transfers sent or received.", "counter", s.Block2Transfers},
+		{"cache_hits_total", "Number of requests served from the cache.", "counter", s.CacheHits},
+		{"cache_misses_total", "Number of requests not found in the cache.", "counter", s.CacheMisses},
+	}
+	for _, c := range counters {
+		metric = name(c.name)
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric, c.help, metric, c.kind, metric, c.value)
+	}
+
+	metric = name("handler_duration_seconds")
+	h := s.HandlerLatency
+	fmt.Fprintf(bw, "# HELP %s Time spent by handlers serving requests.\n# TYPE %s histogram\n", metric, metric)
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", metric, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", metric, h.Count)
+	fmt.Fprintf(bw, "%s_sum %s\n", metric, strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(bw, "%s_count %d\n", metric, h.Count)
+	return bw.Flush()
+}
+
+// writeLabeled 按标签值排序输出带direction及label标签的计数
+func writeLabeled(w io.Writer, metric, label, direction string, values map[string]uint64) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{direction=%q,%s=%q} %d\n", metric, direction, label, k, values[k])
+	}
+}
+
+// StatsVar 返回可由expvar.Publish发布的统计变量, 如expvar.Publish("coap", coap.StatsVar(server.Stats))
+func StatsVar(f func() Stats) expvar.Var {
+	return expvar.Func(func() interface{} { return f() })
+}
+
+// StatsHandler 返回以Prometheus文本格式输出统计的http.Handler
+func StatsHandler(namespace string, f func() Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s := f()
+		s.WritePrometheus(w, namespace)
+	})
+}
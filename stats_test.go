@@ -0,0 +1,216 @@
+package coap
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+func TestStats(t *testing.T) {
+	EnableCache = true
+	defer func() { EnableCache = false }()
+
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		time.Sleep(2 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	server := &Server{Handler: h}
+	go server.Serve("coap", ln)
+
+	client := &Client{}
+	urlstr := "coap://" + ln.LocalAddr().String()
+	for _, path := range []string{"/a", "/a", "/b"} {
+		req, err := NewRequest(true, GET, urlstr+path, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if _, err = client.SendRequest(req); err != nil {
+			t.Fatalf("send request: %v", err)
+		}
+	}
+
+	ss, cs := server.Stats(), client.Stats()
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "server sessions", got: ss.Sessions, want: 1},
+		{name: "server in CON", got: ss.MessagesIn.Types["CON"], want: uint64(2)},
+		{name: "server in GET", got: ss.MessagesIn.Codes["0.01"], want: uint64(2)},
+		{name: "server out ACK", got: ss.MessagesOut.Types["ACK"], want: uint64(2)},
+		{name: "server out Content", got: ss.MessagesOut.Codes["2.05"], want: uint64(2)},
+		{name: "server handler count", got: ss.HandlerLatency.Count, want: uint64(2)},
+		{name: "server handler <=1ms", got: ss.HandlerLatency.Counts[0], want: uint64(0)},
+		{name: "client sessions", got: cs.Sessions, want: 1},
+		{name: "client out CON", got: cs.MessagesOut.Types["CON"], want: uint64(2)},
+		{name: "client in ACK", got: cs.MessagesIn.Types["ACK"], want: uint64(2)},
+		{name: "client cache hits", got: cs.CacheHits, want: uint64(1)},
+		{name: "client cache misses", got: cs.CacheMisses, want: uint64(2)},
+	}
+	for i, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("case%d: %s: %v != %v", i, tt.name, tt.got, tt.want)
+		}
+	}
+	if ss.HandlerLatency.Sum < 4*time.Millisecond {
+		t.Errorf("handler latency sum: %v < %v", ss.HandlerLatency.Sum, 4*time.Millisecond)
+	}
+
+	var buf bytes.Buffer
+	if err = ss.WritePrometheus(&buf, "coap"); err != nil {
+		t.Fatalf("write prometheus: %v", err)
+	}
+	for _, line := range []string{
+		`coap_messages_total{direction="in",type="CON"} 2`,
+		`coap_message_codes_total{direction="out",code="2.05"} 2`,
+		`coap_sessions 1`,
+		`coap_handler_duration_seconds_bucket{le="0.001"} 0`,
+		`coap_handler_duration_seconds_bucket{le="+Inf"} 2`,
+		`coap_handler_duration_seconds_count 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("prometheus output has no line %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestStatsRetransmit(t *testing.T) {
+	// 不响应任何请求的对端
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+
+	c := &Client{TransmissionParams: &TransmissionParams{AckTimeout: 20 * time.Millisecond, AckRandomFactor: 1, MaxRetransmit: 2}}
+	req, err := NewRequest(true, GET, "coap://"+ln.LocalAddr().String()+"/silent", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if _, err = c.SendRequest(req); err != ErrTimeout {
+		t.Fatalf("send request: %v != %v", err, ErrTimeout)
+	}
+
+	s := c.Stats()
+	if got, want := s.Retransmits, uint64(1); got != want {
+		t.Errorf("retransmits: %d != %d", got, want)
+	}
+	if got, want := s.AckTimeouts, uint64(1); got != want {
+		t.Errorf("ack timeouts: %d != %d", got, want)
+	}
+	if got, want := s.MessagesOut.Types["CON"], uint64(2); got != want {
+		t.Errorf("out CON: %d != %d", got, want)
+	}
+}
+
+func TestStatsBlocksAndResets(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Method == GET {
+			w.Write(data)
+			return
+		}
+		w.WriteCode(Changed)
+	})
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	defer ln.Close()
+	server := &Server{Handler: h}
+	go server.Serve("coap", ln)
+
+	client := &Client{Cache: NoCache}
+	urlstr := "coap://" + ln.LocalAddr().String() + "/data"
+	for _, method := range []Code{GET, PUT, PUT} {
+		var payload []byte
+		if method == PUT {
+			payload = data
+		}
+		req, err := NewRequest(true, method, urlstr, payload)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if _, err = client.SendRequest(req); err != nil {
+			t.Fatalf("send request: %v", err)
+		}
+	}
+
+	// 服务端以RST拒绝未订阅的观察通知
+	conn, err := net.Dial("udp", ln.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	m := base.Message{Type: base.CON, Code: base.Content, MessageID: 1, Token: "unknown"}
+	m.SetOption(base.Observe, uint32(2))
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	conn.Write(b)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 64)); err != nil {
+		t.Fatalf("read rst: %v", err)
+	}
+
+	// 去重层以RST拒绝与NON消息ID重复的CON消息
+	for i, typ := range []uint8{base.NON, base.CON} {
+		m = base.Message{Type: typ, Code: base.PUT, MessageID: 2, Token: "put"}
+		if b, err = m.Marshal(); err != nil {
+			t.Fatalf("case%d: marshal: %v", i, err)
+		}
+		conn.Write(b)
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("case%d: read: %v", i, err)
+		}
+		var resp base.Message
+		if err = resp.Unmarshal(buf[:n]); err != nil {
+			t.Fatalf("case%d: unmarshal: %v", i, err)
+		}
+		if typ == base.CON && resp.Type != base.RST {
+			t.Fatalf("case%d: type: %d != %d", i, resp.Type, base.RST)
+		}
+	}
+
+	ss, cs := server.Stats(), client.Stats()
+	tests := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{name: "server block1 transfers", got: ss.Block1Transfers, want: 2},
+		{name: "server block2 transfers", got: ss.Block2Transfers, want: 1},
+		{name: "server resets sent", got: ss.ResetsSent, want: 2},
+		{name: "client block1 transfers", got: cs.Block1Transfers, want: 2},
+		{name: "client block2 transfers", got: cs.Block2Transfers, want: 1},
+		{name: "client resets sent", got: cs.ResetsSent, want: 0},
+	}
+	for i, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("case%d: %s: %d != %d", i, tt.name, tt.got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = ss.WritePrometheus(&buf, "coap"); err != nil {
+		t.Fatalf("write prometheus: %v", err)
+	}
+	for _, line := range []string{"coap_resets_sent_total 2", "coap_block1_transfers_total 2", "coap_block2_transfers_total 1"} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("prometheus output has no line %q:\n%s", line, buf.String())
+		}
+	}
+}
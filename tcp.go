@@ -0,0 +1,274 @@
+package coap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ironzhang/coap/internal/stack/base"
+)
+
+// streamMaxMessageSize 可靠传输链接可接收的最大消息长度, 通过CSM告知对端
+const streamMaxMessageSize = 64 * 1024
+
+// streamBlockOverhead 按对端的最大消息长度确定块大小时, 为消息头及选项预留的长度.
+// 默认的最大消息长度1152恰好容纳1024字节的块(RFC 8323 5.3.1).
+const streamBlockOverhead = base.DEFAULT_MAX_MESSAGE_SIZE - 1024
+
+var errMessageTooLarge = errors.New("message exceeds peer's Max-Message-Size")
+
+// isStreamScheme 判断scheme是否使用可靠传输(RFC 8323)
+func isStreamScheme(scheme string) bool {
+	switch scheme {
+	case "coap+tcp", "coaps+tcp", "coap+ws", "coaps+ws":
+		return true
+	default:
+		return false
+	}
+}
+
+// isWebSocketScheme 判断scheme是否基于WebSocket传输
+func isWebSocketScheme(scheme string) bool {
+	return scheme == "coap+ws" || scheme == "coaps+ws"
+}
+
+// defaultPort 返回scheme的默认端口
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "coaps", "coaps+tcp":
+		return "5684"
+	case "coap+ws":
+		return "80"
+	case "coaps+ws":
+		return "443"
+	default:
+		return "5683"
+	}
+}
+
+// streamConn 可靠传输链接, 负责消息帧的读写及信令消息的处理.
+//
+// 基于WebSocket的链接每个消息占用一个二进制帧, 消息中不包含长度字段.
+type streamConn struct {
+	net.Conn
+	ws                 *websocket.Conn
+	mu                 sync.Mutex
+	closed             int64
+	peerMaxMessageSize uint32
+
+	pingMu sync.Mutex
+	pings  map[string]chan struct{} // 等待Pong的Ping, 以token为键
+}
+
+func newStreamConn(conn net.Conn) *streamConn {
+	return &streamConn{Conn: conn, peerMaxMessageSize: base.DEFAULT_MAX_MESSAGE_SIZE}
+}
+
+func newWebSocketConn(ws *websocket.Conn) *streamConn {
+	ws.SetReadLimit(streamMaxMessageSize)
+	return &streamConn{Conn: ws.UnderlyingConn(), ws: ws, peerMaxMessageSize: base.DEFAULT_MAX_MESSAGE_SIZE}
+}
+
+// Write 写入一个完整的消息帧, 可被多个协程并发调用. 超过对端最大消息长度的消息不会被发送.
+func (c *streamConn) Write(p []byte) (int, error) {
+	if uint32(len(p)) > atomic.LoadUint32(&c.peerMaxMessageSize) {
+		return 0, errMessageTooLarge
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ws != nil {
+		if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+// Close 发送Release信令后关闭链接
+func (c *streamConn) Close() error {
+	if atomic.CompareAndSwapInt64(&c.closed, 0, 1) {
+		c.writeMessage(base.Message{Code: base.Release})
+		if c.ws != nil {
+			msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		}
+		return c.Conn.Close()
+	}
+	return nil
+}
+
+func (c *streamConn) writeMessage(m base.Message) error {
+	var data []byte
+	var err error
+	if c.ws != nil {
+		data, err = m.MarshalWS()
+	} else {
+		data, err = m.MarshalTCP()
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(data)
+	return err
+}
+
+// readFrame 读取一个完整的消息帧, WebSocket链接忽略r
+func (c *streamConn) readFrame(r io.Reader) ([]byte, error) {
+	if c.ws != nil {
+		typ, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if typ != websocket.BinaryMessage {
+			return nil, errors.New("unexpected websocket message type")
+		}
+		return data, nil
+	}
+	return base.ReadTCPFrame(r, streamMaxMessageSize)
+}
+
+func (c *streamConn) unmarshal(m *base.Message, frame []byte) error {
+	if c.ws != nil {
+		return m.UnmarshalWS(frame)
+	}
+	return m.UnmarshalTCP(frame)
+}
+
+func (c *streamConn) sendCSM() error {
+	m := base.Message{Code: base.CSM}
+	m.SetOption(base.MaxMessageSize, uint32(streamMaxMessageSize))
+	m.SetOption(base.BlockWiseTransfer, struct{}{})
+	return c.writeMessage(m)
+}
+
+func (c *streamConn) sendAbort(diagnostic string) error {
+	m := base.Message{Code: base.Abort, Payload: []byte(diagnostic)}
+	return c.writeMessage(m)
+}
+
+// ping 发送Ping信令并等待对端的Pong(RFC 8323 5.4)
+func (c *streamConn) ping(ctx context.Context, token string, donec <-chan struct{}) error {
+	ch := make(chan struct{})
+	c.pingMu.Lock()
+	if c.pings == nil {
+		c.pings = make(map[string]chan struct{})
+	}
+	c.pings[token] = ch
+	c.pingMu.Unlock()
+	defer func() {
+		c.pingMu.Lock()
+		delete(c.pings, token)
+		c.pingMu.Unlock()
+	}()
+
+	if err := c.writeMessage(base.Message{Code: base.Ping, Token: token}); err != nil {
+		return err
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-donec:
+		return ErrSessionClosed
+	}
+}
+
+// handleSignal 处理信令消息, 返回false表示链接需要关闭
+func (c *streamConn) handleSignal(sess *session, m base.Message) bool {
+	switch m.Code {
+	case base.CSM:
+		if v, ok := m.GetOption(base.MaxMessageSize).(uint32); ok {
+			atomic.StoreUint32(&c.peerMaxMessageSize, v)
+			sess.setPeerMaxMessageSize(v)
+		}
+	case base.Ping:
+		pong := base.Message{Code: base.Pong, Token: m.Token}
+		if err := c.writeMessage(pong); err != nil {
+			sess.logger().Log(LevelWarn, "send pong", "error", err)
+		}
+	case base.Pong:
+		c.pingMu.Lock()
+		if ch, ok := c.pings[m.Token]; ok {
+			delete(c.pings, m.Token)
+			close(ch)
+		}
+		c.pingMu.Unlock()
+	case base.Release, base.Abort:
+		return false
+	default:
+		sess.logger().Log(LevelWarn, "unknown signal", "code", base.CodeName(m.Code))
+	}
+	return true
+}
+
+// serve 读取链接上的消息并交由session处理, 直至链接关闭
+func (c *streamConn) serve(sess *session) error {
+	if err := c.sendCSM(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(c.Conn)
+	for {
+		frame, err := c.readFrame(r)
+		if err != nil {
+			if err == io.EOF || atomic.LoadInt64(&c.closed) != 0 {
+				return nil
+			}
+			return err
+		}
+
+		var m base.Message
+		if err = c.unmarshal(&m, frame); err != nil {
+			if e, ok := err.(base.BadOptionsError); ok && e.BadOptions() {
+				sess.recvStreamMessage(m, err)
+				continue
+			}
+			c.sendAbort(err.Error())
+			return err
+		}
+		if base.IsSignal(m.Code) {
+			// 信令消息同样表明链接存活, 仅有Ping/Pong的链接不会被回收
+			sess.lastRecvTimeUpdate()
+			if !c.handleSignal(sess, m) {
+				return nil
+			}
+			continue
+		}
+		sess.recvStreamMessage(m, nil)
+	}
+}
+
+// setPeerMaxMessageSize 按对端的最大消息长度减小发送负载的块大小, 块大小不小于16字节
+func (s *session) setPeerMaxMessageSize(n uint32) {
+	size := s.params.MaxBlockSize
+	for size > 16 && size+streamBlockOverhead > n {
+		size /= 2
+	}
+	atomic.StoreUint32(&s.blockSizeV, size)
+	s.post(func() { s.stack.SetMaxBlockSize(size) })
+}
+
+// ping 在可靠传输链接上发送Ping并等待Pong
+func (s *session) ping(ctx context.Context) error {
+	c, ok := s.writer.(*streamConn)
+	if !ok {
+		return ErrPingNotSupported
+	}
+	return c.ping(ctx, s.genToken(), s.donec)
+}
+
+// dialTCP 建立coap+tcp或coaps+tcp链接
+func dialTCP(scheme, address string, config *tls.Config) (net.Conn, error) {
+	if scheme == "coaps+tcp" {
+		return tls.Dial("tcp", address, config)
+	}
+	return net.Dial("tcp", address)
+}
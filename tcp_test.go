@@ -0,0 +1,207 @@
+package coap_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ironzhang/coap"
+	"github.com/ironzhang/coap/internal/stack/base"
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
+)
+
+func ServeTestTCP(t *testing.T, config *tls.Config) (addr string, closer func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &coap.Server{Handler: TestCOAPHandler{}}
+	if config == nil {
+		go s.ServeTCP(ln)
+	} else {
+		go s.ServeTLS(ln, config)
+	}
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestTCPClient(t *testing.T) {
+	addr, closer := ServeTestTCP(t, nil)
+	defer closer()
+
+	conn, err := coap.DefaultClient.Dial("coap+tcp://"+addr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		confirmable bool
+		method      coap.Code
+		payload     []byte
+	}{
+		{confirmable: true, method: coap.PUT, payload: []byte("hello")},
+		{confirmable: false, method: coap.POST, payload: []byte("hello")},
+		{confirmable: true, method: coap.POST, payload: bytes.Repeat([]byte("0123456789"), 500)},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(tt.confirmable, tt.method, "coap+tcp://"+addr+"/echo", tt.payload)
+		if err != nil {
+			t.Fatalf("case%d: coap new request: %v", i, err)
+		}
+		resp, err := conn.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: coap send request: %v", i, err)
+		}
+		if got, want := resp.Status, coap.Content; got != want {
+			t.Errorf("case%d: response status: %v != %v", i, got, want)
+		}
+		if got, want := string(resp.Payload), string(tt.payload); got != want {
+			t.Errorf("case%d: response payload: %d bytes != %d bytes", i, len(got), len(want))
+		}
+	}
+}
+
+func TestTCPPing(t *testing.T) {
+	addr, closer := ServeTestTCP(t, nil)
+	defer closer()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	ping := base.Message{Code: base.Ping, Token: "ping"}
+	data, err := ping.MarshalTCP()
+	if err != nil {
+		t.Fatalf("marshal ping: %v", err)
+	}
+	if _, err = conn.Write(data); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+
+	// 服务端首先发送CSM, 然后回复Pong
+	want := []uint8{base.CSM, base.Pong}
+	for i, code := range want {
+		frame, err := base.ReadTCPFrame(conn, 0)
+		if err != nil {
+			t.Fatalf("case%d: read frame: %v", i, err)
+		}
+		var m base.Message
+		if err = m.UnmarshalTCP(frame); err != nil {
+			t.Fatalf("case%d: unmarshal: %v", i, err)
+		}
+		if m.Code != code {
+			t.Errorf("case%d: code: %s != %s", i, base.CodeName(m.Code), base.CodeName(code))
+		}
+		if code == base.Pong && m.Token != ping.Token {
+			t.Errorf("case%d: token: %q != %q", i, m.Token, ping.Token)
+		}
+	}
+}
+
+func TestTLSClient(t *testing.T) {
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	addr, closer := ServeTestTCP(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer closer()
+
+	client := &coap.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	req, err := coap.NewRequest(true, coap.GET, "coaps+tcp://"+addr+"/echo", []byte("secure"))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := client.SendRequest(req)
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if got, want := string(resp.Payload), "secure"; got != want {
+		t.Errorf("payload: %q != %q", got, want)
+	}
+}
+
+func TestTCPPeerMaxMessageSize(t *testing.T) {
+	addr, closer := ServeTestTCP(t, nil)
+	defer closer()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	// 告知服务端只能接收300字节的消息, 服务端以128字节的块响应
+	csm := base.Message{Code: base.CSM}
+	csm.SetOption(base.MaxMessageSize, uint32(300))
+	req := base.Message{Code: base.POST, Token: "large", Payload: bytes.Repeat([]byte("0123456789"), 100)}
+	req.SetOption(base.URIPath, "echo")
+	for _, m := range []base.Message{csm, req} {
+		data, err := m.MarshalTCP()
+		if err != nil {
+			t.Fatalf("marshal %s: %v", base.CodeName(m.Code), err)
+		}
+		if _, err = conn.Write(data); err != nil {
+			t.Fatalf("write %s: %v", base.CodeName(m.Code), err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		frame, err := base.ReadTCPFrame(conn, 0)
+		if err != nil {
+			t.Fatalf("case%d: read frame: %v", i, err)
+		}
+		var m base.Message
+		if err = m.UnmarshalTCP(frame); err != nil {
+			t.Fatalf("case%d: unmarshal: %v", i, err)
+		}
+		if m.Code == base.CSM {
+			continue
+		}
+		if len(frame) > 300 {
+			t.Errorf("case%d: frame length %d exceeds 300", i, len(frame))
+		}
+		if opt, ok := base.ParseBlock2Option(m); !ok || opt.Num != 0 || !opt.More || opt.Size != 128 {
+			t.Errorf("case%d: block2: %v %t", i, opt, ok)
+		}
+		if got, want := string(m.Payload), string(req.Payload[:128]); got != want {
+			t.Errorf("case%d: payload: %q != %q", i, got, want)
+		}
+	}
+}
+
+func TestTCPClientPing(t *testing.T) {
+	addr, closer := ServeTestTCP(t, nil)
+	defer closer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn, err := coap.DefaultClient.Dial("coap+tcp://"+addr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err = conn.Ping(ctx); err != nil {
+		t.Errorf("conn ping: %v", err)
+	}
+	if err = coap.DefaultClient.Ping(ctx, "coap+tcp://"+addr); err != nil {
+		t.Errorf("client ping: %v", err)
+	}
+
+	// 数据报链接不支持Ping信令
+	udp, err := coap.DefaultClient.Dial("coap://"+addr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial udp: %v", err)
+	}
+	defer udp.Close()
+	if err = udp.Ping(ctx); err != coap.ErrPingNotSupported {
+		t.Errorf("udp ping: %v != %v", err, coap.ErrPingNotSupported)
+	}
+}
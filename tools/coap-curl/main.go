@@ -25,6 +25,7 @@ type Args struct {
 	OutFile       string
 	Method        coap.Code
 	URL           string
+	Trace         int
 }
 
 func ParseMethod(s string) (coap.Code, error) {
@@ -37,6 +38,12 @@ func ParseMethod(s string) (coap.Code, error) {
 		return coap.PUT, nil
 	case "DELETE":
 		return coap.DELETE, nil
+	case "FETCH":
+		return coap.FETCH, nil
+	case "PATCH":
+		return coap.PATCH, nil
+	case "IPATCH":
+		return coap.IPATCH, nil
 	default:
 		return 0, fmt.Errorf("unknown coap method: %v", s)
 	}
@@ -57,8 +64,8 @@ func (a *Args) Parse() error {
 	flag.StringVar(&a.Data, "data", "", "data")
 	flag.StringVar(&a.InFile, "in-file", "", "in file")
 	flag.StringVar(&a.OutFile, "out-file", "", "out file")
-	flag.StringVar(&method, "X", "GET", "method")
-	flag.IntVar(&coap.Verbose, "verbose", 0, "verbose")
+	flag.StringVar(&method, "X", "GET", "method: GET, POST, PUT, DELETE, FETCH, PATCH or iPATCH")
+	flag.IntVar(&a.Trace, "verbose", 0, "message trace mode: 0 off, 1 brief, 2 full, 3 json")
 	flag.Parse()
 
 	a.Method, err = ParseMethod(method)
@@ -162,7 +169,8 @@ func main() {
 	}
 	coap.PrintRequest(os.Stdout, req, true)
 
-	resp, err := coap.DefaultClient.SendRequest(req)
+	client := coap.Client{Trace: coap.TraceMode(args.Trace)}
+	resp, err := client.SendRequest(req)
 	if err != nil {
 		fmt.Printf("send request: %v\n", err)
 		return
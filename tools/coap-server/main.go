@@ -18,26 +18,16 @@ type Server struct {
 }
 
 func (s *Server) ListenAndServe(address string) error {
-	s.Server.Handler = s
+	mux := coap.NewServeMux()
+	mux.HandleFunc("/TestConRequest", s.TestConOrNonRequest)
+	mux.HandleFunc("/TestNonRequest", s.TestConOrNonRequest)
+	mux.HandleFunc("/TestBlock", s.TestBlock)
+	mux.HandleFunc("/TestCache", s.TestCache)
+	mux.HandleFunc("/TestDeduplication", s.TestDeduplication)
+	s.Server.Handler = mux
 	return s.Server.ListenAndServe(address)
 }
 
-func (s *Server) ServeCOAP(w coap.ResponseWriter, r *coap.Request) {
-	switch r.URL.Path {
-	case "/TestConRequest", "/TestNonRequest":
-		s.TestConOrNonRequest(w, r)
-	case "/TestBlock":
-		s.TestBlock(w, r)
-	case "/TestCache":
-		s.TestCache(w, r)
-	case "/TestDeduplication":
-		s.TestDeduplication(w, r)
-	default:
-		w.WriteCode(coap.NotFound)
-		fmt.Fprintf(w, "%q path not found", r.URL.Path)
-	}
-}
-
 func (s *Server) TestConOrNonRequest(w coap.ResponseWriter, r *coap.Request) {
 	coap.PrintRequest(os.Stdout, r, true)
 	w.Write(r.Payload)
@@ -73,11 +63,13 @@ func (s *Server) TestDeduplication(w coap.ResponseWriter, r *coap.Request) {
 
 func main() {
 	var addr string
+	var trace int
 	flag.StringVar(&addr, "addr", ":5683", "address")
-	flag.IntVar(&coap.Verbose, "verbose", 0, "verbose")
+	flag.IntVar(&trace, "verbose", 0, "message trace mode: 0 off, 1 brief, 2 full, 3 json")
 	flag.Parse()
 
 	var s Server
+	s.Trace = coap.TraceMode(trace)
 	log.Printf("listen and serve on %q", addr)
 	if err := s.ListenAndServe(addr); err != nil {
 		log.Fatalf("listen and serve: %v", err)
@@ -15,6 +15,9 @@ const (
 	POST   Code = base.POST
 	PUT    Code = base.PUT
 	DELETE Code = base.DELETE
+	FETCH  Code = base.FETCH  // 以负载描述查询条件的GET, 安全且可缓存
+	PATCH  Code = base.PATCH  // 部分更新, 不幂等
+	IPATCH Code = base.IPATCH // 幂等的部分更新
 )
 
 // Responses Codes
@@ -34,9 +37,11 @@ const (
 	MethodNotAllowed         Code = base.MethodNotAllowed
 	NotAcceptable            Code = base.NotAcceptable
 	RequestEntityIncomplete  Code = base.RequestEntityIncomplete
+	Conflict                 Code = base.Conflict
 	PreconditionFailed       Code = base.PreconditionFailed
 	RequestEntityTooLarge    Code = base.RequestEntityTooLarge
 	UnsupportedContentFormat Code = base.UnsupportedContentFormat
+	UnprocessableEntity      Code = base.UnprocessableEntity
 
 	InternalServerError  Code = base.InternalServerError
 	NotImplemented       Code = base.NotImplemented
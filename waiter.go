@@ -6,22 +6,21 @@ import (
 	"github.com/ironzhang/coap/internal/stack/base"
 )
 
-const defaultResponseTimeout = 20 * time.Second
-
 type responseWaiter struct {
 	done      chan struct{}
 	start     time.Time
 	timeout   time.Duration
 	messageID uint16
+	token     string
 	err       error
 	msg       base.Message
 }
 
-func newResponseWaiter() *responseWaiter {
+func newResponseWaiter(timeout time.Duration) *responseWaiter {
 	return &responseWaiter{
 		done:    make(chan struct{}),
 		start:   time.Now(),
-		timeout: defaultResponseTimeout,
+		timeout: timeout,
 	}
 }
 
@@ -9,7 +9,7 @@ import (
 )
 
 func TestResponseWaiterReturnNil(t *testing.T) {
-	w := newResponseWaiter()
+	w := newResponseWaiter(time.Second)
 	time.AfterFunc(10*time.Millisecond, func() { w.Done(base.Message{Code: base.Created, Token: "1"}, nil) })
 	resp, err := w.Wait()
 	if err != nil {
@@ -24,7 +24,7 @@ func TestResponseWaiterReturnNil(t *testing.T) {
 }
 
 func TestResponseWaiterReturnErr(t *testing.T) {
-	w := newResponseWaiter()
+	w := newResponseWaiter(time.Second)
 	time.AfterFunc(10*time.Millisecond, func() { w.Done(base.Message{}, io.EOF) })
 	_, err := w.Wait()
 	if err != io.EOF {
@@ -33,8 +33,7 @@ func TestResponseWaiterReturnErr(t *testing.T) {
 }
 
 func TestResponseWaiterTimeout(t *testing.T) {
-	w := newResponseWaiter()
-	w.timeout = 200 * time.Millisecond
+	w := newResponseWaiter(200 * time.Millisecond)
 	if got, want := w.Timeout(), false; got != want {
 		t.Errorf("first: %v != %v", got, want)
 	}
@@ -0,0 +1,60 @@
+package coap
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketPath COAP over WebSocket的默认访问路径
+const WebSocketPath = "/.well-known/coap"
+
+// webSocketProtocol COAP over WebSocket的子协议名
+const webSocketProtocol = "coap"
+
+// ServeHTTP 将HTTP请求升级为WebSocket链接并提供COAP服务(coap+ws), 通常注册在WebSocketPath上.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown() {
+		http.Error(w, ErrServerClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{webSocketProtocol},
+		CheckOrigin:  s.CheckOrigin,
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger().Log(LevelWarn, "websocket upgrade", "peer", r.RemoteAddr, "error", err)
+		return
+	}
+	if ws.Subprotocol() != webSocketProtocol {
+		s.logger().Log(LevelWarn, "websocket unsupported subprotocol", "peer", ws.RemoteAddr(), "subprotocol", ws.Subprotocol())
+		ws.Close()
+		return
+	}
+
+	scheme := "coap+ws"
+	if r.TLS != nil {
+		scheme = "coaps+ws"
+	}
+	s.serveStreamConn(scheme, newWebSocketConn(ws))
+}
+
+// dialWebSocket 建立coap+ws或coaps+ws链接
+func dialWebSocket(u *url.URL, config *tls.Config) (*streamConn, error) {
+	wsurl := url.URL{Scheme: "ws", Host: u.Host, Path: WebSocketPath}
+	if u.Scheme == "coaps+ws" {
+		wsurl.Scheme = "wss"
+	}
+	dialer := websocket.Dialer{
+		Subprotocols:    []string{webSocketProtocol},
+		TLSClientConfig: config,
+	}
+	ws, _, err := dialer.Dial(wsurl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return newWebSocketConn(ws), nil
+}
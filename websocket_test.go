@@ -0,0 +1,125 @@
+package coap_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ironzhang/coap"
+)
+
+func ServeTestWebSocket(secure bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle(coap.WebSocketPath, &coap.Server{Handler: TestCOAPHandler{}})
+	if secure {
+		return httptest.NewTLSServer(mux)
+	}
+	return httptest.NewServer(mux)
+}
+
+func TestWebSocketClient(t *testing.T) {
+	ts := ServeTestWebSocket(false)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	conn, err := coap.DefaultClient.Dial("coap+ws://"+addr, nil, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		method  coap.Code
+		payload []byte
+	}{
+		{method: coap.PUT, payload: []byte("hello")},
+		{method: coap.POST, payload: bytes.Repeat([]byte("0123456789"), 300)},
+	}
+	for i, tt := range tests {
+		req, err := coap.NewRequest(true, tt.method, "coap+ws://"+addr+"/echo", tt.payload)
+		if err != nil {
+			t.Fatalf("case%d: coap new request: %v", i, err)
+		}
+		resp, err := conn.SendRequest(req)
+		if err != nil {
+			t.Fatalf("case%d: coap send request: %v", i, err)
+		}
+		if got, want := resp.Status, coap.Content; got != want {
+			t.Errorf("case%d: response status: %v != %v", i, got, want)
+		}
+		if got, want := string(resp.Payload), string(tt.payload); got != want {
+			t.Errorf("case%d: response payload: %d bytes != %d bytes", i, len(got), len(want))
+		}
+	}
+}
+
+func TestSecureWebSocketClient(t *testing.T) {
+	ts := ServeTestWebSocket(true)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "https://")
+
+	client := &coap.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	req, err := coap.NewRequest(true, coap.GET, "coaps+ws://"+addr+"/echo", []byte("secure"))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := client.SendRequest(req)
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if got, want := string(resp.Payload), "secure"; got != want {
+		t.Errorf("payload: %q != %q", got, want)
+	}
+}
+
+func TestWebSocketWithoutSubprotocol(t *testing.T) {
+	ts := ServeTestWebSocket(false)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + coap.WebSocketPath)
+	if err != nil {
+		t.Fatalf("http get: %v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("status code: %d != %d", got, want)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		check  func(r *http.Request) bool
+		origin string
+		ok     bool
+	}{
+		{check: nil, origin: "", ok: true},
+		{check: nil, origin: "http://example.com", ok: false},
+		{check: func(r *http.Request) bool { return r.Header.Get("Origin") == "http://example.com" }, origin: "http://example.com", ok: true},
+		{check: func(r *http.Request) bool { return r.Header.Get("Origin") == "http://example.com" }, origin: "http://other.com", ok: false},
+	}
+	for i, tt := range tests {
+		mux := http.NewServeMux()
+		mux.Handle(coap.WebSocketPath, &coap.Server{Handler: TestCOAPHandler{}, CheckOrigin: tt.check})
+		ts := httptest.NewServer(mux)
+
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		dialer := websocket.Dialer{Subprotocols: []string{"coap"}}
+		ws, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+coap.WebSocketPath, header)
+		if got, want := err == nil, tt.ok; got != want {
+			t.Errorf("case%d: upgrade: %v", i, err)
+		}
+		if err == nil {
+			ws.Close()
+		} else if resp != nil && resp.StatusCode != http.StatusForbidden {
+			t.Errorf("case%d: status code: %d != %d", i, resp.StatusCode, http.StatusForbidden)
+		}
+		ts.Close()
+	}
+}